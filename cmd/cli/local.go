@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+	"github.com/derekhassan/transcribe-to-notion/internal/pipeline"
+)
+
+// localRunner runs the transcription pipeline in this process, using a
+// Notion integration token and the OpenAI key from the environment.
+type localRunner struct {
+	pipeline    *pipeline.Pipeline
+	user        models.User
+	notionToken string
+
+	// reporters maps job IDs to the report function of the file being
+	// processed, for the pipeline's status callback.
+	reporters sync.Map
+}
+
+func (lr *localRunner) statusChanged(job models.Job) {
+	if isTerminal(job.Status) {
+		return
+	}
+
+	if report, ok := lr.reporters.Load(job.ID); ok {
+		report.(func(string))(job.Status)
+	}
+}
+
+func (lr *localRunner) databases() ([]database, error) {
+	results, err := pipeline.SearchSharedDatabases(lr.notionToken)
+	if err != nil {
+		return nil, err
+	}
+
+	databases := make([]database, 0, len(results))
+	for _, result := range results {
+		db := database{Id: result.Id, Emoji: result.Icon.Emoji}
+		if len(result.Title) > 0 {
+			db.Title = result.Title[0].Text.Content
+		}
+		databases = append(databases, db)
+	}
+
+	return databases, nil
+}
+
+func (lr *localRunner) process(f inputFile, databaseId string, previous *manifestEntry, report func(string)) (manifestEntry, error) {
+	entry := manifestEntry{Path: f.path, Sha256: f.sha256}
+
+	// A job left unfinished by an interrupted run already has the audio
+	// stored, so process it again rather than creating another.
+	var job models.Job
+	if previous != nil && previous.resumable() {
+		var err error
+		job, err = lr.pipeline.Jobs.Get(previous.JobId)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			return entry, err
+		}
+	}
+
+	if job.ID != "" && !isTerminal(job.Status) {
+		report("resuming job " + job.ID)
+	} else {
+		audio, err := os.ReadFile(f.path)
+		if err != nil {
+			return entry, err
+		}
+
+		job, err = lr.pipeline.CreateJob(lr.user, databaseId, filepath.Base(f.path), audio, pipeline.Options{})
+		if err != nil {
+			return entry, err
+		}
+		report("created job " + job.ID)
+	}
+
+	lr.reporters.Store(job.ID, report)
+	defer lr.reporters.Delete(job.ID)
+
+	entry.JobId = job.ID
+	entry.Status = job.Status
+	err := f.manifest.record(entry)
+	if err != nil {
+		return entry, err
+	}
+
+	job, _ = lr.pipeline.Process(job, lr.notionToken)
+
+	entry.Status = job.Status
+	entry.NotionPageUrl = job.NotionPageURL
+	entry.Error = job.Error
+
+	return entry, nil
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+	"github.com/derekhassan/transcribe-to-notion/internal/pipeline"
+	_ "modernc.org/sqlite"
+)
+
+type config struct {
+	server      string
+	token       string
+	local       bool
+	dsn         string
+	storageDir  string
+	mockOpenAI  bool
+	database    string
+	manifest    string
+	concurrency int
+	verbose     bool
+	ffmpeg      string
+}
+
+type database struct {
+	Id    string `json:"id"`
+	Title string `json:"title"`
+	Emoji string `json:"emoji"`
+}
+
+type inputFile struct {
+	path     string
+	sha256   string
+	manifest *manifest
+}
+
+// runner is implemented by the API client and the in-process pipeline.
+type runner interface {
+	databases() ([]database, error)
+	process(f inputFile, databaseId string, previous *manifestEntry, report func(string)) (manifestEntry, error)
+}
+
+func main() {
+	var cfg config
+
+	flag.StringVar(&cfg.server, "server", envOrDefault("TRANSCRIBE_SERVER", "http://localhost:4000"), "Server URL")
+	flag.StringVar(&cfg.token, "token", os.Getenv("TRANSCRIBE_API_TOKEN"), "Personal API token (defaults to $TRANSCRIBE_API_TOKEN)")
+	flag.BoolVar(&cfg.local, "local", false, "Run the pipeline in-process using $NOTION_TOKEN and $OPENAI_API_KEY instead of a server")
+	flag.StringVar(&cfg.dsn, "dsn", "transcribe-cli.db", "SQLite database file for -local")
+	flag.StringVar(&cfg.storageDir, "storageDir", "./data", "Directory for uploads with -local")
+	flag.BoolVar(&cfg.mockOpenAI, "mockOpenAI", false, "Mock OpenAI requests with local file outputs with -local")
+	flag.StringVar(&cfg.database, "database", "", "Notion database ID or title (prompts when omitted)")
+	flag.StringVar(&cfg.manifest, "manifest", "transcribe-manifest.json", "Results manifest; files already completed in it are skipped")
+	flag.IntVar(&cfg.concurrency, "concurrency", 2, "Number of files processed at once")
+	flag.BoolVar(&cfg.verbose, "v", false, "Log pipeline details to stderr with -local")
+	flag.StringVar(&cfg.ffmpeg, "ffmpeg", "", "Path to ffmpeg with -local, to convert audio formats Whisper doesn't accept")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <file or directory>...\n\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if flag.NArg() == 0 || cfg.concurrency < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	r, err := newRunner(cfg)
+	if err == nil {
+		err = run(cfg, r, flag.Args())
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(cfg config, r runner, args []string) error {
+	paths, err := collectFiles(args)
+	if err != nil {
+		return err
+	}
+
+	if len(paths) == 0 {
+		return errors.New("no audio files found")
+	}
+
+	m, err := loadManifest(cfg.manifest)
+	if err != nil {
+		return err
+	}
+
+	databaseId, err := chooseDatabase(r, cfg.database)
+	if err != nil {
+		return err
+	}
+
+	var (
+		out    sync.Mutex
+		wg     sync.WaitGroup
+		failed int
+		sem    = make(chan struct{}, cfg.concurrency)
+	)
+
+	printf := func(format string, a ...any) {
+		out.Lock()
+		defer out.Unlock()
+		fmt.Printf(format, a...)
+	}
+
+	for i, path := range paths {
+		label := fmt.Sprintf("[%d/%d] %s", i+1, len(paths), path)
+		report := func(message string) {
+			printf("%s: %s\n", label, message)
+		}
+
+		sha, err := hashFile(path)
+		if err != nil {
+			report(err.Error())
+			out.Lock()
+			failed++
+			out.Unlock()
+			continue
+		}
+
+		var previous *manifestEntry
+		if entry, ok := m.lookup(sha); ok {
+			if entry.Status == models.JobCompleted {
+				report("already processed, skipping (" + entry.NotionPageUrl + ")")
+				continue
+			}
+			previous = &entry
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			entry, err := r.process(inputFile{path: path, sha256: sha, manifest: m}, databaseId, previous, report)
+			if err != nil {
+				// Once the job has been submitted it carries on without us,
+				// so keep the status the server last reported for the next
+				// run to resume from.
+				if entry.JobId == "" {
+					entry.Status = models.JobFailed
+				}
+				entry.ClientError = err.Error()
+			}
+
+			recordErr := m.record(entry)
+			if recordErr != nil {
+				report("could not update manifest: " + recordErr.Error())
+			}
+
+			out.Lock()
+			defer out.Unlock()
+
+			switch {
+			case entry.Status == models.JobCompleted:
+				fmt.Printf("%s: completed %s\n", label, entry.NotionPageUrl)
+			case entry.resumable():
+				failed++
+				fmt.Printf("%s: stopped waiting for job %s, which the next run will resume: %s\n", label, entry.JobId, entry.ClientError)
+			default:
+				failed++
+				fmt.Printf("%s: failed: %s\n", label, cmp.Or(entry.Error, entry.ClientError))
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	fmt.Printf("%d file(s), %d failed. Results written to %s\n", len(paths), failed, cfg.manifest)
+
+	if failed > 0 {
+		return fmt.Errorf("%d file(s) failed", failed)
+	}
+
+	return nil
+}
+
+func newRunner(cfg config) (runner, error) {
+	if !cfg.local {
+		if cfg.token == "" {
+			return nil, errors.New("an API token is required: pass -token or set TRANSCRIBE_API_TOKEN")
+		}
+
+		return &remoteRunner{
+			server:       normalizeServer(cfg.server),
+			token:        cfg.token,
+			client:       &http.Client{Timeout: 10 * time.Minute},
+			pollInterval: 5 * time.Second,
+		}, nil
+	}
+
+	notionToken := os.Getenv("NOTION_TOKEN")
+	if notionToken == "" {
+		return nil, errors.New("NOTION_TOKEN must be set to a Notion integration token when using -local")
+	}
+
+	db, err := sql.Open("sqlite", "file:"+cfg.dsn+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+
+	err = models.Migrate(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// Jobs belong to a user, so the integration token is recorded as a
+	// local pseudo-user.
+	users := &models.UserModel{DB: db}
+	user := models.User{ID: "cli", Name: "Command-line client", AccessToken: notionToken}
+
+	err = users.Upsert(user)
+	if err != nil {
+		return nil, err
+	}
+
+	logOutput := io.Discard
+	if cfg.verbose {
+		logOutput = os.Stderr
+	}
+
+	lr := &localRunner{
+		pipeline: &pipeline.Pipeline{
+			Logger:     slog.New(slog.NewTextHandler(logOutput, &slog.HandlerOptions{Level: slog.LevelDebug})),
+			MockOpenAI: cfg.mockOpenAI,
+			Jobs:       &models.JobModel{DB: db},
+			Storage:    &pipeline.LocalStorage{Dir: cfg.storageDir},
+			Glossary:   &models.GlossaryModel{DB: db},
+			FFmpeg:     cfg.ffmpeg,
+		},
+		user:        user,
+		notionToken: notionToken,
+	}
+	lr.pipeline.OnStatusChange = lr.statusChanged
+
+	return lr, nil
+}
+
+// collectFiles expands directories into the audio files they contain,
+// recursively and in lexical order.
+func collectFiles(args []string) ([]string, error) {
+	var paths []string
+
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+
+		err = filepath.WalkDir(arg, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && slices.Contains(pipeline.AudioExtensions, strings.ToLower(filepath.Ext(path))) {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return paths, nil
+}
+
+// chooseDatabase resolves -database as an ID or title, or asks the user to
+// pick one of the databases shared with the integration.
+func chooseDatabase(r runner, selector string) (string, error) {
+	if isNotionId(selector) {
+		return selector, nil
+	}
+
+	databases, err := r.databases()
+	if err != nil {
+		return "", err
+	}
+
+	if len(databases) == 0 {
+		return "", errors.New("no Notion databases are shared with the integration")
+	}
+
+	if selector != "" {
+		normalized := strings.ReplaceAll(selector, "-", "")
+		for _, db := range databases {
+			if strings.ReplaceAll(db.Id, "-", "") == normalized || strings.EqualFold(db.Title, selector) {
+				return db.Id, nil
+			}
+		}
+		return "", fmt.Errorf("no Notion database matches %q", selector)
+	}
+
+	for i, db := range databases {
+		fmt.Printf("%2d) %s %s\n", i+1, db.Emoji, db.Title)
+	}
+
+	fmt.Print("Select a Notion database: ")
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil || n < 1 || n > len(databases) {
+		return "", errors.New("invalid selection")
+	}
+
+	return databases[n-1].Id, nil
+}
+
+// isNotionId reports whether s looks like a Notion object ID, with or without
+// dashes, so it can be used without looking it up.
+func isNotionId(s string) bool {
+	s = strings.ReplaceAll(s, "-", "")
+	if len(s) != 32 {
+		return false
+	}
+
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func isTerminal(status string) bool {
+	return status == models.JobCompleted || status == models.JobFailed
+}
+
+func envOrDefault(key string, defaultValue string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return defaultValue
+}
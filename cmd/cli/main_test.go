@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+)
+
+const testDatabaseId = "0123456789abcdef0123456789abcdef"
+
+// fakeRunner finishes each file with the result set for its name, and
+// records the earlier manifest entry it was given.
+type fakeRunner struct {
+	mu       sync.Mutex
+	results  map[string]fakeResult
+	previous map[string]*manifestEntry
+}
+
+type fakeResult struct {
+	entry manifestEntry
+	err   error
+}
+
+func (fr *fakeRunner) databases() ([]database, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (fr *fakeRunner) process(f inputFile, databaseId string, previous *manifestEntry, report func(string)) (manifestEntry, error) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	name := filepath.Base(f.path)
+	fr.previous[name] = previous
+
+	result := fr.results[name]
+	result.entry.Path = f.path
+	result.entry.Sha256 = f.sha256
+
+	return result.entry, result.err
+}
+
+// writeAudio writes files with distinct contents to a new directory and
+// returns it.
+func writeAudio(t *testing.T, names ...string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for _, name := range names {
+		err := os.WriteFile(filepath.Join(dir, name), []byte("audio of "+name), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestRunRecordsResults(t *testing.T) {
+	dir := writeAudio(t, "done.mp3", "failed.mp3", "interrupted.mp3", "unsent.mp3")
+	cfg := config{manifest: filepath.Join(t.TempDir(), "manifest.json"), database: testDatabaseId, concurrency: 2}
+
+	r := &fakeRunner{
+		previous: map[string]*manifestEntry{},
+		results: map[string]fakeResult{
+			"done.mp3":        {entry: manifestEntry{JobId: "1", Status: models.JobCompleted, NotionPageUrl: "https://notion.so/1"}},
+			"failed.mp3":      {entry: manifestEntry{JobId: "2", Status: models.JobFailed, Error: "invalid audio"}},
+			"interrupted.mp3": {entry: manifestEntry{JobId: "3", Status: models.JobTranscribing}, err: errors.New("connection reset")},
+			"unsent.mp3":      {err: errors.New("connection refused")},
+		},
+	}
+
+	err := run(cfg, r, []string{dir})
+	if err == nil {
+		t.Errorf("run succeeded with failed files")
+	}
+
+	m, err := loadManifest(cfg.manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]manifestEntry{
+		"done.mp3":        {JobId: "1", Status: models.JobCompleted},
+		"failed.mp3":      {JobId: "2", Status: models.JobFailed, Error: "invalid audio"},
+		"interrupted.mp3": {JobId: "3", Status: models.JobTranscribing, ClientError: "connection reset"},
+		"unsent.mp3":      {Status: models.JobFailed, ClientError: "connection refused"},
+	}
+
+	for _, entry := range m.Files {
+		name := filepath.Base(entry.Path)
+		w := want[name]
+		if entry.JobId != w.JobId || entry.Status != w.Status || entry.Error != w.Error || entry.ClientError != w.ClientError {
+			t.Errorf("%s: got %+v; want %+v", name, *entry, w)
+		}
+	}
+}
+
+func TestRunSkipsAndResumes(t *testing.T) {
+	names := []string{"done.mp3", "failed.mp3", "interrupted.mp3", "new.mp3"}
+	dir := writeAudio(t, names...)
+	cfg := config{manifest: filepath.Join(t.TempDir(), "manifest.json"), database: testDatabaseId, concurrency: 1}
+
+	m, err := loadManifest(cfg.manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	earlier := map[string]manifestEntry{
+		"done.mp3":        {JobId: "1", Status: models.JobCompleted},
+		"failed.mp3":      {JobId: "2", Status: models.JobFailed},
+		"interrupted.mp3": {JobId: "3", Status: models.JobTranscribing, ClientError: "connection reset"},
+	}
+	for name, entry := range earlier {
+		// Moving a file doesn't matter, only its contents.
+		entry.Path = filepath.Join("elsewhere", name)
+		entry.Sha256, err = hashFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m.record(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := &fakeRunner{previous: map[string]*manifestEntry{}, results: map[string]fakeResult{}}
+	for _, name := range names {
+		r.results[name] = fakeResult{entry: manifestEntry{JobId: "new", Status: models.JobCompleted}}
+	}
+
+	err = run(cfg, r, []string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := r.previous["done.mp3"]; ok {
+		t.Errorf("processed a file that was already completed")
+	}
+
+	for _, name := range []string{"failed.mp3", "interrupted.mp3"} {
+		previous := r.previous[name]
+		if previous == nil || previous.JobId != earlier[name].JobId {
+			t.Errorf("%s: got earlier entry %+v; want %+v", name, previous, earlier[name])
+		}
+	}
+
+	if previous, ok := r.previous["new.mp3"]; !ok || previous != nil {
+		t.Errorf("new.mp3: got earlier entry %+v, processed %t; want it processed afresh", previous, ok)
+	}
+}
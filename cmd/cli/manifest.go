@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// manifestEntry records what happened to one input file. Entries are matched
+// on the file's SHA-256 so renamed or moved files are still skipped.
+type manifestEntry struct {
+	Path          string    `json:"path"`
+	Sha256        string    `json:"sha256"`
+	JobId         string    `json:"job_id,omitempty"`
+	Status        string    `json:"status"`
+	NotionPageUrl string    `json:"notion_page_url,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	Updated       time.Time `json:"updated"`
+
+	// ClientError is why this client stopped waiting for the job, such as a
+	// network error, as opposed to the job itself failing on the server.
+	ClientError string `json:"client_error,omitempty"`
+}
+
+// resumable reports whether the entry's job was submitted but not seen to
+// finish, so it can be picked up again rather than starting over.
+func (e manifestEntry) resumable() bool {
+	return e.JobId != "" && !isTerminal(e.Status)
+}
+
+type manifest struct {
+	mu    sync.Mutex
+	path  string
+	Files []*manifestEntry `json:"files"`
+}
+
+func loadManifest(path string) (*manifest, error) {
+	m := &manifest{path: path}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return m, nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, m)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *manifest) lookup(sha string) (manifestEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, entry := range m.Files {
+		if entry.Sha256 == sha {
+			return *entry, true
+		}
+	}
+
+	return manifestEntry{}, false
+}
+
+// record inserts or replaces the entry for the file's hash and writes the
+// manifest to disk, so progress survives the client being interrupted.
+func (m *manifest) record(entry manifestEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry.Updated = time.Now().UTC()
+
+	replaced := false
+	for i, existing := range m.Files {
+		if existing.Sha256 == entry.Sha256 {
+			m.Files[i] = &entry
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		m.Files = append(m.Files, &entry)
+	}
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := m.path + ".tmp"
+
+	err = os.WriteFile(tmp, append(b, '\n'), 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, m.path)
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+)
+
+func TestManifestRecordAndLookup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifest.json")
+
+	m, err := loadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := m.lookup("abc"); ok {
+		t.Fatalf("a new manifest has an entry")
+	}
+
+	err = m.record(manifestEntry{Path: "a.mp3", Sha256: "abc", JobId: "job-1", Status: models.JobQueued})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.record(manifestEntry{Path: "b.mp3", Sha256: "def", JobId: "job-2", Status: models.JobCompleted})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Recording the same audio again replaces its entry, even under
+	// another name.
+	err = m.record(manifestEntry{Path: "renamed.mp3", Sha256: "abc", JobId: "job-1", Status: models.JobCompleted, NotionPageUrl: "https://notion.so/page"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reload it to check the entries were written to disk.
+	m, err = loadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.Files) != 2 {
+		t.Errorf("got %d entries; want 2", len(m.Files))
+	}
+
+	entry, ok := m.lookup("abc")
+	if !ok {
+		t.Fatalf("no entry for abc")
+	}
+	if entry.Path != "renamed.mp3" || entry.Status != models.JobCompleted || entry.NotionPageUrl != "https://notion.so/page" {
+		t.Errorf("got %+v; want the latest entry", entry)
+	}
+	if entry.Updated.IsZero() {
+		t.Errorf("the entry has no updated time")
+	}
+
+	if entry, ok := m.lookup("def"); !ok || entry.JobId != "job-2" {
+		t.Errorf("got %+v, %t; want job-2's entry", entry, ok)
+	}
+
+	if _, ok := m.lookup("b.mp3"); ok {
+		t.Errorf("looked an entry up by its path; want only its hash to match")
+	}
+}
+
+func TestManifestEntryResumable(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry manifestEntry
+		want  bool
+	}{
+		{"never submitted", manifestEntry{Status: models.JobFailed, ClientError: "connection refused"}, false},
+		{"queued", manifestEntry{JobId: "job", Status: models.JobQueued}, true},
+		{"interrupted while transcribing", manifestEntry{JobId: "job", Status: models.JobTranscribing, ClientError: "timeout"}, true},
+		{"completed", manifestEntry{JobId: "job", Status: models.JobCompleted}, false},
+		{"failed on the server", manifestEntry{JobId: "job", Status: models.JobFailed, Error: "invalid audio"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.entry.resumable(); got != tt.want {
+				t.Errorf("resumable() = %t; want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type apiError struct {
+	Error struct {
+		Status  int    `json:"status"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type apiJob struct {
+	Id            string `json:"id"`
+	Status        string `json:"status"`
+	NotionPageUrl string `json:"notion_page_url"`
+	Error         string `json:"error"`
+}
+
+// duplicateJobError is returned when the server refuses an upload because
+// the same audio was already submitted to that database, with the job it
+// was submitted as.
+type duplicateJobError struct {
+	message string
+	job     apiJob
+}
+
+func (e *duplicateJobError) Error() string {
+	return e.message
+}
+
+// remoteRunner uploads files to a running server through the JSON API,
+// authenticating with a personal API token.
+type remoteRunner struct {
+	server       string
+	token        string
+	client       *http.Client
+	pollInterval time.Duration
+}
+
+func (rr *remoteRunner) do(req *http.Request, dst any) error {
+	req.Header.Set("Authorization", "Bearer "+rr.token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := rr.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			apiError
+			Job apiJob `json:"job"`
+		}
+		if json.Unmarshal(b, &apiErr) == nil && apiErr.Error.Message != "" {
+			message := fmt.Sprintf("%s: %s", resp.Status, apiErr.Error.Message)
+			if resp.StatusCode == http.StatusConflict && apiErr.Job.Id != "" {
+				return &duplicateJobError{message: message, job: apiErr.Job}
+			}
+			return errors.New(message)
+		}
+		return fmt.Errorf("unexpected response %s", resp.Status)
+	}
+
+	return json.Unmarshal(b, dst)
+}
+
+func (rr *remoteRunner) databases() ([]database, error) {
+	req, err := http.NewRequest("GET", rr.server+"/api/v1/notion/databases", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var body struct {
+		Databases []database `json:"databases"`
+	}
+
+	err = rr.do(req, &body)
+	if err != nil {
+		return nil, err
+	}
+
+	return body.Databases, nil
+}
+
+// progressReader reports every additional 10% of the file read.
+type progressReader struct {
+	r        io.Reader
+	total    int64
+	read     int64
+	reported int64
+	report   func(string)
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	pr.read += int64(n)
+
+	if pr.total > 0 {
+		percent := pr.read * 100 / pr.total
+		if percent >= pr.reported+10 || (percent == 100 && pr.reported != 100) {
+			pr.reported = percent - percent%10
+			pr.report(fmt.Sprintf("uploading %d%%", percent))
+		}
+	}
+
+	return n, err
+}
+
+func (rr *remoteRunner) upload(path string, databaseId string, report func(string)) (apiJob, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return apiJob{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return apiJob{}, err
+	}
+
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+
+	go func() {
+		err := writer.WriteField("notion_database_id", databaseId)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		part, err := writer.CreateFormFile("file", filepath.Base(path))
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		_, err = io.Copy(part, &progressReader{r: f, total: info.Size(), report: report})
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		pw.CloseWithError(writer.Close())
+	}()
+
+	req, err := http.NewRequest("POST", rr.server+"/api/v1/jobs", pr)
+	if err != nil {
+		return apiJob{}, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	var body struct {
+		Job apiJob `json:"job"`
+	}
+
+	err = rr.do(req, &body)
+	if err != nil {
+		return apiJob{}, err
+	}
+
+	return body.Job, nil
+}
+
+func (rr *remoteRunner) getJob(id string) (apiJob, error) {
+	req, err := http.NewRequest("GET", rr.server+"/api/v1/jobs/"+id, nil)
+	if err != nil {
+		return apiJob{}, err
+	}
+
+	var body struct {
+		Job apiJob `json:"job"`
+	}
+
+	err = rr.do(req, &body)
+	if err != nil {
+		return apiJob{}, err
+	}
+
+	return body.Job, nil
+}
+
+// wait polls the job until it completes or fails, reporting each status
+// change along the way.
+func (rr *remoteRunner) wait(job apiJob, report func(string)) (apiJob, error) {
+	lastStatus := job.Status
+
+	for !isTerminal(job.Status) {
+		time.Sleep(rr.pollInterval)
+
+		var err error
+		job, err = rr.getJob(job.Id)
+		if err != nil {
+			return job, err
+		}
+
+		if job.Status != lastStatus && !isTerminal(job.Status) {
+			report(job.Status)
+			lastStatus = job.Status
+		}
+	}
+
+	return job, nil
+}
+
+func (rr *remoteRunner) process(f inputFile, databaseId string, previous *manifestEntry, report func(string)) (manifestEntry, error) {
+	entry := manifestEntry{Path: f.path, Sha256: f.sha256}
+
+	// A job submitted by an earlier, interrupted run is still running on the
+	// server, so pick it up rather than uploading the file again.
+	var job apiJob
+	if previous != nil && previous.resumable() {
+		report("resuming job " + previous.JobId)
+		job = apiJob{Id: previous.JobId, Status: previous.Status}
+	} else {
+		var err error
+		job, err = rr.upload(f.path, databaseId, report)
+
+		// The server already has this audio, perhaps from a run whose
+		// manifest was lost, so follow the job it was submitted as.
+		var duplicate *duplicateJobError
+		switch {
+		case errors.As(err, &duplicate):
+			job = duplicate.job
+			report("already submitted as job " + job.Id)
+		case err != nil:
+			return entry, err
+		default:
+			report("submitted job " + job.Id)
+		}
+	}
+
+	entry.JobId = job.Id
+	entry.Status = job.Status
+	err := f.manifest.record(entry)
+	if err != nil {
+		return entry, err
+	}
+
+	job, err = rr.wait(job, report)
+	if err != nil {
+		return entry, err
+	}
+
+	entry.Status = job.Status
+	entry.NotionPageUrl = job.NotionPageUrl
+	entry.Error = job.Error
+
+	return entry, nil
+}
+
+func normalizeServer(server string) string {
+	return strings.TrimRight(server, "/")
+}
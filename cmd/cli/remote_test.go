@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+)
+
+// fakeServer stands in for the JSON API. Uploads create job "new", or are
+// refused as a duplicate of job duplicateOf if it is set, and every job
+// completes when it is polled.
+type fakeServer struct {
+	mu          sync.Mutex
+	uploads     int
+	polled      []string
+	duplicateOf string
+}
+
+func (fs *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer ttn_test" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var job apiJob
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/jobs":
+		fs.uploads++
+		if fs.duplicateOf != "" {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]any{
+				"error": map[string]any{"status": http.StatusConflict, "message": "this audio has already been submitted"},
+				"job":   apiJob{Id: fs.duplicateOf, Status: models.JobTranscribing},
+			})
+			return
+		}
+		job = apiJob{Id: "new", Status: models.JobQueued}
+	case r.Method == http.MethodGet:
+		id := filepath.Base(r.URL.Path)
+		fs.polled = append(fs.polled, id)
+		job = apiJob{Id: id, Status: models.JobCompleted, NotionPageUrl: "https://notion.so/" + id}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{"job": job})
+}
+
+func TestRemoteRunnerResumes(t *testing.T) {
+	dir := writeAudio(t, "audio.mp3")
+	path := filepath.Join(dir, "audio.mp3")
+
+	tests := []struct {
+		name        string
+		previous    *manifestEntry
+		wantUploads int
+		wantJob     string
+	}{
+		{"new file", nil, 1, "new"},
+		{"still running", &manifestEntry{JobId: "earlier", Status: models.JobSummarizing}, 0, "earlier"},
+		{"never submitted", &manifestEntry{Status: models.JobFailed, ClientError: "connection refused"}, 1, "new"},
+		{"failed on the server", &manifestEntry{JobId: "earlier", Status: models.JobFailed}, 1, "new"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := &fakeServer{}
+			server := httptest.NewServer(fs)
+			defer server.Close()
+
+			rr := &remoteRunner{server: server.URL, token: "ttn_test", client: server.Client()}
+
+			m, err := loadManifest(filepath.Join(t.TempDir(), "manifest.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			entry, err := rr.process(inputFile{path: path, sha256: "abc", manifest: m}, testDatabaseId, tt.previous, func(string) {})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if fs.uploads != tt.wantUploads {
+				t.Errorf("uploaded %d times; want %d", fs.uploads, tt.wantUploads)
+			}
+			if len(fs.polled) != 1 || fs.polled[0] != tt.wantJob {
+				t.Errorf("polled jobs %v; want %s", fs.polled, tt.wantJob)
+			}
+			if entry.JobId != tt.wantJob || entry.Status != models.JobCompleted {
+				t.Errorf("got %+v; want job %s completed", entry, tt.wantJob)
+			}
+		})
+	}
+}
+
+func TestRemoteRunnerFollowsDuplicate(t *testing.T) {
+	dir := writeAudio(t, "audio.mp3")
+
+	fs := &fakeServer{duplicateOf: "earlier"}
+	server := httptest.NewServer(fs)
+	defer server.Close()
+
+	rr := &remoteRunner{server: server.URL, token: "ttn_test", client: server.Client()}
+
+	m, err := loadManifest(filepath.Join(t.TempDir(), "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reports []string
+	entry, err := rr.process(inputFile{path: filepath.Join(dir, "audio.mp3"), sha256: "abc", manifest: m}, testDatabaseId, nil, func(s string) {
+		reports = append(reports, s)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fs.polled) != 1 || fs.polled[0] != "earlier" {
+		t.Errorf("polled jobs %v; want earlier", fs.polled)
+	}
+	if entry.JobId != "earlier" || entry.Status != models.JobCompleted {
+		t.Errorf("got %+v; want job earlier completed", entry)
+	}
+	if !slices.Contains(reports, "already submitted as job earlier") {
+		t.Errorf("reported %q", reports)
+	}
+}
@@ -0,0 +1,709 @@
+package main
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+	"github.com/derekhassan/transcribe-to-notion/internal/pipeline"
+)
+
+type apiJob struct {
+	Id               string    `json:"id"`
+	Status           string    `json:"status"`
+	Filename         string    `json:"filename"`
+	NotionDatabaseId string    `json:"notion_database_id"`
+	NotionPageId     string    `json:"notion_page_id,omitempty"`
+	NotionPageUrl    string    `json:"notion_page_url,omitempty"`
+	Error            string    `json:"error,omitempty"`
+	Review           bool      `json:"review"`
+	Language         string    `json:"language,omitempty"`
+	DetectedLanguage string    `json:"detected_language,omitempty"`
+	Translate        bool      `json:"translate"`
+	SummaryLanguage  string    `json:"summary_language,omitempty"`
+	Template         string    `json:"template,omitempty"`
+	Usage            apiUsage  `json:"usage"`
+	Preprocess       bool      `json:"preprocess"`
+	CompressSilence  bool      `json:"compress_silence"`
+	OriginalSeconds  float64   `json:"original_seconds,omitempty"`
+	ProcessedSeconds float64   `json:"processed_seconds,omitempty"`
+	Diarize          bool      `json:"diarize"`
+	AttachAudio      bool      `json:"attach_audio"`
+	Created          time.Time `json:"created"`
+	Updated          time.Time `json:"updated"`
+}
+
+// templateChoice is how a job's template is chosen when submitting: the
+// key of a built-in template or the ID of a custom one.
+func templateChoice(job models.Job) string {
+	t, err := pipeline.JobTemplate(job)
+	if err != nil {
+		return ""
+	}
+
+	return apiTemplateId(t)
+}
+
+func apiTemplateId(t models.SummaryTemplate) string {
+	if t.Key != "" {
+		return t.Key
+	}
+
+	return strconv.FormatInt(t.ID, 10)
+}
+
+func newApiJob(job models.Job) apiJob {
+	return apiJob{
+		Id:               job.ID,
+		Status:           job.Status,
+		Filename:         job.Filename,
+		NotionDatabaseId: job.NotionDatabaseID,
+		NotionPageId:     job.NotionPageID,
+		NotionPageUrl:    job.NotionPageURL,
+		Error:            job.Error,
+		Review:           job.Review,
+		Language:         job.Language,
+		DetectedLanguage: job.DetectedLanguage,
+		Translate:        job.Translate,
+		SummaryLanguage:  job.SummaryLanguage,
+		Template:         templateChoice(job),
+		Usage:            newApiUsage(job.Usage),
+		Preprocess:       job.Preprocess,
+		CompressSilence:  job.CompressSilence,
+		OriginalSeconds:  job.OriginalSeconds,
+		ProcessedSeconds: job.ProcessedSeconds,
+		Diarize:          job.Diarize,
+		AttachAudio:      job.AttachAudio,
+		Created:          job.Created,
+		Updated:          job.Updated,
+	}
+}
+
+type apiUsage struct {
+	AudioSeconds     float64 `json:"audio_seconds"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
+func newApiUsage(usage models.Usage) apiUsage {
+	return apiUsage{
+		AudioSeconds:     usage.AudioSeconds,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		CostUSD:          usage.Cost,
+	}
+}
+
+type apiMonthlyUsage struct {
+	Month string `json:"month"`
+	Jobs  int    `json:"jobs"`
+	apiUsage
+}
+
+func newApiMonthlyUsage(months []models.MonthlyUsage) []apiMonthlyUsage {
+	results := make([]apiMonthlyUsage, len(months))
+	for i, m := range months {
+		results[i] = apiMonthlyUsage{Month: m.Month, Jobs: m.Jobs, apiUsage: newApiUsage(m.Usage)}
+	}
+	return results
+}
+
+type apiNotionDatabase struct {
+	Id    string `json:"id"`
+	Title string `json:"title"`
+	Emoji string `json:"emoji,omitempty"`
+}
+
+type createJobRequest struct {
+	AudioUrl         string `json:"audio_url"`
+	NotionDatabaseId string `json:"notion_database_id"`
+	Review           bool   `json:"review"`
+	Language         string `json:"language"`
+	Translate        bool   `json:"translate"`
+	SummaryLanguage  string `json:"summary_language"`
+	Template         string `json:"template"`
+	Preprocess       bool   `json:"preprocess"`
+	CompressSilence  bool   `json:"compress_silence"`
+	Diarize          bool   `json:"diarize"`
+	AttachAudio      bool   `json:"attach_audio"`
+	AllowDuplicate   bool   `json:"allow_duplicate"`
+}
+
+func (app *application) apiNotFound(w http.ResponseWriter, r *http.Request) {
+	app.apiError(w, r, http.StatusNotFound, "the requested resource could not be found")
+}
+
+// apiCreateJob accepts either a multipart upload with "file" and
+// "notion_database_id" fields, or a JSON body naming an audio_url to fetch.
+func (app *application) apiCreateJob(w http.ResponseWriter, r *http.Request) {
+	user, _ := app.authenticatedUser(r)
+
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if len(idempotencyKey) > 255 {
+		app.apiError(w, r, http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+		return
+	}
+
+	// Check the quota before reading the upload or fetching audio_url, so
+	// that a user who is over it doesn't keep the server busy with a large
+	// file first. A retry of a request that was already accepted is let
+	// through, as it only replays the job that request created.
+	retry := false
+	if idempotencyKey != "" {
+		var err error
+		retry, err = app.idempotencyKeys.Exists(user.ID, idempotencyKey)
+		if err != nil {
+			app.apiServerError(w, r, err)
+			return
+		}
+	}
+
+	if !retry {
+		err := app.checkQuota(user, models.Job{})
+		if err != nil {
+			if errors.Is(err, errOverQuota) {
+				app.apiError(w, r, http.StatusTooManyRequests, err.Error())
+				return
+			}
+			app.apiServerError(w, r, err)
+			return
+		}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var (
+		notionDatabaseId string
+		filename         string
+		audio            []byte
+		opts             pipeline.Options
+		template         string
+		allowDuplicate   bool
+	)
+
+	switch mediaType {
+	case "multipart/form-data":
+		r.Body = http.MaxBytesReader(w, r.Body, pipeline.MaxUploadSize)
+
+		err := r.ParseMultipartForm(pipeline.MaxUploadSize)
+		if err != nil {
+			app.apiError(w, r, http.StatusBadRequest, "invalid multipart body: "+err.Error())
+			return
+		}
+
+		notionDatabaseId = r.FormValue("notion_database_id")
+
+		flags := map[string]*bool{
+			"review":           &opts.Review,
+			"translate":        &opts.Translate,
+			"preprocess":       &opts.Preprocess,
+			"compress_silence": &opts.CompressSilence,
+			"diarize":          &opts.Diarize,
+			"attach_audio":     &opts.AttachAudio,
+			"allow_duplicate":  &allowDuplicate,
+		}
+
+		for name, flag := range flags {
+			if value := r.FormValue(name); value != "" {
+				*flag, err = strconv.ParseBool(value)
+				if err != nil {
+					app.apiError(w, r, http.StatusBadRequest, name+" must be true or false")
+					return
+				}
+			}
+		}
+		opts.Language = r.FormValue("language")
+		opts.SummaryLanguage = r.FormValue("summary_language")
+		template = r.FormValue("template")
+
+		uploadedFile, handler, err := r.FormFile("file")
+		if err != nil {
+			app.apiError(w, r, http.StatusBadRequest, "a \"file\" field is required")
+			return
+		}
+		defer uploadedFile.Close()
+
+		audio, opts.AudioHash, err = pipeline.ReadAudio(uploadedFile)
+		if err != nil {
+			app.apiServerError(w, r, err)
+			return
+		}
+		filename = handler.Filename
+
+	case "application/json":
+		var input createJobRequest
+
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024*1024))
+		dec.DisallowUnknownFields()
+
+		err := dec.Decode(&input)
+		if err != nil {
+			app.apiError(w, r, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+			return
+		}
+
+		if input.AudioUrl == "" {
+			app.apiError(w, r, http.StatusBadRequest, "audio_url is required")
+			return
+		}
+
+		audio, opts.AudioHash, filename, err = app.downloadAudio(input.AudioUrl)
+		if err != nil {
+			app.apiError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		notionDatabaseId = input.NotionDatabaseId
+		opts.Review = input.Review
+		opts.Language = input.Language
+		opts.Translate = input.Translate
+		opts.SummaryLanguage = input.SummaryLanguage
+		opts.Preprocess = input.Preprocess
+		opts.CompressSilence = input.CompressSilence
+		opts.Diarize = input.Diarize
+		opts.AttachAudio = input.AttachAudio
+		template = input.Template
+		allowDuplicate = input.AllowDuplicate
+
+	default:
+		app.apiError(w, r, http.StatusUnsupportedMediaType, "Content-Type must be multipart/form-data or application/json")
+		return
+	}
+
+	if notionDatabaseId == "" {
+		app.apiError(w, r, http.StatusBadRequest, "notion_database_id is required")
+		return
+	}
+
+	var err error
+	opts.Template, err = app.resolveTemplate(user, template)
+	if err != nil {
+		if errors.Is(err, errUnknownTemplate) {
+			app.apiError(w, r, http.StatusUnprocessableEntity, "unknown template: "+template)
+			return
+		}
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	// A request retried with the same Idempotency-Key gets the job the first
+	// one created. The key is released if no job is created, so a request
+	// that failed can be retried.
+	var createdJobId string
+	if idempotencyKey != "" {
+		requestHash, err := hashJobRequest(notionDatabaseId, filename, opts, allowDuplicate)
+		if err != nil {
+			app.apiServerError(w, r, err)
+			return
+		}
+
+		key, reserved, err := app.idempotencyKeys.Reserve(user.ID, idempotencyKey, requestHash)
+		if err != nil {
+			app.apiServerError(w, r, err)
+			return
+		}
+
+		if !reserved {
+			app.apiReplayJob(w, r, user, key, requestHash)
+			return
+		}
+
+		defer func() {
+			if createdJobId == "" {
+				err := app.idempotencyKeys.Release(user.ID, idempotencyKey)
+				if err != nil {
+					app.logger.Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+				}
+			}
+		}()
+	}
+
+	if !allowDuplicate {
+		duplicate, found, err := app.duplicateJob(user, notionDatabaseId, opts.AudioHash)
+		if err != nil {
+			app.apiServerError(w, r, err)
+			return
+		}
+
+		if found {
+			body := envelope{
+				"error": envelope{
+					"status":  http.StatusConflict,
+					"message": "this audio has already been submitted to that database; set allow_duplicate to process it again",
+				},
+				"job": newApiJob(duplicate),
+			}
+
+			err = app.writeJSON(w, http.StatusConflict, body, nil)
+			if err != nil {
+				app.apiServerError(w, r, err)
+			}
+			return
+		}
+	}
+
+	job, err := app.submitJob(user, notionDatabaseId, filename, audio, opts)
+	if err != nil {
+		if errors.Is(err, pipeline.ErrInvalidAudioFile) || errors.Is(err, pipeline.ErrUnknownLanguage) ||
+			errors.Is(err, pipeline.ErrPreprocessingUnavailable) || errors.Is(err, pipeline.ErrDiarizationUnavailable) {
+			app.apiError(w, r, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		app.apiServerError(w, r, err)
+		return
+	}
+	createdJobId = job.ID
+
+	if idempotencyKey != "" {
+		err = app.idempotencyKeys.SetJob(user.ID, idempotencyKey, job.ID)
+		if err != nil {
+			app.apiServerError(w, r, err)
+			return
+		}
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", "/api/v1/jobs/"+job.ID)
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"job": newApiJob(job)}, headers)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+// hashJobRequest identifies what a job submission asked for, so that an
+// Idempotency-Key reused for a different request can be told apart from a
+// retry.
+func hashJobRequest(notionDatabaseId string, filename string, opts pipeline.Options, allowDuplicate bool) (string, error) {
+	b, err := json.Marshal([]any{notionDatabaseId, filename, opts, allowDuplicate})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// apiReplayJob answers a job submission whose Idempotency-Key has been used
+// before with the job the first request created.
+func (app *application) apiReplayJob(w http.ResponseWriter, r *http.Request, user models.User, key models.IdempotencyKey, requestHash string) {
+	if key.RequestHash != requestHash {
+		app.apiError(w, r, http.StatusUnprocessableEntity, "this Idempotency-Key has already been used for a different request")
+		return
+	}
+
+	if key.JobID == "" {
+		app.apiError(w, r, http.StatusConflict, "a request with this Idempotency-Key is still being handled")
+		return
+	}
+
+	job, err := app.jobs.Get(key.JobID)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", "/api/v1/jobs/"+job.ID)
+	headers.Set("Idempotent-Replayed", "true")
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"job": newApiJob(job)}, headers)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+func (app *application) apiListJobs(w http.ResponseWriter, r *http.Request) {
+	user, _ := app.authenticatedUser(r)
+
+	limit, err := queryInt(r, "limit", 20)
+	if err != nil || limit < 1 || limit > 100 {
+		app.apiError(w, r, http.StatusBadRequest, "limit must be between 1 and 100")
+		return
+	}
+
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		app.apiError(w, r, http.StatusBadRequest, "offset must be a positive integer")
+		return
+	}
+
+	jobs, err := app.jobs.ListForUser(user.ID, limit, offset)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	results := make([]apiJob, 0, len(jobs))
+	for _, job := range jobs {
+		results = append(results, newApiJob(job))
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"jobs": results}, nil)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+// userJob looks up the job named in the path, writing a 404 when it doesn't
+// exist or belongs to another user.
+func (app *application) userJob(w http.ResponseWriter, r *http.Request) (models.Job, bool) {
+	user, _ := app.authenticatedUser(r)
+
+	job, err := app.jobs.Get(r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiNotFound(w, r)
+			return models.Job{}, false
+		}
+		app.apiServerError(w, r, err)
+		return models.Job{}, false
+	}
+
+	if job.UserID != user.ID {
+		app.apiNotFound(w, r)
+		return models.Job{}, false
+	}
+
+	return job, true
+}
+
+func (app *application) apiGetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := app.userJob(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"job": newApiJob(job)}, nil)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+// apiPublishJob approves a job that is waiting for review, publishing its
+// saved summary to Notion.
+func (app *application) apiPublishJob(w http.ResponseWriter, r *http.Request) {
+	user, _ := app.authenticatedUser(r)
+
+	job, ok := app.userJob(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.approveJob(user, job)
+	if err != nil {
+		if errors.Is(err, models.ErrStatusChanged) {
+			app.apiError(w, r, http.StatusConflict, "the job is not waiting for review")
+			return
+		}
+		app.apiServerError(w, r, err)
+		return
+	}
+	job.Status = models.JobPublishing
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"job": newApiJob(job)}, nil)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+type rerunJobRequest struct {
+	NewPage bool `json:"new_page"`
+}
+
+// apiRerunJob processes a completed or failed job again. Its Notion page is
+// updated in place unless the optional JSON body sets new_page.
+func (app *application) apiRerunJob(w http.ResponseWriter, r *http.Request) {
+	user, _ := app.authenticatedUser(r)
+
+	job, ok := app.userJob(w, r)
+	if !ok {
+		return
+	}
+
+	var input rerunJobRequest
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(&input)
+	if err != nil && !errors.Is(err, io.EOF) {
+		app.apiError(w, r, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	job, err = app.rerunJob(user, job, input.NewPage)
+	if err != nil {
+		switch {
+		case errors.Is(err, errOverQuota):
+			app.apiError(w, r, http.StatusTooManyRequests, err.Error())
+		case errors.Is(err, models.ErrStatusChanged):
+			app.apiError(w, r, http.StatusConflict, "only completed or failed jobs can be run again")
+		default:
+			app.apiServerError(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"job": newApiJob(job)}, nil)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+func (app *application) apiJobEvents(w http.ResponseWriter, r *http.Request) {
+	job, ok := app.userJob(w, r)
+	if !ok {
+		return
+	}
+
+	app.streamJobEvents(w, r, job)
+}
+
+func (app *application) apiGetTranscript(w http.ResponseWriter, r *http.Request) {
+	job, ok := app.userJob(w, r)
+	if !ok {
+		return
+	}
+
+	if job.Transcript == "" {
+		app.apiError(w, r, http.StatusNotFound, "the transcript is not available yet")
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"job_id": job.ID, "transcript": job.Transcript}, nil)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+func (app *application) apiGetSummary(w http.ResponseWriter, r *http.Request) {
+	job, ok := app.userJob(w, r)
+	if !ok {
+		return
+	}
+
+	if job.Summary == "" {
+		app.apiError(w, r, http.StatusNotFound, "the summary is not available yet")
+		return
+	}
+
+	var summary pipeline.ResponseSchemaForNotion
+	err := json.Unmarshal([]byte(job.Summary), &summary)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"job_id": job.ID, "summary": summary}, nil)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+func (app *application) apiListNotionDatabases(w http.ResponseWriter, r *http.Request) {
+	user, _ := app.authenticatedUser(r)
+
+	results, err := pipeline.SearchSharedDatabases(user.AccessToken)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	databases := make([]apiNotionDatabase, 0, len(results))
+	for _, result := range results {
+		database := apiNotionDatabase{
+			Id:    result.Id,
+			Emoji: result.Icon.Emoji,
+		}
+		if len(result.Title) > 0 {
+			database.Title = result.Title[0].Text.Content
+		}
+		databases = append(databases, database)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"databases": databases}, nil)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+type apiTemplate struct {
+	Id     string                 `json:"id"`
+	Name   string                 `json:"name"`
+	Custom bool                   `json:"custom"`
+	Fields []models.TemplateField `json:"fields"`
+	Layout string                 `json:"layout"`
+	Order  []string               `json:"order"`
+}
+
+// apiListTemplates lists the built-in templates and the custom ones the user
+// can use, with the IDs to pass as "template" when submitting a job.
+func (app *application) apiListTemplates(w http.ResponseWriter, r *http.Request) {
+	user, _ := app.authenticatedUser(r)
+
+	custom, err := app.templates.ForUser(user.ID)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	templates := []apiTemplate{}
+	for _, t := range slices.Concat(pipeline.BuiltinTemplates, custom) {
+		templates = append(templates, apiTemplate{
+			Id:     apiTemplateId(t),
+			Name:   t.Name,
+			Custom: t.Key == "",
+			Fields: t.Fields,
+			Layout: cmp.Or(t.Layout, pipeline.LayoutClassic),
+			Order:  pipeline.TemplateOrder(t),
+		})
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"templates": templates}, nil)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+func (app *application) apiGetUsage(w http.ResponseWriter, r *http.Request) {
+	user, _ := app.authenticatedUser(r)
+
+	userUsage, err := app.usage.MonthlyForUser(user.ID, usageMonths)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	workspaceUsage, err := app.usage.MonthlyForWorkspace(user.WorkspaceID, usageMonths)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"usage": envelope{
+		"user":      newApiMonthlyUsage(userUsage),
+		"workspace": newApiMonthlyUsage(workspaceUsage),
+	}}, nil)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+func queryInt(r *http.Request, key string, defaultValue int) (int, error) {
+	s := r.URL.Query().Get(key)
+	if s == "" {
+		return defaultValue, nil
+	}
+
+	return strconv.Atoi(s)
+}
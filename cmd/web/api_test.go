@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+	"github.com/derekhassan/transcribe-to-notion/internal/pipeline"
+)
+
+// postJob submits audio to the jobs API as the user, with the
+// Idempotency-Key if one is given.
+func postJob(t *testing.T, app *application, user models.User, idempotencyKey string, databaseId string, audio []byte) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("notion_database_id", databaseId)
+	part, err := writer.CreateFormFile("file", "audio.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(audio)
+	writer.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/jobs", &body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	if idempotencyKey != "" {
+		r.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	r = r.WithContext(context.WithValue(r.Context(), authenticatedUserContextKey, user))
+
+	rr := httptest.NewRecorder()
+	app.apiCreateJob(rr, r)
+	return rr
+}
+
+func TestAPICreateJobReplaysIdempotencyKey(t *testing.T) {
+	app, user := newTestApplication(t)
+
+	audio := []byte("ID3 audio")
+
+	// The request that first used the key created this job.
+	job, err := app.jobs.Insert(models.Job{UserID: user.ID, Filename: "audio.mp3", NotionDatabaseID: "database"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template, err := app.resolveTemplate(user, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, audioHash, err := pipeline.ReadAudio(bytes.NewReader(audio))
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestHash, err := hashJobRequest("database", "audio.mp3", pipeline.Options{Template: template, AudioHash: audioHash}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = app.idempotencyKeys.Reserve(user.ID, "key", requestHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.idempotencyKeys.SetJob(user.ID, "key", job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("retry", func(t *testing.T) {
+		rr := postJob(t, app, user, "key", "database", audio)
+
+		if rr.Code != http.StatusAccepted || rr.Header().Get("Idempotent-Replayed") != "true" {
+			t.Fatalf("got status %d, replayed %q; want %d replayed: %s", rr.Code, rr.Header().Get("Idempotent-Replayed"), http.StatusAccepted, rr.Body)
+		}
+
+		var body struct {
+			Job apiJob `json:"job"`
+		}
+		err := json.NewDecoder(rr.Body).Decode(&body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body.Job.Id != job.ID {
+			t.Errorf("got job %s; want %s", body.Job.Id, job.ID)
+		}
+	})
+
+	tests := []struct {
+		name       string
+		databaseId string
+		audio      []byte
+	}{
+		{"different database", "other-database", audio},
+		{"different audio", "database", []byte("ID3 other audio")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := postJob(t, app, user, "key", tt.databaseId, tt.audio)
+
+			if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "different request") {
+				t.Errorf("got status %d; want %d: %s", rr.Code, http.StatusUnprocessableEntity, rr.Body)
+			}
+		})
+	}
+
+	// Neither request created a job of its own.
+	jobs, err := app.jobs.ListForUser(user.ID, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 {
+		t.Errorf("got %d jobs; want only the first request's", len(jobs))
+	}
+}
@@ -0,0 +1,8 @@
+package main
+
+type contextKey string
+
+const (
+	authenticatedUserContextKey = contextKey("authenticatedUser")
+	apiTokenContextKey          = contextKey("apiToken")
+)
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+)
+
+// jobEventBroker fans out newly recorded job events to the streams that are
+// following each job.
+type jobEventBroker struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan models.JobEvent]struct{}
+}
+
+func newJobEventBroker() *jobEventBroker {
+	return &jobEventBroker{
+		subscribers: make(map[string]map[chan models.JobEvent]struct{}),
+	}
+}
+
+func (b *jobEventBroker) subscribe(jobID string) (chan models.JobEvent, func()) {
+	ch := make(chan models.JobEvent, 16)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subscribers[jobID] == nil {
+		b.subscribers[jobID] = make(map[chan models.JobEvent]struct{})
+	}
+	b.subscribers[jobID][ch] = struct{}{}
+
+	unsubscribe := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, ok := b.subscribers[jobID][ch]; ok {
+			delete(b.subscribers[jobID], ch)
+			close(ch)
+		}
+		if len(b.subscribers[jobID]) == 0 {
+			delete(b.subscribers, jobID)
+		}
+	}
+
+	return ch, unsubscribe
+}
+
+// publish never blocks. A subscriber that has fallen behind is disconnected
+// instead, and its client catches up by reconnecting with Last-Event-ID.
+func (b *jobEventBroker) publish(event models.JobEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers[event.JobID] {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subscribers[event.JobID], ch)
+			close(ch)
+		}
+	}
+}
+
+type jobStatusEvent struct {
+	Status        string `json:"status"`
+	NotionPageUrl string `json:"notion_page_url,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+type jobProgressEvent struct {
+	Stage string `json:"stage"`
+	Done  int    `json:"done"`
+	Total int    `json:"total"`
+}
+
+func (app *application) recordJobEvent(jobID string, eventType string, data any) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		app.logger.Error(err.Error(), "job", jobID)
+		return
+	}
+
+	event, err := app.jobEvents.Insert(jobID, eventType, string(b))
+	if err != nil {
+		app.logger.Error(err.Error(), "job", jobID)
+		return
+	}
+
+	app.jobEventBroker.publish(event)
+}
+
+// jobProgress is the pipeline's OnProgress callback.
+func (app *application) jobProgress(job models.Job, done int, total int) {
+	app.recordJobEvent(job.ID, models.JobEventProgress, jobProgressEvent{
+		Stage: job.Status,
+		Done:  done,
+		Total: total,
+	})
+}
+
+func isTerminalStatus(status string) bool {
+	return status == models.JobCompleted || status == models.JobFailed
+}
+
+func writeServerSentEvent(w http.ResponseWriter, event models.JobEvent) error {
+	if event.ID > 0 {
+		_, err := fmt.Fprintf(w, "id: %d\n", event.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, event.Data)
+	return err
+}
+
+// streamJobEvents writes the job's events as Server-Sent Events, replaying
+// any after the client's Last-Event-ID from the job's latest run before
+// following new ones. The stream ends once the job completes or fails.
+func (app *application) streamJobEvents(w http.ResponseWriter, r *http.Request, job models.Job) {
+	rc := http.NewResponseController(w)
+
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = r.URL.Query().Get("lastEventId")
+	}
+	lastId, _ := strconv.ParseInt(lastEventId, 10, 64)
+
+	// Subscribe before replaying so nothing recorded in between is missed.
+	// Anything delivered twice is skipped by comparing IDs.
+	ch, unsubscribe := app.jobEventBroker.subscribe(job.ID)
+	defer unsubscribe()
+
+	events, err := app.jobEvents.ListAfter(job.ID, lastId)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(w, "retry: 3000\n\n")
+
+	send := func(event models.JobEvent) (bool, error) {
+		lastId = event.ID
+
+		err := writeServerSentEvent(w, event)
+		if err != nil {
+			return false, err
+		}
+
+		err = rc.Flush()
+		if err != nil {
+			return false, err
+		}
+
+		var status jobStatusEvent
+		if event.Type == models.JobEventStatus && json.Unmarshal([]byte(event.Data), &status) == nil {
+			return isTerminalStatus(status.Status), nil
+		}
+
+		return false, nil
+	}
+
+	// A job that was run again starts over from queued, so what happened in
+	// earlier runs isn't replayed.
+	for i := len(events) - 1; i > 0; i-- {
+		var status jobStatusEvent
+		if events[i].Type == models.JobEventStatus && json.Unmarshal([]byte(events[i].Data), &status) == nil &&
+			status.Status == models.JobQueued {
+			events = events[i:]
+			break
+		}
+	}
+
+	for _, event := range events {
+		done, err := send(event)
+		if err != nil || done {
+			return
+		}
+	}
+
+	// Jobs that finished before events were recorded have no history, so
+	// send their final status directly.
+	job, err = app.jobs.Get(job.ID)
+	if err == nil && isTerminalStatus(job.Status) {
+		b, _ := json.Marshal(jobStatusEvent{Status: job.Status, NotionPageUrl: job.NotionPageURL, Error: job.Error})
+		send(models.JobEvent{Type: models.JobEventStatus, Data: string(b)})
+		return
+	}
+
+	rc.Flush()
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			_, err := fmt.Fprint(w, ": keep-alive\n\n")
+			if err != nil || rc.Flush() != nil {
+				return
+			}
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			if event.ID <= lastId {
+				continue
+			}
+
+			done, err := send(event)
+			if err != nil || done {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+)
+
+func TestJobEventBrokerDropsSlowSubscribers(t *testing.T) {
+	b := newJobEventBroker()
+
+	slow, unsubscribeSlow := b.subscribe("job")
+	defer unsubscribeSlow()
+
+	fast, unsubscribeFast := b.subscribe("job")
+	defer unsubscribeFast()
+
+	other, unsubscribeOther := b.subscribe("other")
+	defer unsubscribeOther()
+
+	received := 0
+	for i := range cap(slow) + 1 {
+		b.publish(models.JobEvent{ID: int64(i + 1), JobID: "job"})
+
+		select {
+		case <-fast:
+			received++
+		default:
+			t.Fatalf("event %d wasn't delivered to the subscriber keeping up", i+1)
+		}
+	}
+
+	if received != cap(slow)+1 {
+		t.Errorf("the subscriber keeping up got %d events; want %d", received, cap(slow)+1)
+	}
+
+	// The slow subscriber still gets everything that fitted in its buffer,
+	// then finds its channel closed.
+	for i := range cap(slow) {
+		event, ok := <-slow
+		if !ok || event.ID != int64(i+1) {
+			t.Fatalf("got event %+v, %t; want event %d", event, ok, i+1)
+		}
+	}
+	if _, ok := <-slow; ok {
+		t.Errorf("the slow subscriber's channel is still open")
+	}
+
+	select {
+	case event := <-other:
+		t.Errorf("another job's subscriber got %+v", event)
+	default:
+	}
+
+	// Unsubscribing after being dropped is harmless.
+	unsubscribeSlow()
+	b.publish(models.JobEvent{ID: 100, JobID: "job"})
+	if event := <-fast; event.ID != 100 {
+		t.Errorf("got event %d after the slow subscriber left; want 100", event.ID)
+	}
+}
+
+// recordStatus records a status event for the job, returning its ID.
+func recordStatus(t *testing.T, app *application, jobID string, status string) int64 {
+	t.Helper()
+
+	event, err := app.jobEvents.Insert(jobID, models.JobEventStatus, `{"status":"`+status+`"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return event.ID
+}
+
+// streamEvents streams the job's events after lastEventId until the stream
+// ends, returning the statuses sent.
+func streamEvents(t *testing.T, app *application, job models.Job, lastEventId int64) []string {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, "/jobs/"+job.ID+"/events", nil)
+	if lastEventId > 0 {
+		r.Header.Set("Last-Event-ID", strconv.FormatInt(lastEventId, 10))
+	}
+
+	rr := httptest.NewRecorder()
+	app.streamJobEvents(rr, r, job)
+
+	var statuses []string
+	for _, line := range strings.Split(rr.Body.String(), "\n") {
+		if data, ok := strings.CutPrefix(line, `data: {"status":"`); ok {
+			status, _, _ := strings.Cut(data, `"`)
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses
+}
+
+func TestStreamJobEventsReplaysAfterLastEventID(t *testing.T) {
+	app, user := newTestApplication(t)
+
+	job, err := app.jobs.Insert(models.Job{UserID: user.ID, Filename: "audio.mp3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recordStatus(t, app, job.ID, models.JobQueued)
+	transcribing := recordStatus(t, app, job.ID, models.JobTranscribing)
+	recordStatus(t, app, job.ID, models.JobSummarizing)
+	recordStatus(t, app, job.ID, models.JobCompleted)
+
+	got := streamEvents(t, app, job, 0)
+	want := []string{models.JobQueued, models.JobTranscribing, models.JobSummarizing, models.JobCompleted}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got statuses %v; want %v", got, want)
+	}
+
+	got = streamEvents(t, app, job, transcribing)
+	want = []string{models.JobSummarizing, models.JobCompleted}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("after Last-Event-ID %d got statuses %v; want %v", transcribing, got, want)
+	}
+}
+
+func TestStreamJobEventsFollowsNewEvents(t *testing.T) {
+	app, user := newTestApplication(t)
+
+	job, err := app.jobs.Insert(models.Job{UserID: user.ID, Filename: "audio.mp3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued := recordStatus(t, app, job.ID, models.JobQueued)
+
+	go func() {
+		// Wait for the stream to subscribe.
+		for {
+			app.jobEventBroker.mu.Lock()
+			n := len(app.jobEventBroker.subscribers[job.ID])
+			app.jobEventBroker.mu.Unlock()
+			if n > 0 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		app.recordJobEvent(job.ID, models.JobEventStatus, jobStatusEvent{Status: models.JobTranscribing})
+		app.recordJobEvent(job.ID, models.JobEventStatus, jobStatusEvent{Status: models.JobFailed, Error: "no audio"})
+	}()
+
+	got := streamEvents(t, app, job, queued)
+	want := []string{models.JobTranscribing, models.JobFailed}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got statuses %v; want %v", got, want)
+	}
+}
+
+func TestStreamJobEventsReplaysOnlyTheLatestRun(t *testing.T) {
+	app, user := newTestApplication(t)
+
+	job, err := app.jobs.Insert(models.Job{UserID: user.ID, Filename: "audio.mp3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := recordStatus(t, app, job.ID, models.JobQueued)
+	recordStatus(t, app, job.ID, models.JobTranscribing)
+	recordStatus(t, app, job.ID, models.JobFailed)
+
+	// Run again.
+	recordStatus(t, app, job.ID, models.JobQueued)
+	transcribing := recordStatus(t, app, job.ID, models.JobTranscribing)
+	recordStatus(t, app, job.ID, models.JobCompleted)
+
+	got := streamEvents(t, app, job, 0)
+	want := []string{models.JobQueued, models.JobTranscribing, models.JobCompleted}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got statuses %v; want only the latest run's %v", got, want)
+	}
+
+	// A client that saw the start of the first run skips ahead to the
+	// latest run too.
+	got = streamEvents(t, app, job, first)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("after Last-Event-ID %d got statuses %v; want %v", first, got, want)
+	}
+
+	got = streamEvents(t, app, job, transcribing)
+	want = []string{models.JobCompleted}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("after Last-Event-ID %d got statuses %v; want %v", transcribing, got, want)
+	}
+}
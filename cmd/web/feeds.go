@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/feeds"
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+	"github.com/derekhassan/transcribe-to-notion/internal/pipeline"
+)
+
+func (app *application) pollFeedsEvery(interval time.Duration) {
+	for {
+		subscriptions, err := app.feeds.All()
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+
+		for _, feed := range subscriptions {
+			app.pollFeed(feed)
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// pollFeed queues a job for every episode it hasn't seen before. Episodes
+// that fail to download are retried on the next poll; ones that can never be
+// transcribed are remembered and skipped.
+func (app *application) pollFeed(feed models.Feed) {
+	var title, lastError string
+
+	defer func() {
+		err := app.feeds.Checked(feed.ID, title, lastError)
+		if err != nil {
+			app.logger.Error(err.Error(), "feed", feed.ID)
+		}
+	}()
+
+	parsed, err := feeds.Fetch(app.feedClient, feed.URL)
+	if err != nil {
+		lastError = err.Error()
+		app.logger.Error(lastError, "feed", feed.ID)
+		return
+	}
+	title = parsed.Title
+
+	user, err := app.users.Get(feed.UserID)
+	if err != nil {
+		lastError = err.Error()
+		app.logger.Error(lastError, "feed", feed.ID)
+		return
+	}
+
+	for _, item := range parsed.Items {
+		claimed, err := app.feeds.Claim(feed.ID, item.GUID, item.Title)
+		if err != nil {
+			lastError = err.Error()
+			app.logger.Error(lastError, "feed", feed.ID)
+			return
+		}
+		if !claimed {
+			continue
+		}
+
+		var job models.Job
+
+		audio, hash, _, err := app.downloadAudio(item.EnclosureURL)
+		if err == nil {
+			podcast, _ := pipeline.BuiltinTemplate("podcast")
+
+			job, err = app.submitJob(user, feed.NotionDatabaseID, episodeFilename(item), audio, pipeline.Options{Template: podcast, AudioHash: hash})
+		}
+
+		switch {
+		case err == nil:
+			app.logger.Info("queued podcast episode", "feed", feed.ID, "guid", item.GUID, "job", job.ID)
+
+			err = app.feeds.SetJob(feed.ID, item.GUID, job.ID)
+		case errors.Is(err, pipeline.ErrFileTooLarge), errors.Is(err, pipeline.ErrInvalidAudioFile):
+			// Retrying won't help, so keep the claim.
+			lastError = item.Title + ": " + err.Error()
+			app.logger.Warn("skipping episode: "+err.Error(), "feed", feed.ID, "guid", item.GUID)
+			continue
+		default:
+			lastError = item.Title + ": " + err.Error()
+			app.logger.Error(lastError, "feed", feed.ID)
+
+			err = app.feeds.Release(feed.ID, item.GUID)
+		}
+		if err != nil {
+			lastError = err.Error()
+			app.logger.Error(lastError, "feed", feed.ID)
+			return
+		}
+	}
+}
+
+// subscribeToFeed records a new subscription. Only the latest backfill
+// episodes already in the feed are transcribed; the rest are marked as seen.
+func (app *application) subscribeToFeed(user models.User, feedUrl string, notionDatabaseId string, backfill int) (models.Feed, error) {
+	parsed, err := feeds.Fetch(app.feedClient, feedUrl)
+	if err != nil {
+		return models.Feed{}, err
+	}
+
+	title := parsed.Title
+	if title == "" {
+		title = feedUrl
+	}
+
+	feed, err := app.feeds.Insert(models.Feed{
+		UserID:           user.ID,
+		URL:              feedUrl,
+		Title:            title,
+		NotionDatabaseID: notionDatabaseId,
+	})
+	if err != nil {
+		return models.Feed{}, err
+	}
+
+	skipped := max(len(parsed.Items)-backfill, 0)
+
+	for _, item := range parsed.Items[:skipped] {
+		err = app.feeds.MarkSeen(feed.ID, item.GUID, item.Title)
+		if err != nil {
+			return models.Feed{}, err
+		}
+	}
+
+	if backfill > 0 {
+		go app.pollFeed(feed)
+	}
+
+	return feed, nil
+}
+
+func episodeFilename(item feeds.Item) string {
+	var ext string
+	if u, err := url.Parse(item.EnclosureURL); err == nil {
+		ext = path.Ext(u.Path)
+	}
+
+	title := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '-'
+		}
+		return r
+	}, item.Title)
+
+	if title == "" {
+		title = "Episode"
+	}
+
+	return title + ext
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+	"github.com/derekhassan/transcribe-to-notion/internal/pipeline"
+)
+
+func TestPollFeedQueuesEachEpisodeOnce(t *testing.T) {
+	app, user := newTestApplication(t)
+
+	var (
+		mu        sync.Mutex
+		downloads = map[string]int{}
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/feed.xml" {
+			fmt.Fprintf(w, `<rss><channel><title>Show</title>
+				<item><guid>old</guid><title>Old</title><enclosure url="http://%[1]s/old.mp3"/></item>
+				<item><guid>new</guid><title>New</title><enclosure url="http://%[1]s/new.mp3"/></item>
+			</channel></rss>`, r.Host)
+			return
+		}
+
+		mu.Lock()
+		downloads[r.URL.Path]++
+		mu.Unlock()
+
+		// Slow enough for polls running together to overlap.
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("ID3\x03\x00\x00\x00\x00\x00\x00audio"))
+	}))
+	defer server.Close()
+
+	// The test server is on loopback.
+	app.config.allowPrivateURLs = true
+	app.feedClient = newOutboundClient(5*time.Second, true)
+	app.audioClient = newOutboundClient(5*time.Second, true)
+
+	// Processing fails straight away, as there are no mock OpenAI replies.
+	app.pipeline = &pipeline.Pipeline{
+		Logger:     app.logger,
+		MockOpenAI: true,
+		Jobs:       app.jobs,
+		Storage:    &pipeline.LocalStorage{Dir: t.TempDir()},
+	}
+
+	feed, err := app.feeds.Insert(models.Feed{UserID: user.ID, URL: server.URL + "/feed.xml", Title: "Show", NotionDatabaseID: "database"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The old episode was already in the feed when subscribing.
+	err = app.feeds.MarkSeen(feed.ID, "old", "Old")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A poll started when subscribing can run alongside the scheduled one.
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app.pollFeed(feed)
+		}()
+	}
+	wg.Wait()
+
+	app.pollFeed(feed)
+
+	if downloads["/old.mp3"] != 0 {
+		t.Errorf("downloaded the episode seen when subscribing %d times", downloads["/old.mp3"])
+	}
+	if downloads["/new.mp3"] != 1 {
+		t.Errorf("downloaded the new episode %d times; want once", downloads["/new.mp3"])
+	}
+
+	jobs, err := app.jobs.ListForUser(user.ID, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 {
+		t.Fatalf("queued %d jobs; want 1", len(jobs))
+	}
+
+	var jobId string
+	err = app.feeds.DB.QueryRow(`SELECT job_id FROM feed_items WHERE feed_id = ? AND guid = 'new'`, feed.ID).Scan(&jobId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobId != jobs[0].ID {
+		t.Errorf("the episode was recorded with job %q; want %q", jobId, jobs[0].ID)
+	}
+}
+
+func TestPollFeedRetriesFailedDownloads(t *testing.T) {
+	app, user := newTestApplication(t)
+
+	available := false
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/feed.xml" {
+			fmt.Fprintf(w, `<rss><channel><item><guid>1</guid><enclosure url="http://%s/1.mp3"/></item></channel></rss>`, r.Host)
+			return
+		}
+
+		if !available {
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ID3\x03\x00\x00\x00\x00\x00\x00audio"))
+	}))
+	defer server.Close()
+
+	app.config.allowPrivateURLs = true
+	app.feedClient = newOutboundClient(5*time.Second, true)
+	app.audioClient = newOutboundClient(5*time.Second, true)
+	app.pipeline = &pipeline.Pipeline{
+		Logger:     app.logger,
+		MockOpenAI: true,
+		Jobs:       app.jobs,
+		Storage:    &pipeline.LocalStorage{Dir: t.TempDir()},
+	}
+
+	feed, err := app.feeds.Insert(models.Feed{UserID: user.ID, URL: server.URL + "/feed.xml", NotionDatabaseID: "database"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.pollFeed(feed)
+
+	jobs, err := app.jobs.ListForUser(user.ID, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 0 {
+		t.Fatalf("queued %d jobs when the download failed", len(jobs))
+	}
+
+	available = true
+	app.pollFeed(feed)
+
+	jobs, err = app.jobs.ListForUser(user.ID, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 {
+		t.Errorf("queued %d jobs on the next poll; want 1", len(jobs))
+	}
+}
@@ -1,35 +1,22 @@
 package main
 
 import (
-	"encoding/base64"
 	"encoding/json"
 	"errors"
-	"html/template"
-	"io"
+	"fmt"
 	"net/http"
 	"net/url"
-	"os"
-	"slices"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+	"github.com/derekhassan/transcribe-to-notion/internal/pipeline"
 )
 
 func (app *application) renderHomepage(w http.ResponseWriter, r *http.Request) {
-	files := []string{
-		"./ui/html/base.tmpl",
-		"./ui/html/pages/home.tmpl",
-	}
-
-	ts, err := template.ParseFiles(files...)
-	if err != nil {
-		app.serverError(w, r, err)
-		return
-	}
-
-	err = ts.ExecuteTemplate(w, "base", nil)
-	if err != nil {
-		app.serverError(w, r, err)
-		return
-	}
+	app.render(w, r, http.StatusOK, "home.tmpl", &TemplateData{})
 }
 
 func clearCookie(cookieName string) *http.Cookie {
@@ -55,7 +42,7 @@ func (app *application) home(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	err = getBotDataFromToken(notionAccessToken.Value)
+	err = pipeline.GetBotDataFromToken(notionAccessToken.Value)
 	if err != nil {
 		http.SetCookie(w, clearCookie("notion_token"))
 
@@ -65,111 +52,351 @@ func (app *application) home(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-type TemplateData struct {
-	NotionPages []NotionResult
+func (app *application) uploadForm(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	app.renderUpload(w, r, user, http.StatusOK, "")
+}
+
+func (app *application) renderUpload(w http.ResponseWriter, r *http.Request, user models.User, status int, formError string) {
+	data, err := app.uploadTemplateData(r, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data.FormError = formError
+
+	app.render(w, r, status, "upload.tmpl", data)
 }
 
-func (app *application) uploadForm(w http.ResponseWriter, r *http.Request) {
-	files := []string{
-		"./ui/html/base.tmpl",
-		"./ui/html/pages/upload.tmpl",
+// renderDuplicateUpload shows the upload form again with a link to the job
+// that already transcribed the uploaded audio.
+func (app *application) renderDuplicateUpload(w http.ResponseWriter, r *http.Request, user models.User, duplicate models.Job) {
+	data, err := app.uploadTemplateData(r, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
 	}
+	data.Job = duplicate
+
+	app.render(w, r, http.StatusConflict, "upload.tmpl", data)
+}
 
-	cookie, err := r.Cookie("notion_token")
+func (app *application) uploadTemplateData(r *http.Request, user models.User) (*TemplateData, error) {
+	results, err := pipeline.SearchSharedDatabases(user.AccessToken)
 	if err != nil {
-		if errors.Is(err, http.ErrNoCookie) {
-			http.Redirect(w, r, "/", http.StatusSeeOther)
-			return
+		return nil, err
+	}
+
+	customTemplates, err := app.templates.ForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	data := app.newTemplateData(r)
+	data.NotionPages = results
+	data.Languages = pipeline.Languages
+	data.Templates = pipeline.BuiltinTemplates
+	data.CustomTemplates = customTemplates
+	data.CanDiarize = app.pipeline.Diarizer != nil
+
+	return data, nil
+}
+
+// ownedJob looks up the job named in the path for the signed-in user,
+// writing a 404 if it doesn't exist or belongs to someone else.
+func (app *application) ownedJob(w http.ResponseWriter, r *http.Request, user models.User) (models.Job, bool) {
+	job, err := app.jobs.Get(r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+			return models.Job{}, false
 		}
 		app.serverError(w, r, err)
+		return models.Job{}, false
+	}
+
+	if job.UserID != user.ID {
+		app.clientError(w, http.StatusNotFound)
+		return models.Job{}, false
+	}
+
+	return job, true
+}
+
+func (app *application) jobView(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	job, ok := app.ownedJob(w, r, user)
+	if !ok {
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Job = job
+
+	app.render(w, r, http.StatusOK, "job.tmpl", data)
+}
+
+func (app *application) jobEventStream(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	job, ok := app.ownedJob(w, r, user)
+	if !ok {
+		return
+	}
+
+	app.streamJobEvents(w, r, job)
+}
+
+func (app *application) jobReview(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	job, ok := app.ownedJob(w, r, user)
+	if !ok {
+		return
+	}
+
+	if job.Status != models.JobReview {
+		http.Redirect(w, r, "/jobs/"+job.ID, http.StatusSeeOther)
 		return
 	}
 
-	results, err := searchSharedDatabases(cookie.Value)
+	var summary pipeline.ResponseSchemaForNotion
+	err := json.Unmarshal([]byte(job.Summary), &summary)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
-	ts, err := template.ParseFiles(files...)
+	template, err := pipeline.JobTemplate(job)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
-	err = ts.ExecuteTemplate(w, "base", &TemplateData{
-		NotionPages: results,
-	})
+	speakers, err := pipeline.JobSpeakers(job)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
+
+	data := app.newTemplateData(r)
+	data.Job = job
+	data.Summary = summary
+	data.Paragraphs = strings.Split(summary.LogicalParagraphs, "\n\n")
+	data.Speakers = speakers
+
+	for _, field := range template.Fields {
+		data.ReviewSections = append(data.ReviewSections, reviewSection{
+			Field: field,
+			Text:  strings.Join(summary.Sections[field.Key], "\n"),
+		})
+	}
+
+	app.render(w, r, http.StatusOK, "review.tmpl", data)
 }
 
-func (app *application) uploadSuccessful(w http.ResponseWriter, r *http.Request) {
-	files := []string{
-		"./ui/html/base.tmpl",
-		"./ui/html/pages/transcribe-complete.tmpl",
+// jobReviewSubmit saves the edits from the review page, then either
+// returns to it, regenerates the summary or publishes, depending on which
+// button was pressed.
+func (app *application) jobReviewSubmit(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	job, ok := app.ownedJob(w, r, user)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if job.Status != models.JobReview {
+		app.clientError(w, http.StatusConflict)
+		return
 	}
 
-	ts, err := template.ParseFiles(files...)
+	template, err := pipeline.JobTemplate(job)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
-	err = ts.ExecuteTemplate(w, "base", nil)
+	var summary pipeline.ResponseSchemaForNotion
+	err = json.Unmarshal([]byte(job.Summary), &summary)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
-}
 
-func (app *application) transcribeAndPushToNotionPage(savedPath string, filename string, notionPageId string, notionAccessToken string) {
-	var transcribedText string
+	// Chapters aren't edited on the review page, so they are kept as the
+	// summarizer wrote them.
+	edited := pipeline.ResponseSchemaForNotion{
+		Summary:  strings.TrimSpace(r.PostForm.Get("summary")),
+		Chapters: summary.Chapters,
+		Sections: map[string][]string{},
+	}
+
+	var paragraphs []string
+	for _, paragraph := range r.PostForm["paragraph"] {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph != "" {
+			paragraphs = append(paragraphs, paragraph)
+		}
+	}
+	edited.LogicalParagraphs = strings.Join(paragraphs, "\n\n")
+
+	// Diarized jobs send the speaker of each paragraph alongside it, and any
+	// names given to the speakers.
+	var speakers pipeline.Speakers
+	if labels := r.PostForm["paragraph-speaker"]; len(labels) > 0 {
+		speakers.Names = map[string]string{}
+
+		names := r.PostForm["speaker-name"]
+		for i, label := range r.PostForm["speaker-label"] {
+			if i < len(names) && strings.TrimSpace(names[i]) != "" {
+				speakers.Names[label] = strings.TrimSpace(names[i])
+			}
+		}
+
+		for i, paragraph := range r.PostForm["paragraph"] {
+			paragraph = strings.TrimSpace(paragraph)
+			if paragraph == "" || i >= len(labels) {
+				continue
+			}
+
+			speakers.Turns = append(speakers.Turns, pipeline.SpeakerTurn{
+				Speaker: labels[i],
+				Text:    paragraph,
+			})
+		}
+	}
+
+	for _, field := range template.Fields {
+		entries := []string{}
+
+		for _, entry := range strings.Split(r.PostForm.Get("section-"+field.Key), "\n") {
+			entry = strings.TrimSpace(entry)
+			if entry != "" {
+				entries = append(entries, entry)
+			}
+		}
+
+		edited.Sections[field.Key] = entries
+	}
 
-	transcribedText, err := app.sendTranscriptionToWhisper(savedPath, filename)
+	job, err = app.saveReview(job, edited, speakers)
 	if err != nil {
-		app.logger.Error(err.Error())
+		app.serverError(w, r, err)
 		return
 	}
-	app.logger.Debug("Whisper transcription completed")
 
-	chatResponse, err := app.formatAndSummarizeTranscription(transcribedText)
-	if err != nil {
-		app.logger.Error(err.Error())
+	switch r.PostForm.Get("action") {
+	case "regenerate":
+		err = app.regenerateSummary(job)
+	case "publish":
+		err = app.approveJob(user, job)
+	default:
+		http.Redirect(w, r, "/jobs/"+job.ID+"/review", http.StatusSeeOther)
 		return
 	}
-	app.logger.Debug("Summary completed")
 
-	err = app.createNotionPage(filename, chatResponse, notionPageId, notionAccessToken)
 	if err != nil {
-		app.logger.Error(err.Error())
+		if errors.Is(err, models.ErrStatusChanged) {
+			app.clientError(w, http.StatusConflict)
+			return
+		}
+		app.serverError(w, r, err)
 		return
 	}
-	app.logger.Debug("Notion page created")
+
+	http.Redirect(w, r, "/jobs/"+job.ID, http.StatusSeeOther)
 }
 
-func isValidAudioFile(contentType string) bool {
-	validFileTypes := []string{"audio/mpeg", "video/mp4", "video/mpeg"}
+// jobRerun processes a finished job again, updating its Notion page unless
+// the user asked for a new one.
+func (app *application) jobRerun(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	job, ok := app.ownedJob(w, r, user)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	_, err = app.rerunJob(user, job, r.PostForm.Has("new-page"))
+	if err != nil {
+		switch {
+		case errors.Is(err, errOverQuota):
+			data := app.newTemplateData(r)
+			data.Job = job
+			data.FormError = err.Error()
+			app.render(w, r, http.StatusTooManyRequests, "job.tmpl", data)
+		case errors.Is(err, models.ErrStatusChanged):
+			app.clientError(w, http.StatusConflict)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
 
-	return slices.Contains(validFileTypes, contentType)
+	http.Redirect(w, r, "/jobs/"+job.ID, http.StatusSeeOther)
 }
 
 func (app *application) createTranscription(w http.ResponseWriter, r *http.Request) {
-	notionAccessTokenCookie, err := r.Cookie("notion_token")
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	// Check the quota before reading the upload, so that a user who is over
+	// it isn't kept waiting for a large file to be stored first.
+	err := app.checkQuota(user, models.Job{})
 	if err != nil {
-		if errors.Is(err, http.ErrNoCookie) {
-			app.clientError(w, http.StatusUnauthorized)
+		if errors.Is(err, errOverQuota) {
+			app.renderUpload(w, r, user, http.StatusTooManyRequests, err.Error())
 			return
 		}
 		app.serverError(w, r, err)
 		return
 	}
 
-	r.Body = http.MaxBytesReader(w, r.Body, 25*1024*1024)
+	r.Body = http.MaxBytesReader(w, r.Body, pipeline.MaxUploadSize)
 
-	err = r.ParseMultipartForm(25 * 1024 * 1024)
+	err = r.ParseMultipartForm(pipeline.MaxUploadSize)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
@@ -188,27 +415,73 @@ func (app *application) createTranscription(w http.ResponseWriter, r *http.Reque
 	}
 	defer uploadedFile.Close()
 
-	uploadedBytes, err := io.ReadAll(uploadedFile)
+	uploadedBytes, audioHash, err := pipeline.ReadAudio(uploadedFile)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
-	contentType := http.DetectContentType(uploadedBytes)
-	if !isValidAudioFile(contentType) {
-		app.clientError(w, http.StatusBadRequest)
-		return
+	// Uploading the same recording to the same page again is more often a
+	// mistake than not, so link to the first job unless the user insists.
+	if r.FormValue("allow-duplicate") != "on" {
+		duplicate, found, err := app.duplicateJob(user, notionPageId, audioHash)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		if found {
+			app.renderDuplicateUpload(w, r, user, duplicate)
+			return
+		}
 	}
 
-	savedPath, err := writeToExternalStorage(uploadedBytes, handler.Filename, contentType)
+	template, err := app.resolveTemplate(user, r.FormValue("template"))
 	if err != nil {
+		if errors.Is(err, errUnknownTemplate) {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
 		app.serverError(w, r, err)
 		return
 	}
 
-	go app.transcribeAndPushToNotionPage(savedPath, handler.Filename, notionPageId, notionAccessTokenCookie.Value)
+	opts := pipeline.Options{
+		Template:        template,
+		Review:          r.FormValue("review") == "on",
+		Language:        r.FormValue("language"),
+		Translate:       r.FormValue("translate") == "on",
+		SummaryLanguage: r.FormValue("summary-language"),
+		Preprocess:      r.FormValue("preprocess") == "on",
+		CompressSilence: r.FormValue("compress-silence") == "on",
+		Diarize:         r.FormValue("diarize") == "on",
+		AttachAudio:     r.FormValue("attach-audio") == "on",
+		AudioHash:       audioHash,
+	}
+
+	job, err := app.submitJob(user, notionPageId, handler.Filename, uploadedBytes, opts)
+	if err != nil {
+		if errors.Is(err, pipeline.ErrInvalidAudioFile) {
+			app.renderUpload(w, r, user, http.StatusUnprocessableEntity, "That file isn't audio we can transcribe ("+err.Error()+").")
+			return
+		}
+		if errors.Is(err, pipeline.ErrPreprocessingUnavailable) {
+			app.renderUpload(w, r, user, http.StatusUnprocessableEntity, "Trimming silence isn't available on this server.")
+			return
+		}
+		if errors.Is(err, pipeline.ErrDiarizationUnavailable) {
+			app.renderUpload(w, r, user, http.StatusUnprocessableEntity, "Labelling speakers isn't available on this server.")
+			return
+		}
+		if errors.Is(err, pipeline.ErrUnknownLanguage) {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		app.serverError(w, r, err)
+		return
+	}
 
-	http.Redirect(w, r, "/upload/success", http.StatusSeeOther)
+	http.Redirect(w, r, "/jobs/"+job.ID, http.StatusSeeOther)
 }
 
 func (app *application) notionAuthCallback(w http.ResponseWriter, r *http.Request) {
@@ -230,56 +503,774 @@ func (app *application) notionAuthCallback(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	tokenRequest := &TokenRequest{
-		GrantType:   "authorization_code",
-		Code:        code,
-		RedirectUri: app.config.appUri + "/auth/callback",
+	tokenResponse, err := pipeline.ExchangeOAuthCode(code, app.config.appUri+"/auth/callback")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if tokenResponse.AccessToken == "" {
+		app.serverError(w, r, errors.New("could not get access token"))
+		return
+	}
+
+	if tokenResponse.Owner.User.Id == "" {
+		app.serverError(w, r, errors.New("access token is not owned by a Notion user"))
+		return
+	}
+
+	err = app.users.Upsert(models.User{
+		ID:            tokenResponse.Owner.User.Id,
+		Name:          tokenResponse.Owner.User.Name,
+		Email:         tokenResponse.Owner.User.Person.Email,
+		WorkspaceID:   tokenResponse.WorkspaceId,
+		WorkspaceName: tokenResponse.WorkspaceName,
+		BotID:         tokenResponse.BotId,
+		AccessToken:   tokenResponse.AccessToken,
+	})
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	notionAccessToken := http.Cookie{
+		Name:     "notion_token",
+		Value:    tokenResponse.AccessToken,
+		Path:     "/",
+		MaxAge:   3600,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	http.SetCookie(w, &notionAccessToken)
+	http.Redirect(w, r, "/upload", http.StatusSeeOther)
+}
+
+func (app *application) tokenList(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
 	}
 
-	marshalled, err := json.Marshal(tokenRequest)
+	tokens, err := app.apiTokens.ListForUser(user.ID)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
-	encodedBasicCredentials := generateAuthHeader("basic", base64.StdEncoding.EncodeToString([]byte(os.Getenv("NOTION_CLIENT_ID")+":"+os.Getenv("NOTION_CLIENT_SECRET"))))
-	resp, err := doNotionApiRequest("oauth/token", marshalled, encodedBasicCredentials, "POST")
+	data := app.newTemplateData(r)
+	data.Tokens = tokens
+
+	app.render(w, r, http.StatusOK, "tokens.tmpl", data)
+}
+
+func (app *application) tokenCreate(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(r.PostForm.Get("name"))
+	scopes := r.PostForm["scopes"]
+
+	var formError string
+
+	switch {
+	case name == "":
+		formError = "Give the token a name so you can recognise it later."
+	case utf8.RuneCountInString(name) > 100:
+		formError = "Token names can be at most 100 characters long."
+	case len(scopes) == 0:
+		formError = "Select at least one scope."
+	}
+
+	for _, scope := range scopes {
+		if scope != models.ScopeRead && scope != models.ScopeSubmit {
+			formError = "Unknown scope: " + scope
+		}
+	}
+
+	var plaintext string
+
+	if formError == "" {
+		plaintext, _, err = app.apiTokens.Insert(user.ID, name, scopes)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
 
+	tokens, err := app.apiTokens.ListForUser(user.ID)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
-	b, err := io.ReadAll(resp.Body)
+	data := app.newTemplateData(r)
+	data.Tokens = tokens
+	data.NewToken = plaintext
+	data.FormError = formError
+
+	status := http.StatusOK
+	if formError != "" {
+		status = http.StatusUnprocessableEntity
+	}
+
+	app.render(w, r, status, "tokens.tmpl", data)
+}
+
+func (app *application) tokenRevoke(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = app.apiTokens.Revoke(id, user.ID)
 	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
 		app.serverError(w, r, err)
 		return
 	}
-	defer resp.Body.Close()
 
-	var tokenResponse = &TokenResponse{}
+	http.Redirect(w, r, "/settings/tokens", http.StatusSeeOther)
+}
+
+func (app *application) feedList(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
 
-	err = json.Unmarshal(b, tokenResponse)
+	app.renderFeeds(w, r, user, http.StatusOK, "")
+}
+
+func (app *application) renderFeeds(w http.ResponseWriter, r *http.Request, user models.User, status int, formError string) {
+	subscriptions, err := app.feeds.ListForUser(user.ID)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
-	if tokenResponse.AccessToken == "" {
-		app.serverError(w, r, errors.New("could not get access token"))
+	results, err := pipeline.SearchSharedDatabases(user.AccessToken)
+	if err != nil {
+		app.serverError(w, r, err)
 		return
 	}
 
-	notionAccessToken := http.Cookie{
-		Name:     "notion_token",
-		Value:    tokenResponse.AccessToken,
-		Path:     "/",
-		MaxAge:   3600,
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteLaxMode,
+	data := app.newTemplateData(r)
+	data.Feeds = subscriptions
+	data.NotionPages = results
+	data.FormError = formError
+
+	app.render(w, r, status, "feeds.tmpl", data)
+}
+
+func (app *application) feedCreate(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		app.clientError(w, http.StatusUnauthorized)
+		return
 	}
 
-	http.SetCookie(w, &notionAccessToken)
-	http.Redirect(w, r, "/upload", http.StatusSeeOther)
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	feedUrl := strings.TrimSpace(r.PostForm.Get("url"))
+	notionDatabaseId := r.PostForm.Get("notion-page-id")
+
+	backfill, err := strconv.Atoi(r.PostForm.Get("backfill"))
+	if err != nil || backfill < 0 || backfill > 10 {
+		app.renderFeeds(w, r, user, http.StatusUnprocessableEntity, "Choose between 0 and 10 existing episodes to transcribe.")
+		return
+	}
+
+	_, err = checkOutboundURL(feedUrl, app.config.allowPrivateURLs)
+	if err != nil {
+		if errors.Is(err, errPrivateAddress) {
+			app.renderFeeds(w, r, user, http.StatusUnprocessableEntity, "Feeds on private network addresses can't be subscribed to.")
+			return
+		}
+		app.renderFeeds(w, r, user, http.StatusUnprocessableEntity, "Enter the feed's full http or https URL on a host that can be found.")
+		return
+	}
+
+	if notionDatabaseId == "" {
+		app.renderFeeds(w, r, user, http.StatusUnprocessableEntity, "Select a Notion database.")
+		return
+	}
+
+	_, err = app.subscribeToFeed(user, feedUrl, notionDatabaseId, backfill)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateFeed) {
+			app.renderFeeds(w, r, user, http.StatusUnprocessableEntity, "You are already subscribed to this feed.")
+			return
+		}
+		app.logger.Warn("could not subscribe to feed", "url", feedUrl, "error", err.Error())
+		app.renderFeeds(w, r, user, http.StatusUnprocessableEntity, "Could not read the feed: "+err.Error())
+		return
+	}
+
+	http.Redirect(w, r, "/feeds", http.StatusSeeOther)
+}
+
+func (app *application) feedDelete(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = app.feeds.Delete(id, user.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/feeds", http.StatusSeeOther)
+}
+
+func (app *application) glossaryList(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	app.renderGlossary(w, r, user, http.StatusOK, "")
+}
+
+func (app *application) renderGlossary(w http.ResponseWriter, r *http.Request, user models.User, status int, formError string) {
+	terms, err := app.glossary.ForUser(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.FormError = formError
+
+	for _, term := range terms {
+		if term.UserID == user.ID {
+			data.GlossaryTerms = append(data.GlossaryTerms, term)
+		} else {
+			data.SharedGlossaryTerms = append(data.SharedGlossaryTerms, term)
+		}
+	}
+
+	app.render(w, r, status, "glossary.tmpl", data)
+}
+
+func (app *application) glossaryCreate(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	term := models.GlossaryTerm{
+		UserID:      user.ID,
+		WorkspaceID: user.WorkspaceID,
+		Term:        strings.TrimSpace(r.PostForm.Get("term")),
+		Shared:      r.PostForm.Get("shared") == "on",
+	}
+
+	for _, misspelling := range strings.Split(r.PostForm.Get("misspellings"), ",") {
+		misspelling = strings.TrimSpace(misspelling)
+		if misspelling != "" {
+			term.Misspellings = append(term.Misspellings, misspelling)
+		}
+	}
+
+	switch {
+	case term.Term == "":
+		app.renderGlossary(w, r, user, http.StatusUnprocessableEntity, "Enter the term as it should be spelled.")
+		return
+	case utf8.RuneCountInString(term.Term) > 100:
+		app.renderGlossary(w, r, user, http.StatusUnprocessableEntity, "Terms can be at most 100 characters long.")
+		return
+	}
+
+	_, err = app.glossary.Insert(term)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/settings/glossary", http.StatusSeeOther)
+}
+
+func (app *application) glossaryDelete(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = app.glossary.Delete(id, user.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/settings/glossary", http.StatusSeeOther)
+}
+
+func (app *application) templateList(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	app.renderTemplates(w, r, user, http.StatusOK, "")
+}
+
+func (app *application) renderTemplates(w http.ResponseWriter, r *http.Request, user models.User, status int, formError string) {
+	templates, err := app.templates.ForUser(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Templates = pipeline.BuiltinTemplates
+	data.TemplateBlocks = pipeline.TemplateBlocks
+	data.TemplateLayouts = pipeline.TemplateLayouts
+	data.FormError = formError
+
+	for _, t := range templates {
+		if t.UserID == user.ID {
+			data.CustomTemplates = append(data.CustomTemplates, t)
+		} else {
+			data.SharedTemplates = append(data.SharedTemplates, t)
+		}
+	}
+
+	app.render(w, r, status, "templates.tmpl", data)
+}
+
+// parseTemplateFields reads the fields of a custom template, written one
+// per line as "key | heading | block type | description". The block type
+// defaults to a bulleted list and the description to the heading.
+func parseTemplateFields(s string) ([]models.TemplateField, error) {
+	fields := []models.TemplateField{}
+
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		parts := strings.SplitN(line, "|", 4)
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("%q needs at least a key and a heading", line)
+		}
+
+		field := models.TemplateField{
+			Key:         parts[0],
+			Heading:     parts[1],
+			Block:       pipeline.BlockBulletedList,
+			Description: parts[1],
+		}
+		if len(parts) > 2 && parts[2] != "" {
+			field.Block = parts[2]
+		}
+		if len(parts) > 3 && parts[3] != "" {
+			field.Description = parts[3]
+		}
+
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+func (app *application) templateCreate(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	fields, err := parseTemplateFields(r.PostForm.Get("fields"))
+	if err != nil {
+		app.renderTemplates(w, r, user, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	var order []string
+	for _, section := range strings.Split(r.PostForm.Get("order"), ",") {
+		if section = strings.TrimSpace(section); section != "" {
+			order = append(order, section)
+		}
+	}
+
+	template := models.SummaryTemplate{
+		UserID:      user.ID,
+		WorkspaceID: user.WorkspaceID,
+		Name:        strings.TrimSpace(r.PostForm.Get("name")),
+		Prompt:      strings.TrimSpace(r.PostForm.Get("prompt")),
+		Fields:      fields,
+		Layout:      r.PostForm.Get("layout"),
+		Order:       order,
+		Shared:      r.PostForm.Get("shared") == "on",
+	}
+
+	err = pipeline.ValidateTemplate(template)
+	if err != nil {
+		app.renderTemplates(w, r, user, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	_, err = app.templates.Insert(template)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/settings/templates", http.StatusSeeOther)
+}
+
+func (app *application) templateDelete(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = app.templates.Delete(id, user.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/settings/templates", http.StatusSeeOther)
+}
+
+func (app *application) webhookList(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	app.renderWebhooks(w, r, user, http.StatusOK, "")
+}
+
+func (app *application) renderWebhooks(w http.ResponseWriter, r *http.Request, user models.User, status int, formError string) {
+	webhooks, err := app.webhooks.ListForUser(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	deliveries, err := app.webhooks.RecentDeliveries(user.ID, 50)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Webhooks = webhooks
+	data.WebhookDeliveries = deliveries
+	data.FormError = formError
+
+	app.render(w, r, status, "webhooks.tmpl", data)
+}
+
+func (app *application) webhookCreate(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	webhookUrl := strings.TrimSpace(r.PostForm.Get("url"))
+	events := r.PostForm["events"]
+
+	_, err = checkOutboundURL(webhookUrl, app.config.allowPrivateURLs)
+	if err != nil {
+		if errors.Is(err, errPrivateAddress) {
+			app.renderWebhooks(w, r, user, http.StatusUnprocessableEntity, "Webhooks can't be sent to private network addresses.")
+			return
+		}
+		app.renderWebhooks(w, r, user, http.StatusUnprocessableEntity, "Enter the webhook's full http or https URL on a host that can be found.")
+		return
+	}
+
+	if len(events) == 0 {
+		app.renderWebhooks(w, r, user, http.StatusUnprocessableEntity, "Select at least one event.")
+		return
+	}
+
+	for _, event := range events {
+		if event != models.EventJobCompleted && event != models.EventJobFailed {
+			app.renderWebhooks(w, r, user, http.StatusUnprocessableEntity, "Unknown event: "+event)
+			return
+		}
+	}
+
+	_, err = app.webhooks.Insert(user.ID, webhookUrl, events)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/settings/webhooks", http.StatusSeeOther)
+}
+
+func (app *application) webhookDelete(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = app.webhooks.Delete(id, user.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/settings/webhooks", http.StatusSeeOther)
+}
+
+// usageMonths is how many months of history the usage page and API show.
+const usageMonths = 12
+
+func (app *application) usageView(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	userUsage, err := app.usage.MonthlyForUser(user.ID, usageMonths)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	workspaceUsage, err := app.usage.MonthlyForWorkspace(user.WorkspaceID, usageMonths)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	userQuota, err := app.quotaFor(models.QuotaScopeUser, user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	workspaceQuota, err := app.quotaFor(models.QuotaScopeWorkspace, user.WorkspaceID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.UserUsage = userUsage
+	data.WorkspaceUsage = workspaceUsage
+	data.UserQuota = userQuota
+	data.WorkspaceQuota = workspaceQuota
+
+	app.render(w, r, http.StatusOK, "usage.tmpl", data)
+}
+
+// adminUser returns the signed-in user if they are an admin, writing a
+// 403 otherwise.
+func (app *application) adminUser(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	user, ok := app.authenticatedUser(r)
+	if !ok || !app.isAdmin(user) {
+		app.clientError(w, http.StatusForbidden)
+		return models.User{}, false
+	}
+
+	return user, true
+}
+
+func (app *application) quotaOverrideList(w http.ResponseWriter, r *http.Request) {
+	_, ok := app.adminUser(w, r)
+	if !ok {
+		return
+	}
+
+	app.renderQuotaOverrides(w, r, http.StatusOK, "")
+}
+
+func (app *application) renderQuotaOverrides(w http.ResponseWriter, r *http.Request, status int, formError string) {
+	overrides, err := app.quotaOverrides.All()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.QuotaOverrides = overrides
+	data.UserQuota = app.config.quotas.user
+	data.WorkspaceQuota = app.config.quotas.workspace
+	data.FormError = formError
+
+	app.render(w, r, status, "quotas.tmpl", data)
+}
+
+func (app *application) quotaOverrideSet(w http.ResponseWriter, r *http.Request) {
+	admin, ok := app.adminUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	override := models.QuotaOverride{
+		Scope:     r.PostForm.Get("scope"),
+		SubjectID: strings.TrimSpace(r.PostForm.Get("subject-id")),
+		UpdatedBy: admin.ID,
+	}
+
+	if override.Scope != models.QuotaScopeUser && override.Scope != models.QuotaScopeWorkspace {
+		app.renderQuotaOverrides(w, r, http.StatusUnprocessableEntity, "Choose whether the override is for a user or a workspace.")
+		return
+	}
+
+	if override.SubjectID == "" {
+		app.renderQuotaOverrides(w, r, http.StatusUnprocessableEntity, "Enter the Notion ID of the user or workspace.")
+		return
+	}
+
+	limits := map[string]*int{
+		"minutes-per-month": &override.MinutesPerMonth,
+		"jobs-per-day":      &override.JobsPerDay,
+		"concurrent-jobs":   &override.ConcurrentJobs,
+	}
+
+	for name, limit := range limits {
+		value := strings.TrimSpace(r.PostForm.Get(name))
+		if value == "" {
+			continue
+		}
+
+		*limit, err = strconv.Atoi(value)
+		if err != nil || *limit < 0 {
+			app.renderQuotaOverrides(w, r, http.StatusUnprocessableEntity, "Limits must be whole numbers, or empty for no limit.")
+			return
+		}
+	}
+
+	err = app.quotaOverrides.Set(override)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/quotas", http.StatusSeeOther)
+}
+
+func (app *application) quotaOverrideDelete(w http.ResponseWriter, r *http.Request) {
+	_, ok := app.adminUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.quotaOverrides.Delete(r.PathValue("scope"), r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/quotas", http.StatusSeeOther)
 }
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+	"github.com/derekhassan/transcribe-to-notion/internal/pipeline"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// fakeNotion sends Notion API requests to a test server that creates every
+// page it is asked to and adds any blocks to it.
+func fakeNotion(t *testing.T) {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/pages":
+			json.NewEncoder(w).Encode(map[string]string{"object": "page", "id": "page", "url": "https://notion.so/page"})
+		case r.Method == http.MethodPatch && strings.HasSuffix(r.URL.Path, "/children"):
+			var request struct{ Children []json.RawMessage }
+			json.NewDecoder(r.Body).Decode(&request)
+
+			results := []map[string]string{}
+			for i := range request.Children {
+				results = append(results, map[string]string{"id": fmt.Sprintf("block-%d", i)})
+			}
+			json.NewEncoder(w).Encode(map[string]any{"results": results})
+		default:
+			http.Error(w, `{"message": "not found"}`, http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	target, _ := url.Parse(server.URL)
+
+	transport := http.DefaultTransport
+	http.DefaultTransport = roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		r.URL.Scheme = target.Scheme
+		r.URL.Host = target.Host
+		return transport.RoundTrip(r)
+	})
+	t.Cleanup(func() { http.DefaultTransport = transport })
+}
+
+// waitForJob waits for a job being processed in the background to
+// complete or fail.
+func waitForJob(t *testing.T, app *application, id string) models.Job {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := app.jobs.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status == models.JobCompleted || job.Status == models.JobFailed || time.Now().After(deadline) {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// postReview submits the review form for the job as the user.
+func postReview(app *application, user models.User, job models.Job, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/jobs/"+job.ID+"/review", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetPathValue("id", job.ID)
+	r = r.WithContext(context.WithValue(r.Context(), authenticatedUserContextKey, user))
+
+	rr := httptest.NewRecorder()
+	app.jobReviewSubmit(rr, r)
+	return rr
+}
+
+func TestJobReviewSubmit(t *testing.T) {
+	fakeNotion(t)
+
+	app, user := newTestApplication(t)
+	app.pipeline = &pipeline.Pipeline{Logger: app.logger, Jobs: app.jobs}
+
+	job, err := app.jobs.Insert(models.Job{UserID: user.ID, Filename: "audio.mp3", Review: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Review is only possible once the job has been summarized.
+	rr := postReview(app, user, job, url.Values{"summary": {"Early"}, "action": {"publish"}})
+	if rr.Code != http.StatusConflict {
+		t.Errorf("reviewing a queued job: got status %d; want %d", rr.Code, http.StatusConflict)
+	}
+
+	err = app.jobs.SetSummary(job.ID, `{"summary": "Draft", "chapters": [{"title": "Intro", "start": "0:00", "summary": "Hello"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.jobs.SetStatus(job.ID, models.JobReview)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Saving keeps the job in review with the edits, and the chapters,
+	// which aren't edited there, as they were.
+	rr = postReview(app, user, job, url.Values{
+		"summary":              {" Edited summary "},
+		"paragraph":            {"First paragraph.", " ", "Second paragraph."},
+		"section-action_items": {"Call Sam\n\n Send notes \n"},
+		"action":               {"save"},
+	})
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/jobs/"+job.ID+"/review" {
+		t.Errorf("saving: got status %d to %q; want a redirect back to the review", rr.Code, rr.Header().Get("Location"))
+	}
+
+	job, err = app.jobs.Get(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var saved pipeline.ResponseSchemaForNotion
+	err = json.Unmarshal([]byte(job.Summary), &saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != models.JobReview || saved.Summary != "Edited summary" ||
+		saved.LogicalParagraphs != "First paragraph.\n\nSecond paragraph." ||
+		strings.Join(saved.Sections["action_items"], "|") != "Call Sam|Send notes" ||
+		len(saved.Chapters) != 1 || saved.Chapters[0].Title != "Intro" {
+		t.Errorf("after saving got status %q and summary %+v", job.Status, saved)
+	}
+
+	// Another user can't see the job, let alone publish it.
+	rr = postReview(app, models.User{ID: "other"}, job, url.Values{"action": {"publish"}})
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("another user publishing: got status %d; want %d", rr.Code, http.StatusNotFound)
+	}
+
+	// Publishing moves the job out of review, so a second attempt made
+	// from the same, now stale, page is refused.
+	form := url.Values{"summary": {"Edited summary"}, "paragraph": {"First paragraph."}, "action": {"publish"}}
+
+	rr = postReview(app, user, job, form)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/jobs/"+job.ID {
+		t.Errorf("publishing: got status %d to %q; want a redirect to the job", rr.Code, rr.Header().Get("Location"))
+	}
+
+	rr = postReview(app, user, job, form)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("publishing twice: got status %d; want %d", rr.Code, http.StatusConflict)
+	}
+
+	job = waitForJob(t, app, job.ID)
+	if job.Status != models.JobCompleted || job.NotionPageURL != "https://notion.so/page" {
+		t.Errorf("after publishing got status %q and page %q; want the job completed", job.Status, job.NotionPageURL)
+	}
+}
+
+func TestApproveJobRacing(t *testing.T) {
+	fakeNotion(t)
+
+	app, user := newTestApplication(t)
+	app.pipeline = &pipeline.Pipeline{Logger: app.logger, Jobs: app.jobs}
+
+	job, err := app.jobs.Insert(models.Job{UserID: user.ID, Filename: "audio.mp3", Review: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.Summary = `{"summary": "s"}`
+	err = app.jobs.SetSummary(job.ID, job.Summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.jobs.SetStatus(job.ID, models.JobReview)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Regenerating and publishing both need the job in review, so only
+	// the first of the two requests wins.
+	err = app.approveJob(user, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.regenerateSummary(job)
+	if !errors.Is(err, models.ErrStatusChanged) {
+		t.Errorf("regenerating a published job: got %v; want ErrStatusChanged", err)
+	}
+
+	if job = waitForJob(t, app, job.ID); job.Status != models.JobCompleted {
+		t.Errorf("got status %q; want the job published", job.Status)
+	}
+}
@@ -1,9 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"html/template"
 	"net/http"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
 )
 
+type envelope map[string]any
+
 func (app *application) serverError(w http.ResponseWriter, r *http.Request, err error) {
 	var (
 		method = r.Method
@@ -17,3 +24,73 @@ func (app *application) serverError(w http.ResponseWriter, r *http.Request, err
 func (app *application) clientError(w http.ResponseWriter, status int) {
 	http.Error(w, http.StatusText(status), status)
 }
+
+func (app *application) render(w http.ResponseWriter, r *http.Request, status int, page string, data *TemplateData) {
+	files := []string{
+		"./ui/html/base.tmpl",
+		"./ui/html/pages/" + page,
+	}
+
+	ts, err := template.ParseFiles(files...)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	buf := new(bytes.Buffer)
+
+	err = ts.ExecuteTemplate(buf, "base", data)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+func (app *application) authenticatedUser(r *http.Request) (models.User, bool) {
+	user, ok := r.Context().Value(authenticatedUserContextKey).(models.User)
+	return user, ok
+}
+
+func (app *application) writeJSON(w http.ResponseWriter, status int, data any, headers http.Header) error {
+	js, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(js, '\n'))
+
+	return nil
+}
+
+// apiError is the JSON counterpart of clientError. Every API error body has
+// the same shape: {"error": {"status": 404, "message": "..."}}.
+func (app *application) apiError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	body := envelope{
+		"error": envelope{
+			"status":  status,
+			"message": message,
+		},
+	}
+
+	err := app.writeJSON(w, status, body, nil)
+	if err != nil {
+		app.logger.Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// apiServerError is the JSON counterpart of serverError. The underlying error
+// is logged but never sent to the client.
+func (app *application) apiServerError(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+	app.apiError(w, r, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+}
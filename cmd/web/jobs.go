@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+	"github.com/derekhassan/transcribe-to-notion/internal/pipeline"
+)
+
+// submitJob stores the audio, records a queued job and starts processing it
+// in the background.
+func (app *application) submitJob(user models.User, notionDatabaseId string, filename string, audio []byte, opts pipeline.Options) (models.Job, error) {
+	job, err := app.pipeline.CreateJob(user, notionDatabaseId, filename, audio, opts)
+	if err != nil {
+		return models.Job{}, err
+	}
+	app.jobStatusChanged(job)
+
+	go app.pipeline.Process(job, user.AccessToken)
+
+	return job, nil
+}
+
+// duplicateJob returns the job the user already submitted the same audio
+// to the same Notion database with, unless it failed.
+func (app *application) duplicateJob(user models.User, notionDatabaseId string, audioHash string) (models.Job, bool, error) {
+	job, err := app.jobs.FindDuplicate(user.ID, notionDatabaseId, audioHash)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return models.Job{}, false, nil
+		}
+		return models.Job{}, false, err
+	}
+
+	return job, true, nil
+}
+
+var errUnknownTemplate = errors.New("unknown summary template")
+
+// resolveTemplate finds the template chosen on the upload form or in an API
+// request: the key of a built-in template, or the ID of a custom template
+// the user can see. An empty choice is the default template.
+func (app *application) resolveTemplate(user models.User, choice string) (models.SummaryTemplate, error) {
+	if choice == "" {
+		choice = pipeline.DefaultTemplateKey
+	}
+
+	if t, ok := pipeline.BuiltinTemplate(choice); ok {
+		return t, nil
+	}
+
+	id, err := strconv.ParseInt(choice, 10, 64)
+	if err != nil {
+		return models.SummaryTemplate{}, errUnknownTemplate
+	}
+
+	t, err := app.templates.GetForUser(id, user.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return models.SummaryTemplate{}, errUnknownTemplate
+		}
+		return models.SummaryTemplate{}, err
+	}
+
+	return t, nil
+}
+
+// saveReview replaces a reviewed job's summary with the user's edits and
+// its transcript with the edited paragraphs, so a regenerated summary is
+// based on the corrected text. A diarized job's paragraphs come with their
+// speakers, which replace the job's speaker-labelled transcript.
+func (app *application) saveReview(job models.Job, edited pipeline.ResponseSchemaForNotion, speakers pipeline.Speakers) (models.Job, error) {
+	transcript := edited.LogicalParagraphs
+
+	if len(speakers.Turns) > 0 {
+		b, err := json.Marshal(speakers)
+		if err != nil {
+			return job, err
+		}
+
+		job.Speakers = string(b)
+
+		err = app.jobs.SetSpeakers(job.ID, job.Speakers)
+		if err != nil {
+			return job, err
+		}
+
+		transcript = speakers.Transcript()
+	}
+
+	summary, err := json.Marshal(edited)
+	if err != nil {
+		return job, err
+	}
+
+	job.Summary = string(summary)
+	job.Transcript = transcript
+
+	err = app.jobs.SetSummary(job.ID, job.Summary)
+	if err != nil {
+		return job, err
+	}
+
+	err = app.jobs.SetTranscript(job.ID, job.Transcript)
+	if err != nil {
+		return job, err
+	}
+
+	return job, nil
+}
+
+// regenerateSummary starts summarizing a job in review again from its
+// current transcript.
+func (app *application) regenerateSummary(job models.Job) error {
+	err := app.jobs.Transition(job.ID, models.JobReview, models.JobSummarizing)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		_, err := app.pipeline.Resummarize(job)
+		if err != nil {
+			app.logger.Error(err.Error(), "job", job.ID)
+		}
+	}()
+
+	return nil
+}
+
+// approveJob publishes a job in review to Notion in the background.
+func (app *application) approveJob(user models.User, job models.Job) error {
+	err := app.jobs.Transition(job.ID, models.JobReview, models.JobPublishing)
+	if err != nil {
+		return err
+	}
+
+	go app.pipeline.Publish(job, user.AccessToken)
+
+	return nil
+}
+
+// rerunJob processes a completed or failed job again from its stored audio
+// in the background. It updates the job's Notion page in place unless
+// newPage is set.
+func (app *application) rerunJob(user models.User, job models.Job, newPage bool) (models.Job, error) {
+	err := app.checkQuota(user, models.Job{})
+	if err != nil {
+		return job, err
+	}
+
+	err = app.jobs.Rerun(job.ID, newPage)
+	if err != nil {
+		return job, err
+	}
+
+	job, err = app.jobs.Get(job.ID)
+	if err != nil {
+		return job, err
+	}
+	app.jobStatusChanged(job)
+
+	go app.pipeline.Process(job, user.AccessToken)
+
+	return job, nil
+}
+
+// jobStatusChanged is the pipeline's OnStatusChange callback.
+func (app *application) jobStatusChanged(job models.Job) {
+	app.recordJobEvent(job.ID, models.JobEventStatus, jobStatusEvent{
+		Status:        job.Status,
+		NotionPageUrl: job.NotionPageURL,
+		Error:         job.Error,
+	})
+
+	app.enqueueWebhooks(job)
+}
+
+// downloadAudio fetches a remote audio file for jobs submitted by URL,
+// returning its contents, their hash from pipeline.ReadAudio and a filename
+// derived from the URL path. Like the other URLs users supply, it may only
+// point at a public address.
+func (app *application) downloadAudio(rawUrl string) ([]byte, string, string, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, "", "", errors.New("audio_url must be an absolute http or https URL")
+	}
+
+	_, err = checkOutboundURL(u.String(), app.config.allowPrivateURLs)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("audio_url: %w", err)
+	}
+
+	resp, err := app.audioClient.Get(u.String())
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", "", fmt.Errorf("downloading %s: unexpected status %s", u.Redacted(), resp.Status)
+	}
+
+	b, hash, err := pipeline.ReadAudio(resp.Body)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	filename := path.Base(u.Path)
+	if filename == "/" || filename == "." {
+		filename = "audio"
+	}
+
+	return b, hash, filename, nil
+}
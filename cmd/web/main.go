@@ -1,21 +1,80 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
 	"flag"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+	"github.com/derekhassan/transcribe-to-notion/internal/pipeline"
+	_ "modernc.org/sqlite"
 )
 
 type config = struct {
 	mockOpenAI bool
 	addr       string
 	appUri     string
+	dsn        string
+	storage    string
+	storageDir string
+	watch      struct {
+		dir        string
+		prefix     string
+		databaseId string
+		userId     string
+		interval   time.Duration
+	}
+	feedInterval       time.Duration
+	summaryConcurrency int
+	ffmpeg             string
+	diarizeURL         string
+	audioLinkExpiry    time.Duration
+	transcriptCacheTTL time.Duration
+	transcriptCacheMB  int64
+	prices             string
+	quotas             struct {
+		user      models.Quota
+		workspace models.Quota
+		maxWait   time.Duration
+	}
+	admins []string
+
+	allowPrivateURLs bool
 }
 
 type application struct {
-	logger *slog.Logger
-	config config
+	logger    *slog.Logger
+	config    config
+	users     *models.UserModel
+	jobs      *models.JobModel
+	apiTokens *models.APITokenModel
+	feeds     *models.FeedModel
+	webhooks  *models.WebhookModel
+	jobEvents *models.JobEventModel
+	glossary  *models.GlossaryModel
+	templates *models.SummaryTemplateModel
+	usage     *models.UsageModel
+	pipeline  *pipeline.Pipeline
+
+	quotaOverrides *models.QuotaOverrideModel
+	quotaMu        sync.Mutex
+
+	idempotencyKeys *models.IdempotencyKeyModel
+
+	jobEventBroker *jobEventBroker
+
+	// Clients for URLs that users supply, which refuse private addresses.
+	audioClient   *http.Client
+	feedClient    *http.Client
+	webhookClient *http.Client
 }
 
 func main() {
@@ -24,25 +83,211 @@ func main() {
 	flag.StringVar(&cfg.addr, "addr", ":4000", "HTTP network address")
 	flag.StringVar(&cfg.appUri, "appUri", "http://localhost:4000", "The application URI")
 	flag.BoolVar(&cfg.mockOpenAI, "mockOpenAI", true, "Mock OpenAI requests with local file outputs")
+	flag.StringVar(&cfg.dsn, "dsn", "transcribe.db", "SQLite database file")
+	flag.StringVar(&cfg.storage, "storage", "azure", "Where uploads are stored (azure|local)")
+	flag.StringVar(&cfg.storageDir, "storageDir", "./data", "Directory for uploads when using local storage")
+	flag.StringVar(&cfg.watch.dir, "watchDir", "", "Submit audio files dropped into this directory")
+	flag.StringVar(&cfg.watch.prefix, "watchPrefix", "", "Submit audio files uploaded under this storage prefix, e.g. inbox/")
+	flag.StringVar(&cfg.watch.databaseId, "watchDatabase", "", "Notion database ID for watched files")
+	flag.StringVar(&cfg.watch.userId, "watchUser", "", "Notion user ID whose connection is used for watched files")
+	flag.DurationVar(&cfg.watch.interval, "watchInterval", 15*time.Second, "How often to poll the watched folder")
+	flag.IntVar(&cfg.summaryConcurrency, "summaryConcurrency", 3, "How many chunks of a long transcript to summarize at once")
+	flag.DurationVar(&cfg.feedInterval, "feedInterval", 30*time.Minute, "How often to check podcast feeds for new episodes")
+	flag.StringVar(&cfg.ffmpeg, "ffmpeg", "ffmpeg", "Path to ffmpeg, used to convert audio formats Whisper doesn't accept (empty to reject them)")
+	flag.StringVar(&cfg.diarizeURL, "diarizeURL", "", "URL of a speaker diarization service, which lets jobs label who's speaking (empty to disable)")
+	flag.DurationVar(&cfg.transcriptCacheTTL, "transcriptCacheTTL", 30*24*time.Hour, "How long transcripts are cached, so processing the same audio again doesn't pay for it twice")
+	flag.Int64Var(&cfg.transcriptCacheMB, "transcriptCacheMB", 100, "Most megabytes of transcripts to cache (0 to disable the cache)")
+	flag.DurationVar(&cfg.audioLinkExpiry, "audioLinkExpiry", 7*24*time.Hour, "How long signed links to audio attached to Notion pages work, when it can't be uploaded to Notion")
+	flag.IntVar(&cfg.quotas.user.MinutesPerMonth, "quotaMinutes", 0, "Audio minutes each user can transcribe a month (0 for no limit)")
+	flag.IntVar(&cfg.quotas.user.JobsPerDay, "quotaJobsPerDay", 0, "Jobs each user can submit a day (0 for no limit)")
+	flag.IntVar(&cfg.quotas.user.ConcurrentJobs, "quotaConcurrentJobs", 0, "Jobs each user can have in progress at once (0 for no limit)")
+	flag.IntVar(&cfg.quotas.workspace.MinutesPerMonth, "workspaceQuotaMinutes", 0, "Audio minutes each Notion workspace can transcribe a month (0 for no limit)")
+	flag.IntVar(&cfg.quotas.workspace.JobsPerDay, "workspaceQuotaJobsPerDay", 0, "Jobs each Notion workspace can submit a day (0 for no limit)")
+	flag.IntVar(&cfg.quotas.workspace.ConcurrentJobs, "workspaceQuotaConcurrentJobs", 0, "Jobs each Notion workspace can have in progress at once (0 for no limit)")
+	flag.DurationVar(&cfg.quotas.maxWait, "quotaMaxWait", time.Hour, "How long a job over the concurrent jobs limit waits for another to finish before failing")
+	admins := flag.String("admins", "", "Comma-separated Notion user IDs of admins, who can override quotas")
+	flag.StringVar(&cfg.prices, "prices", "", "JSON file of OpenAI prices used to cost jobs, overriding the built-in list prices")
+	flag.BoolVar(&cfg.allowPrivateURLs, "allowPrivateURLs", false, "Let audio URLs, podcast feeds and webhooks point at private network addresses, e.g. for local development")
 
 	flag.Parse()
 
+	for _, id := range strings.Split(*admins, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			cfg.admins = append(cfg.admins, id)
+		}
+	}
+
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 		Level:     slog.LevelDebug,
 		AddSource: true,
 	}))
 
+	db, err := openDB(cfg.dsn)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	storage, err := openStorage(cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	if cfg.ffmpeg != "" {
+		cfg.ffmpeg, err = exec.LookPath(cfg.ffmpeg)
+		if err != nil {
+			logger.Warn("ffmpeg not found, so audio formats Whisper doesn't accept will be rejected", "error", err.Error())
+			cfg.ffmpeg = ""
+		}
+	}
+
+	prices := pipeline.DefaultPrices
+	if cfg.prices != "" {
+		prices, err = pipeline.LoadPrices(cfg.prices)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	jobs := &models.JobModel{DB: db}
+	glossary := &models.GlossaryModel{DB: db}
+
 	app := &application{
-		logger: logger,
-		config: cfg,
+		logger:    logger,
+		config:    cfg,
+		users:     &models.UserModel{DB: db},
+		jobs:      jobs,
+		apiTokens: &models.APITokenModel{DB: db},
+		feeds:     &models.FeedModel{DB: db},
+		webhooks:  &models.WebhookModel{DB: db},
+		jobEvents: &models.JobEventModel{DB: db},
+		glossary:  glossary,
+		templates: &models.SummaryTemplateModel{DB: db},
+		usage:     &models.UsageModel{DB: db},
+		pipeline: &pipeline.Pipeline{
+			Logger:     logger,
+			MockOpenAI: cfg.mockOpenAI,
+			Jobs:       jobs,
+			Storage:    storage,
+			Glossary:   glossary,
+			FFmpeg:     cfg.ffmpeg,
+
+			SummaryConcurrency: cfg.summaryConcurrency,
+			Prices:             prices,
+			AudioLinkExpiry:    cfg.audioLinkExpiry,
+		},
+		quotaOverrides:  &models.QuotaOverrideModel{DB: db},
+		idempotencyKeys: &models.IdempotencyKeyModel{DB: db},
+		jobEventBroker:  newJobEventBroker(),
+		audioClient:     newOutboundClient(2*time.Minute, cfg.allowPrivateURLs),
+		feedClient:      newOutboundClient(30*time.Second, cfg.allowPrivateURLs),
+		webhookClient:   newOutboundClient(10*time.Second, cfg.allowPrivateURLs),
+	}
+	if cfg.diarizeURL != "" {
+		app.pipeline.Diarizer = &pipeline.HTTPDiarizer{
+			URL:    cfg.diarizeURL,
+			Client: &http.Client{Timeout: 10 * time.Minute},
+		}
+	}
+	if cfg.transcriptCacheMB > 0 {
+		app.pipeline.TranscriptCache = &models.TranscriptCacheModel{
+			DB:       db,
+			TTL:      cfg.transcriptCacheTTL,
+			MaxBytes: cfg.transcriptCacheMB << 20,
+		}
 	}
 
+	app.pipeline.Admit = app.admitJob
+	app.pipeline.OnStatusChange = app.jobStatusChanged
+	app.pipeline.OnProgress = app.jobProgress
+
+	if cfg.watch.dir != "" || cfg.watch.prefix != "" {
+		w, err := newWatcher(cfg, storage)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		_, err = app.users.Get(w.userId)
+		if err != nil {
+			logger.Error("watch folder user must have logged in at least once", "user", w.userId, "error", err.Error())
+			os.Exit(1)
+		}
+
+		go app.watch(w)
+	}
+
+	go app.pollFeedsEvery(cfg.feedInterval)
+	go app.deliverWebhooksEvery(5 * time.Second)
+
 	logger.Info("starting server", slog.String("addr", app.config.addr))
 	logger.Info("Mocking OpenAI Requests: ", slog.Bool("mockOpenAI", app.config.mockOpenAI))
 	logger.Info("Application URL: ", slog.String("appUri", app.config.appUri))
 
-	err := http.ListenAndServe(app.config.addr, app.routes())
+	err = http.ListenAndServe(app.config.addr, app.routes())
 
 	logger.Error(err.Error())
 	os.Exit(1)
 }
+
+func openDB(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", "file:"+dsn+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	err = models.Migrate(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func newWatcher(cfg config, storage pipeline.Storage) (*watcher, error) {
+	if cfg.watch.dir != "" && cfg.watch.prefix != "" {
+		return nil, errors.New("-watchDir and -watchPrefix cannot be used together")
+	}
+
+	if cfg.watch.databaseId == "" || cfg.watch.userId == "" {
+		return nil, errors.New("-watchDatabase and -watchUser are required when watching for files")
+	}
+
+	w := &watcher{
+		source:     storage,
+		prefix:     cfg.watch.prefix,
+		databaseId: cfg.watch.databaseId,
+		userId:     cfg.watch.userId,
+		interval:   cfg.watch.interval,
+	}
+
+	if cfg.watch.dir != "" {
+		w.source = &pipeline.LocalStorage{Dir: cfg.watch.dir}
+		w.prefix = ""
+	} else if !strings.HasSuffix(w.prefix, "/") {
+		w.prefix += "/"
+	}
+
+	return w, nil
+}
+
+func openStorage(cfg config) (pipeline.Storage, error) {
+	switch cfg.storage {
+	case "azure":
+		return pipeline.NewAzureStorage()
+	case "local":
+		return &pipeline.LocalStorage{Dir: cfg.storageDir}, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.storage)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+)
+
+// authenticate loads the user linked to the Notion access token cookie, if
+// there is one, and stores it in the request context.
+func (app *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("notion_token")
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := app.users.GetByAccessToken(cookie.Value)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			app.serverError(w, r, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), authenticatedUserContextKey, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authenticateAPIToken authenticates API requests carrying a personal API
+// token in the Authorization header. It takes precedence over the session
+// cookie, and an invalid token is rejected outright rather than falling back.
+func (app *application) authenticateAPIToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		if authorization == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Authorization")
+
+		plaintext, ok := strings.CutPrefix(authorization, "Bearer ")
+		if !ok {
+			app.apiError(w, r, http.StatusUnauthorized, "the Authorization header must use the Bearer scheme")
+			return
+		}
+
+		token, err := app.apiTokens.GetActive(plaintext)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				app.apiError(w, r, http.StatusUnauthorized, "invalid or revoked API token")
+				return
+			}
+			app.apiServerError(w, r, err)
+			return
+		}
+
+		user, err := app.users.Get(token.UserID)
+		if err != nil {
+			app.apiServerError(w, r, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), authenticatedUserContextKey, user)
+		ctx = context.WithValue(ctx, apiTokenContextKey, token)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (app *application) requireAPIAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := app.authenticatedUser(r); !ok {
+			app.apiError(w, r, http.StatusUnauthorized, "authentication required")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireScope rejects API token requests whose token lacks the scope.
+// Browser sessions are not scoped and always pass.
+func (app *application) requireScope(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := r.Context().Value(apiTokenContextKey).(models.APIToken)
+		if ok && !token.HasScope(scope) {
+			app.apiError(w, r, http.StatusForbidden, "this API token does not have the \""+scope+"\" scope")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
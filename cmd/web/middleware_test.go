@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+)
+
+func TestAPITokenScopes(t *testing.T) {
+	app, user := newTestApplication(t)
+
+	readToken, _, err := app.apiTokens.Insert(user.ID, "read", []string{models.ScopeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	submitToken, _, err := app.apiTokens.Insert(user.ID, "submit", []string{models.ScopeSubmit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revokedToken, revoked, err := app.apiTokens.Insert(user.ID, "revoked", []string{models.ScopeRead, models.ScopeSubmit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.apiTokens.Revoke(revoked.ID, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := app.authenticatedUser(r); !ok {
+			t.Errorf("the handler ran without an authenticated user")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name          string
+		authorization string
+		scope         string
+		wantStatus    int
+	}{
+		{"read token reading", "Bearer " + readToken, models.ScopeRead, http.StatusNoContent},
+		{"read token submitting", "Bearer " + readToken, models.ScopeSubmit, http.StatusForbidden},
+		{"submit token submitting", "Bearer " + submitToken, models.ScopeSubmit, http.StatusNoContent},
+		{"submit token reading", "Bearer " + submitToken, models.ScopeRead, http.StatusForbidden},
+		{"revoked token", "Bearer " + revokedToken, models.ScopeRead, http.StatusUnauthorized},
+		{"unknown token", "Bearer ttn_unknown", models.ScopeRead, http.StatusUnauthorized},
+		{"not a bearer token", "Basic " + readToken, models.ScopeRead, http.StatusUnauthorized},
+		{"lowercase scheme", "bearer " + readToken, models.ScopeRead, http.StatusUnauthorized},
+		{"no authorization", "", models.ScopeRead, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := app.authenticateAPIToken(app.requireAPIAuthentication(app.requireScope(tt.scope, ok)))
+
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/jobs", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, r)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("got status %d; want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+		})
+	}
+}
+
+func TestRequireScopeLetsSessionsThrough(t *testing.T) {
+	app, user := newTestApplication(t)
+
+	handler := app.requireScope(models.ScopeSubmit, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/jobs", nil)
+	r = r.WithContext(context.WithValue(r.Context(), authenticatedUserContextKey, user))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("got status %d; want a browser session to pass, %d", rr.Code, http.StatusNoContent)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+var errPrivateAddress = errors.New("refusing to connect to a private network address")
+
+// sharedAddressSpace is the carrier-grade NAT range, which net.IP doesn't
+// count as private but isn't reachable from the internet either.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// isPublicIP reports whether ip is an address on the public internet, as
+// opposed to loopback, private, link-local (which includes cloud metadata
+// endpoints) or unspecified.
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip))
+}
+
+// newOutboundClient returns a client for fetching URLs that users supply,
+// such as audio URLs, podcast feeds and webhooks. Unless allowPrivate is
+// set it refuses to connect to anything but public addresses, so users
+// can't make the server reach internal services. The check is made on each
+// address actually dialled, so it also covers redirects and hostnames that
+// resolve to private addresses.
+func newOutboundClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+
+	if !allowPrivate {
+		dialer.Control = func(network string, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			ip := net.ParseIP(host)
+			if ip == nil || !isPublicIP(ip) {
+				return errPrivateAddress
+			}
+
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+
+	// A proxy would be dialled instead of the URL's host, defeating the
+	// check.
+	transport.Proxy = nil
+
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// checkOutboundURL checks that a URL a user supplies is an absolute http
+// or https URL whose host, unless allowPrivate is set, only resolves to
+// public addresses. The check is repeated when the URL is fetched, but
+// making it up front lets the user know straight away.
+func checkOutboundURL(rawUrl string, allowPrivate bool) (*url.URL, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return nil, errors.New("the URL must be an absolute http or https URL")
+	}
+
+	if allowPrivate {
+		return u, nil
+	}
+
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ip := range ips {
+		if !isPublicIP(ip) {
+			return nil, errPrivateAddress
+		}
+	}
+
+	return u, nil
+}
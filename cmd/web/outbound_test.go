@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"8.8.8.8", true},
+		{"2606:4700:4700::1111", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"fd00::1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"224.0.0.1", false},
+	}
+
+	for _, tt := range tests {
+		if got := isPublicIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("isPublicIP(%s) = %t; want %t", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestCheckOutboundURL(t *testing.T) {
+	tests := []struct {
+		name         string
+		url          string
+		allowPrivate bool
+		wantErr      bool
+		wantPrivate  bool
+	}{
+		{"public address", "https://8.8.8.8/hook", false, false, false},
+		{"loopback", "http://127.0.0.1:8080/hook", false, true, true},
+		{"localhost", "http://localhost/hook", false, true, true},
+		{"metadata endpoint", "http://169.254.169.254/latest/meta-data/", false, true, true},
+		{"private address allowed", "http://10.0.0.5/hook", true, false, false},
+		{"not http", "ftp://8.8.8.8/file", false, true, false},
+		{"relative", "/hook", false, true, false},
+		{"no host", "http:///hook", false, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := checkOutboundURL(tt.url, tt.allowPrivate)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkOutboundURL(%q) = %v; want error %t", tt.url, err, tt.wantErr)
+			}
+			if errors.Is(err, errPrivateAddress) != tt.wantPrivate {
+				t.Errorf("checkOutboundURL(%q) = %v; want errPrivateAddress %t", tt.url, err, tt.wantPrivate)
+			}
+		})
+	}
+}
+
+func TestOutboundClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal"))
+	}))
+	defer server.Close()
+
+	_, err := newOutboundClient(5*time.Second, false).Get(server.URL)
+	if !errors.Is(err, errPrivateAddress) {
+		t.Errorf("Get(%s) = %v; want errPrivateAddress", server.URL, err)
+	}
+
+	resp, err := newOutboundClient(5*time.Second, true).Get(server.URL)
+	if err != nil {
+		t.Fatalf("with private addresses allowed: %v", err)
+	}
+	resp.Body.Close()
+}
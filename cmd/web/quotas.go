@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+)
+
+// quotaWaitInterval is how often a job held back by the concurrent jobs
+// limit checks whether it can start.
+var quotaWaitInterval = 10 * time.Second
+
+var (
+	errOverQuota = errors.New("over quota")
+
+	// errQuotaBusy means a job can't start until another finishes. Jobs
+	// wait for it rather than failing.
+	errQuotaBusy = errors.New("too many jobs running")
+)
+
+// quotaFor returns the quota that applies to a user or workspace: an
+// admin's override if there is one, and the configured quota otherwise.
+func (app *application) quotaFor(scope string, subjectID string) (models.Quota, error) {
+	override, err := app.quotaOverrides.Get(scope, subjectID)
+	if err == nil {
+		return override.Quota, nil
+	}
+	if !errors.Is(err, models.ErrNoRecord) {
+		return models.Quota{}, err
+	}
+
+	if scope == models.QuotaScopeWorkspace {
+		return app.config.quotas.workspace, nil
+	}
+	return app.config.quotas.user, nil
+}
+
+// checkQuota checks the user's and their workspace's quotas before job
+// runs. Pass the zero Job to check before a new job is created; then the
+// concurrent jobs limit counts every unfinished job, so that users can't
+// queue up more than they are allowed to run. For a job about to start it
+// only counts running jobs and returns errQuotaBusy if they are at the
+// limit.
+func (app *application) checkQuota(user models.User, job models.Job) error {
+	userQuota, err := app.quotaFor(models.QuotaScopeUser, user.ID)
+	if err != nil {
+		return err
+	}
+
+	userUsage, err := app.usage.QuotaForUser(user.ID, job)
+	if err != nil {
+		return err
+	}
+
+	err = checkQuotaUsage(userQuota, userUsage, job, "you have", "your")
+	if err != nil {
+		return err
+	}
+
+	workspaceQuota, err := app.quotaFor(models.QuotaScopeWorkspace, user.WorkspaceID)
+	if err != nil {
+		return err
+	}
+
+	workspaceUsage, err := app.usage.QuotaForWorkspace(user.WorkspaceID, job)
+	if err != nil {
+		return err
+	}
+
+	return checkQuotaUsage(workspaceQuota, workspaceUsage, job, "your workspace has", "its")
+}
+
+func checkQuotaUsage(quota models.Quota, usage models.QuotaUsage, job models.Job, subject string, possessive string) error {
+	if quota.MinutesPerMonth > 0 && usage.AudioSeconds >= float64(quota.MinutesPerMonth*60) {
+		return fmt.Errorf("%w: %s used all %d of %s audio minutes for this month", errOverQuota, subject, quota.MinutesPerMonth, possessive)
+	}
+
+	if quota.JobsPerDay > 0 && usage.JobsToday >= quota.JobsPerDay {
+		return fmt.Errorf("%w: %s reached %s limit of %d jobs a day", errOverQuota, subject, possessive, quota.JobsPerDay)
+	}
+
+	if quota.ConcurrentJobs > 0 {
+		if job.ID == "" && usage.PendingJobs >= quota.ConcurrentJobs {
+			return fmt.Errorf("%w: %s reached %s limit of %d jobs in progress at once; try again when one has finished", errOverQuota, subject, possessive, quota.ConcurrentJobs)
+		}
+		if job.ID != "" && usage.RunningJobs >= quota.ConcurrentJobs {
+			return fmt.Errorf("%w: %s reached %s limit of %d jobs in progress at once", errQuotaBusy, subject, possessive, quota.ConcurrentJobs)
+		}
+	}
+
+	return nil
+}
+
+// admitJob is the pipeline's Admit hook. It enforces the quotas again when a
+// job starts, as jobs from feeds and watched folders aren't checked when
+// they are submitted and usage may have grown while a job was queued. A job
+// over the concurrent jobs limit waits for a running one to finish, and
+// fails if none has after the configured maximum wait.
+func (app *application) admitJob(job models.Job) error {
+	user, err := app.users.Get(job.UserID)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(app.config.quotas.maxWait)
+
+	for {
+		// Checking and starting the job happen under the lock so that two
+		// waiting jobs can't both take the last free slot.
+		app.quotaMu.Lock()
+		err = app.checkQuota(user, job)
+		if err == nil {
+			err = app.jobs.SetStatus(job.ID, models.JobTranscribing)
+		}
+		app.quotaMu.Unlock()
+
+		if !errors.Is(err, errQuotaBusy) {
+			return err
+		}
+
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("%w; gave up waiting for one to finish after %s", err, app.config.quotas.maxWait)
+		}
+
+		time.Sleep(min(quotaWaitInterval, time.Until(deadline)))
+	}
+}
+
+func (app *application) isAdmin(user models.User) bool {
+	return slices.Contains(app.config.admins, user.ID)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+)
+
+func TestCheckQuotaUsage(t *testing.T) {
+	quota := models.Quota{MinutesPerMonth: 60, JobsPerDay: 5, ConcurrentJobs: 2}
+	queued := models.Job{ID: "job"}
+
+	tests := []struct {
+		name    string
+		quota   models.Quota
+		usage   models.QuotaUsage
+		job     models.Job
+		wantErr error
+	}{
+		{"no limits", models.Quota{}, models.QuotaUsage{AudioSeconds: 1e6, JobsToday: 1000, PendingJobs: 100, RunningJobs: 100}, models.Job{}, nil},
+		{"under every limit", quota, models.QuotaUsage{AudioSeconds: 3599, JobsToday: 4, PendingJobs: 1, RunningJobs: 1}, models.Job{}, nil},
+		{"minutes used up", quota, models.QuotaUsage{AudioSeconds: 3600}, models.Job{}, errOverQuota},
+		{"daily jobs used up", quota, models.QuotaUsage{JobsToday: 5}, models.Job{}, errOverQuota},
+		{"too many pending to submit", quota, models.QuotaUsage{PendingJobs: 2}, models.Job{}, errOverQuota},
+		{"running jobs don't stop a submission under the pending limit", quota, models.QuotaUsage{PendingJobs: 1, RunningJobs: 1}, models.Job{}, nil},
+		{"queued job waits for a running one", quota, models.QuotaUsage{PendingJobs: 3, RunningJobs: 2}, queued, errQuotaBusy},
+		{"queued job starts despite other queued jobs", quota, models.QuotaUsage{PendingJobs: 3, RunningJobs: 1}, queued, nil},
+		{"queued job over its minutes fails", quota, models.QuotaUsage{AudioSeconds: 3600, RunningJobs: 2}, queued, errOverQuota},
+		{"queued job over the daily jobs fails", quota, models.QuotaUsage{JobsToday: 5}, queued, errOverQuota},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkQuotaUsage(tt.quota, tt.usage, tt.job, "you have", "your")
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("got error %v; want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAdmitJobWaitsForARunningJob(t *testing.T) {
+	app, user := newTestApplication(t)
+	app.config.quotas.user.ConcurrentJobs = 1
+	app.config.quotas.maxWait = 5 * time.Second
+
+	interval := quotaWaitInterval
+	quotaWaitInterval = 10 * time.Millisecond
+	t.Cleanup(func() { quotaWaitInterval = interval })
+
+	running, err := app.jobs.Insert(models.Job{UserID: user.ID, Filename: "running.mp3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.jobs.SetStatus(running.ID, models.JobTranscribing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queued, err := app.jobs.Insert(models.Job{UserID: user.ID, Filename: "queued.mp3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admitted := make(chan error, 1)
+	go func() { admitted <- app.admitJob(queued) }()
+
+	select {
+	case err := <-admitted:
+		t.Fatalf("admitted while another job was running: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	err = app.jobs.Complete(running.ID, "page", "https://notion.so/page", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-admitted:
+		if err != nil {
+			t.Fatalf("got error %v once the running job finished", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("still waiting after the running job finished")
+	}
+
+	job, err := app.jobs.Get(queued.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != models.JobTranscribing {
+		t.Errorf("got status %q; want the job started", job.Status)
+	}
+}
+
+func TestAdmitJobGivesUpWaiting(t *testing.T) {
+	app, user := newTestApplication(t)
+	app.config.quotas.user.ConcurrentJobs = 1
+	app.config.quotas.maxWait = 50 * time.Millisecond
+
+	interval := quotaWaitInterval
+	quotaWaitInterval = 10 * time.Millisecond
+	t.Cleanup(func() { quotaWaitInterval = interval })
+
+	running, err := app.jobs.Insert(models.Job{UserID: user.ID, Filename: "running.mp3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.jobs.SetStatus(running.ID, models.JobTranscribing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queued, err := app.jobs.Insert(models.Job{UserID: user.ID, Filename: "queued.mp3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.admitJob(queued)
+	if !errors.Is(err, errQuotaBusy) || !strings.Contains(err.Error(), "limit of 1 jobs in progress") {
+		t.Errorf("got error %v; want the concurrent jobs limit", err)
+	}
+
+	job, err := app.jobs.Get(queued.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != models.JobQueued {
+		t.Errorf("got status %q; want the job left for the pipeline to fail", job.Status)
+	}
+}
@@ -1,8 +1,12 @@
 package main
 
-import "net/http"
+import (
+	"net/http"
 
-func (app *application) routes() *http.ServeMux {
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+)
+
+func (app *application) routes() http.Handler {
 	mux := http.NewServeMux()
 
 	fileServer := http.FileServer(http.Dir("./ui/static/"))
@@ -12,8 +16,48 @@ func (app *application) routes() *http.ServeMux {
 	mux.HandleFunc("GET /{$}", app.home)
 	mux.HandleFunc("GET /auth/callback", app.notionAuthCallback)
 	mux.HandleFunc("GET /upload", app.uploadForm)
-	mux.HandleFunc("GET /upload/success", app.uploadSuccessful)
+	mux.HandleFunc("GET /jobs/{id}", app.jobView)
+	mux.HandleFunc("GET /jobs/{id}/events", app.jobEventStream)
+	mux.HandleFunc("GET /jobs/{id}/review", app.jobReview)
+	mux.HandleFunc("POST /jobs/{id}/review", app.jobReviewSubmit)
+	mux.HandleFunc("POST /jobs/{id}/rerun", app.jobRerun)
 	mux.HandleFunc("POST /transcribe", app.createTranscription)
+	mux.HandleFunc("GET /settings/tokens", app.tokenList)
+	mux.HandleFunc("POST /settings/tokens", app.tokenCreate)
+	mux.HandleFunc("POST /settings/tokens/{id}/revoke", app.tokenRevoke)
+	mux.HandleFunc("GET /settings/webhooks", app.webhookList)
+	mux.HandleFunc("POST /settings/webhooks", app.webhookCreate)
+	mux.HandleFunc("POST /settings/webhooks/{id}/delete", app.webhookDelete)
+	mux.HandleFunc("GET /settings/glossary", app.glossaryList)
+	mux.HandleFunc("POST /settings/glossary", app.glossaryCreate)
+	mux.HandleFunc("POST /settings/glossary/{id}/delete", app.glossaryDelete)
+	mux.HandleFunc("GET /settings/templates", app.templateList)
+	mux.HandleFunc("POST /settings/templates", app.templateCreate)
+	mux.HandleFunc("POST /settings/templates/{id}/delete", app.templateDelete)
+	mux.HandleFunc("GET /settings/usage", app.usageView)
+	mux.HandleFunc("GET /admin/quotas", app.quotaOverrideList)
+	mux.HandleFunc("POST /admin/quotas", app.quotaOverrideSet)
+	mux.HandleFunc("POST /admin/quotas/{scope}/{id}/delete", app.quotaOverrideDelete)
+	mux.HandleFunc("GET /feeds", app.feedList)
+	mux.HandleFunc("POST /feeds", app.feedCreate)
+	mux.HandleFunc("POST /feeds/{id}/delete", app.feedDelete)
+
+	api := func(scope string, h http.HandlerFunc) http.Handler {
+		return app.authenticateAPIToken(app.requireAPIAuthentication(app.requireScope(scope, h)))
+	}
+
+	mux.Handle("POST /api/v1/jobs", api(models.ScopeSubmit, app.apiCreateJob))
+	mux.Handle("GET /api/v1/jobs", api(models.ScopeRead, app.apiListJobs))
+	mux.Handle("GET /api/v1/jobs/{id}", api(models.ScopeRead, app.apiGetJob))
+	mux.Handle("POST /api/v1/jobs/{id}/publish", api(models.ScopeSubmit, app.apiPublishJob))
+	mux.Handle("POST /api/v1/jobs/{id}/rerun", api(models.ScopeSubmit, app.apiRerunJob))
+	mux.Handle("GET /api/v1/jobs/{id}/events", api(models.ScopeRead, app.apiJobEvents))
+	mux.Handle("GET /api/v1/jobs/{id}/transcript", api(models.ScopeRead, app.apiGetTranscript))
+	mux.Handle("GET /api/v1/jobs/{id}/summary", api(models.ScopeRead, app.apiGetSummary))
+	mux.Handle("GET /api/v1/templates", api(models.ScopeRead, app.apiListTemplates))
+	mux.Handle("GET /api/v1/usage", api(models.ScopeRead, app.apiGetUsage))
+	mux.Handle("GET /api/v1/notion/databases", api(models.ScopeRead, app.apiListNotionDatabases))
+	mux.HandleFunc("/api/", app.apiNotFound)
 
-	return mux
+	return app.authenticate(mux)
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+	"github.com/derekhassan/transcribe-to-notion/internal/pipeline"
+)
+
+// reviewSection is one template field on the review page, with its entries
+// one per line.
+type reviewSection struct {
+	Field models.TemplateField
+	Text  string
+}
+
+type TemplateData struct {
+	IsAuthenticated     bool
+	IsAdmin             bool
+	Job                 models.Job
+	Summary             pipeline.ResponseSchemaForNotion
+	Paragraphs          []string
+	Speakers            pipeline.Speakers
+	CanDiarize          bool
+	ReviewSections      []reviewSection
+	NotionPages         []pipeline.NotionResult
+	Languages           []pipeline.Language
+	Templates           []models.SummaryTemplate
+	CustomTemplates     []models.SummaryTemplate
+	SharedTemplates     []models.SummaryTemplate
+	TemplateBlocks      []string
+	TemplateLayouts     []string
+	Tokens              []models.APIToken
+	Feeds               []models.Feed
+	Webhooks            []models.Webhook
+	WebhookDeliveries   []models.WebhookDelivery
+	GlossaryTerms       []models.GlossaryTerm
+	SharedGlossaryTerms []models.GlossaryTerm
+	UserUsage           []models.MonthlyUsage
+	WorkspaceUsage      []models.MonthlyUsage
+	UserQuota           models.Quota
+	WorkspaceQuota      models.Quota
+	QuotaOverrides      []models.QuotaOverride
+	NewToken            string
+	FormError           string
+}
+
+func (app *application) newTemplateData(r *http.Request) *TemplateData {
+	user, isAuthenticated := app.authenticatedUser(r)
+
+	return &TemplateData{
+		IsAuthenticated: isAuthenticated,
+		IsAdmin:         isAuthenticated && app.isAdmin(user),
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"database/sql"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+)
+
+// newTestApplication returns an application backed by an empty, migrated
+// in-memory database, with a user to act as.
+func newTestApplication(t *testing.T) (*application, models.User) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", "file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: gets a database of its own.
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	err = models.Migrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &application{
+		logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		users:     &models.UserModel{DB: db},
+		jobs:      &models.JobModel{DB: db},
+		apiTokens: &models.APITokenModel{DB: db},
+		feeds:     &models.FeedModel{DB: db},
+		jobEvents: &models.JobEventModel{DB: db},
+		usage:     &models.UsageModel{DB: db},
+
+		quotaOverrides:  &models.QuotaOverrideModel{DB: db},
+		idempotencyKeys: &models.IdempotencyKeyModel{DB: db},
+
+		jobEventBroker: newJobEventBroker(),
+	}
+
+	user := models.User{ID: "user", Name: "Test User", AccessToken: "secret_notion_token"}
+	err = app.users.Upsert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return app, user
+}
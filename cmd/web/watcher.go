@@ -0,0 +1,106 @@
+package main
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+	"github.com/derekhassan/transcribe-to-notion/internal/pipeline"
+)
+
+// watcher polls a folder for audio files and submits each one as a job for
+// a fixed user and Notion database. Processed files are moved into done/ or
+// failed/ beneath the folder.
+type watcher struct {
+	source     pipeline.Storage
+	prefix     string
+	databaseId string
+	userId     string
+	interval   time.Duration
+
+	// seen holds each file as it looked on the previous poll. A file is only
+	// picked up once its size and modification time have stopped changing,
+	// so recordings still being copied in are left alone.
+	seen map[string]pipeline.StoredFile
+}
+
+func (app *application) watch(w *watcher) {
+	w.seen = make(map[string]pipeline.StoredFile)
+
+	app.logger.Info("watching for audio files", "prefix", w.prefix, "interval", w.interval.String())
+
+	for {
+		app.pollWatchFolder(w)
+		time.Sleep(w.interval)
+	}
+}
+
+func (app *application) pollWatchFolder(w *watcher) {
+	files, err := w.source.List(w.prefix)
+	if err != nil {
+		app.logger.Error(err.Error(), "prefix", w.prefix)
+		return
+	}
+
+	current := make(map[string]pipeline.StoredFile, len(files))
+
+	for _, file := range files {
+		name := path.Base(file.Path)
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		current[file.Path] = file
+
+		previous, ok := w.seen[file.Path]
+		if !ok || file.Size == 0 || previous.Size != file.Size || !previous.Modified.Equal(file.Modified) {
+			continue
+		}
+
+		app.processWatchedFile(w, file)
+		delete(current, file.Path)
+	}
+
+	w.seen = current
+}
+
+func (app *application) processWatchedFile(w *watcher, file pipeline.StoredFile) {
+	name := path.Base(file.Path)
+	destination := "failed/"
+
+	defer func() {
+		err := w.source.Move(file.Path, w.prefix+destination+name)
+		if err != nil {
+			app.logger.Error(err.Error(), "file", file.Path)
+		}
+	}()
+
+	user, err := app.users.Get(w.userId)
+	if err != nil {
+		app.logger.Error("could not load watch folder user: "+err.Error(), "file", file.Path, "user", w.userId)
+		return
+	}
+
+	audio, err := w.source.Read(file.Path)
+	if err != nil {
+		app.logger.Error(err.Error(), "file", file.Path)
+		return
+	}
+
+	job, err := app.pipeline.CreateJob(user, w.databaseId, name, audio, pipeline.Options{})
+	if err != nil {
+		app.logger.Error(err.Error(), "file", file.Path)
+		return
+	}
+	app.logger.Info("submitted watched file", "file", file.Path, "job", job.ID)
+
+	job, err = app.pipeline.Process(job, user.AccessToken)
+	if err != nil {
+		return
+	}
+
+	if job.Status == models.JobCompleted {
+		destination = "done/"
+	}
+}
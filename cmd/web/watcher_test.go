@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/pipeline"
+)
+
+func TestPollWatchFolderWaitsForFilesToSettle(t *testing.T) {
+	app, user := newTestApplication(t)
+
+	dir := t.TempDir()
+	app.pipeline = &pipeline.Pipeline{Logger: app.logger, Jobs: app.jobs}
+
+	w := &watcher{
+		source:     &pipeline.LocalStorage{Dir: dir},
+		databaseId: "database",
+		userId:     user.ID,
+		seen:       make(map[string]pipeline.StoredFile),
+	}
+
+	modified := time.Now().Add(-time.Hour)
+
+	// Files aren't audio, so they fail straight away and are moved into
+	// failed/ without calling OpenAI.
+	write := func(name string, contents string) {
+		t.Helper()
+		p := filepath.Join(dir, name)
+		err := os.WriteFile(p, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Chtimes(p, modified, modified)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pickedUp := func(name string) bool {
+		t.Helper()
+		_, err := os.Stat(filepath.Join(dir, "failed", name))
+		return err == nil
+	}
+
+	write("growing.mp3", "not")
+	write("touched.mp3", "not audio")
+	write("empty.mp3", "")
+	write(".hidden.mp3", "not audio")
+
+	// A file is never picked up the first time it's seen.
+	app.pollWatchFolder(w)
+	for _, name := range []string{"growing.mp3", "touched.mp3"} {
+		if pickedUp(name) {
+			t.Errorf("%s was picked up on the first poll", name)
+		}
+	}
+
+	// Still being written: one grows, the other's modification time moves.
+	write("growing.mp3", "not audio")
+	modified = modified.Add(time.Second)
+	write("touched.mp3", "not audio")
+
+	app.pollWatchFolder(w)
+	for _, name := range []string{"growing.mp3", "touched.mp3"} {
+		if pickedUp(name) {
+			t.Errorf("%s was picked up while it was changing", name)
+		}
+	}
+
+	// Unchanged since the last poll, so both have settled.
+	app.pollWatchFolder(w)
+	for _, name := range []string{"growing.mp3", "touched.mp3"} {
+		if !pickedUp(name) {
+			t.Errorf("%s wasn't picked up once it stopped changing", name)
+		}
+	}
+
+	app.pollWatchFolder(w)
+	for _, name := range []string{"empty.mp3", ".hidden.mp3"} {
+		if pickedUp(name) {
+			t.Errorf("%s was picked up", name)
+		}
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s was moved: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+	"github.com/derekhassan/transcribe-to-notion/internal/pipeline"
+)
+
+const maxWebhookAttempts = 8
+
+type webhookPayload struct {
+	Event   string          `json:"event"`
+	Created time.Time       `json:"created"`
+	Data    webhookJobEvent `json:"data"`
+}
+
+type webhookJobEvent struct {
+	JobId         string   `json:"job_id"`
+	Status        string   `json:"status"`
+	Filename      string   `json:"filename"`
+	NotionPageId  string   `json:"notion_page_id,omitempty"`
+	NotionPageUrl string   `json:"notion_page_url,omitempty"`
+	Summary       string   `json:"summary,omitempty"`
+	ActionItems   []string `json:"action_items,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+// enqueueWebhooks queues a delivery to each of the job owner's webhooks that
+// subscribe to the job's terminal event.
+func (app *application) enqueueWebhooks(job models.Job) {
+	var event string
+
+	switch job.Status {
+	case models.JobCompleted:
+		event = models.EventJobCompleted
+	case models.JobFailed:
+		event = models.EventJobFailed
+	default:
+		return
+	}
+
+	webhooks, err := app.webhooks.ListForUser(job.UserID)
+	if err != nil {
+		app.logger.Error(err.Error(), "job", job.ID)
+		return
+	}
+
+	payload := webhookPayload{
+		Event:   event,
+		Created: time.Now().UTC(),
+		Data: webhookJobEvent{
+			JobId:         job.ID,
+			Status:        job.Status,
+			Filename:      job.Filename,
+			NotionPageId:  job.NotionPageID,
+			NotionPageUrl: job.NotionPageURL,
+			Error:         job.Error,
+		},
+	}
+
+	if job.Summary != "" {
+		var summary pipeline.ResponseSchemaForNotion
+		if json.Unmarshal([]byte(job.Summary), &summary) == nil {
+			payload.Data.Summary = summary.Summary
+			payload.Data.ActionItems = summary.Sections["action_items"]
+		}
+	}
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		app.logger.Error(err.Error(), "job", job.ID)
+		return
+	}
+
+	for _, webhook := range webhooks {
+		if !webhook.Subscribed(event) {
+			continue
+		}
+
+		err = app.webhooks.Enqueue(webhook.ID, job.ID, event, string(b))
+		if err != nil {
+			app.logger.Error(err.Error(), "job", job.ID, "webhook", webhook.ID)
+		}
+	}
+}
+
+// signWebhookPayload returns the X-Transcribe-Signature header value. The
+// signature is the hex HMAC-SHA256 of "<timestamp>.<body>" keyed with the
+// webhook secret, so receivers can reject stale or replayed requests.
+func signWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// webhookBackoff is the delay before retrying after the given number of
+// failed attempts: 30s, 1m, 2m, 4m and so on.
+func webhookBackoff(attempts int) time.Duration {
+	return 30 * time.Second << (attempts - 1)
+}
+
+func (app *application) deliverWebhooksEvery(interval time.Duration) {
+	for {
+		deliveries, err := app.webhooks.Due(time.Now(), 50)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+
+		for _, delivery := range deliveries {
+			app.deliverWebhook(delivery)
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+func (app *application) deliverWebhook(delivery models.WebhookDelivery) {
+	webhook, err := app.webhooks.Get(delivery.WebhookID)
+	if err != nil {
+		app.logger.Error(err.Error(), "delivery", delivery.ID)
+		return
+	}
+
+	responseStatus, err := app.postWebhook(webhook, delivery)
+
+	status := models.DeliverySucceeded
+	nextAttempt := time.Now()
+	var lastError string
+
+	if err != nil {
+		lastError = err.Error()
+		attempts := delivery.Attempts + 1
+
+		if attempts >= maxWebhookAttempts {
+			status = models.DeliveryFailed
+		} else {
+			status = models.DeliveryPending
+			nextAttempt = nextAttempt.Add(webhookBackoff(attempts))
+		}
+
+		app.logger.Warn("webhook delivery failed", "delivery", delivery.ID, "attempt", attempts, "error", lastError)
+	}
+
+	err = app.webhooks.RecordAttempt(delivery.ID, status, responseStatus, lastError, nextAttempt)
+	if err != nil {
+		app.logger.Error(err.Error(), "delivery", delivery.ID)
+	}
+}
+
+func (app *application) postWebhook(webhook models.Webhook, delivery models.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+
+	req, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "transcribe-to-notion-webhooks")
+	req.Header.Set("X-Transcribe-Event", delivery.Event)
+	req.Header.Set("X-Transcribe-Delivery", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("X-Transcribe-Signature", signWebhookPayload(webhook.Secret, time.Now().Unix(), body))
+
+	resp, err := app.webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded with %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignWebhookPayload(t *testing.T) {
+	body := []byte(`{"event":"job.completed"}`)
+
+	// The expected signatures were computed independently, with Python's
+	// hmac module.
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp int64
+		body      []byte
+		want      string
+	}{
+		{"payload", "whsec_test", 1700000000, body, "t=1700000000,v1=51be9920773f454007b9aaf2ef84578604f287a1ad8b1cf6918458c66aac6bd8"},
+		{"other secret", "other", 1700000000, body, "t=1700000000,v1=f292f537f3361ea36923d5397f4b7a2a068dc85301bd37a95eef86df12e034f2"},
+		{"empty body", "whsec_test", 1700000000, nil, "t=1700000000,v1=5967f3c560522fa40cf2876ebc3c3a08551dd6959aaade3b413460591895bdcc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := signWebhookPayload(tt.secret, tt.timestamp, tt.body); got != tt.want {
+				t.Errorf("signWebhookPayload() = %q; want %q", got, tt.want)
+			}
+		})
+	}
+
+	// The timestamp is signed too, so it can't be changed to replay a
+	// request later.
+	_, first, _ := strings.Cut(signWebhookPayload("whsec_test", 1700000000, body), ",v1=")
+	_, second, _ := strings.Cut(signWebhookPayload("whsec_test", 1700000001, body), ",v1=")
+	if first == second {
+		t.Errorf("signatures for different timestamps match")
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{4, 4 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, 64 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := webhookBackoff(tt.attempts); got != tt.want {
+			t.Errorf("webhookBackoff(%d) = %v; want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
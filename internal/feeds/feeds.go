@@ -0,0 +1,172 @@
+// Package feeds fetches podcast feeds in RSS 2.0 or Atom format and
+// normalises their episodes.
+package feeds
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Feed struct {
+	Title string
+	Items []Item
+}
+
+// Item is an episode with an audio enclosure. Entries without one are
+// dropped while parsing.
+type Item struct {
+	GUID          string
+	Title         string
+	Published     time.Time
+	EnclosureURL  string
+	EnclosureType string
+}
+
+type rssDocument struct {
+	XMLName xml.Name `xml:"rss"`
+	Channel struct {
+		Title string `xml:"title"`
+		Items []struct {
+			GUID      string `xml:"guid"`
+			Title     string `xml:"title"`
+			PubDate   string `xml:"pubDate"`
+			Enclosure struct {
+				URL  string `xml:"url,attr"`
+				Type string `xml:"type,attr"`
+			} `xml:"enclosure"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+type atomDocument struct {
+	XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string   `xml:"title"`
+	Entries []struct {
+		ID        string `xml:"id"`
+		Title     string `xml:"title"`
+		Published string `xml:"published"`
+		Updated   string `xml:"updated"`
+		Links     []struct {
+			Rel  string `xml:"rel,attr"`
+			Href string `xml:"href,attr"`
+			Type string `xml:"type,attr"`
+		} `xml:"link"`
+	} `xml:"entry"`
+}
+
+// Fetch downloads and parses the feed at url.
+func Fetch(client *http.Client, url string) (Feed, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return Feed{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Feed{}, fmt.Errorf("fetching feed: unexpected status %s", resp.Status)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 10*1024*1024))
+	if err != nil {
+		return Feed{}, err
+	}
+
+	return Parse(b)
+}
+
+// Parse decodes an RSS 2.0 or Atom document. Items are returned oldest
+// first so they can be queued in publication order.
+func Parse(b []byte) (Feed, error) {
+	var (
+		feed Feed
+		rss  rssDocument
+		atom atomDocument
+	)
+
+	if err := xml.Unmarshal(b, &rss); err == nil {
+		feed.Title = strings.TrimSpace(rss.Channel.Title)
+
+		for _, item := range rss.Channel.Items {
+			if item.Enclosure.URL == "" {
+				continue
+			}
+
+			guid := strings.TrimSpace(item.GUID)
+			if guid == "" {
+				guid = item.Enclosure.URL
+			}
+
+			feed.Items = append(feed.Items, Item{
+				GUID:          guid,
+				Title:         strings.TrimSpace(item.Title),
+				Published:     parseTime(item.PubDate),
+				EnclosureURL:  item.Enclosure.URL,
+				EnclosureType: item.Enclosure.Type,
+			})
+		}
+	} else if err := xml.Unmarshal(b, &atom); err == nil {
+		feed.Title = strings.TrimSpace(atom.Title)
+
+		for _, entry := range atom.Entries {
+			for _, link := range entry.Links {
+				if link.Rel != "enclosure" {
+					continue
+				}
+
+				published := entry.Published
+				if published == "" {
+					published = entry.Updated
+				}
+
+				guid := strings.TrimSpace(entry.ID)
+				if guid == "" {
+					guid = link.Href
+				}
+
+				feed.Items = append(feed.Items, Item{
+					GUID:          guid,
+					Title:         strings.TrimSpace(entry.Title),
+					Published:     parseTime(published),
+					EnclosureURL:  link.Href,
+					EnclosureType: link.Type,
+				})
+				break
+			}
+		}
+	} else {
+		return Feed{}, errors.New("not an RSS or Atom feed")
+	}
+
+	sort.SliceStable(feed.Items, func(i, j int) bool {
+		return feed.Items[i].Published.Before(feed.Items[j].Published)
+	})
+
+	return feed, nil
+}
+
+var timeLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC3339,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+}
+
+func parseTime(s string) time.Time {
+	s = strings.TrimSpace(s)
+
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
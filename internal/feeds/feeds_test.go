@@ -0,0 +1,155 @@
+package feeds
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const rssFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+  <channel>
+    <title> The Show </title>
+    <item>
+      <guid>episode-2</guid>
+      <title>Episode 2</title>
+      <pubDate>Tue, 09 Jan 2024 10:00:00 +0000</pubDate>
+      <enclosure url="https://example.com/2.mp3" type="audio/mpeg" length="1"/>
+    </item>
+    <item>
+      <title>Episode 1</title>
+      <pubDate>Tue, 2 Jan 2024 10:00:00 GMT</pubDate>
+      <enclosure url="https://example.com/1.mp3" type="audio/mpeg" length="1"/>
+    </item>
+    <item>
+      <guid>announcement</guid>
+      <title>No audio</title>
+      <pubDate>Wed, 03 Jan 2024 10:00:00 +0000</pubDate>
+    </item>
+    <item>
+      <guid>episode-3</guid>
+      <title>Episode 3</title>
+      <pubDate>Tue, 16 Jan 2024 10:00:00 +0000</pubDate>
+      <enclosure url="https://example.com/3.m4a" type="audio/x-m4a" length="1"/>
+    </item>
+  </channel>
+</rss>`
+
+const atomFeed = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>The Atom Show</title>
+  <entry>
+    <id>urn:episode:2</id>
+    <title>Second</title>
+    <updated>2024-02-09T10:00:00Z</updated>
+    <link rel="alternate" href="https://example.com/2.html"/>
+    <link rel="enclosure" href="https://example.com/2.mp3" type="audio/mpeg"/>
+  </entry>
+  <entry>
+    <title>First</title>
+    <published>2024-02-02T10:00:00Z</published>
+    <updated>2024-03-01T10:00:00Z</updated>
+    <link rel="enclosure" href="https://example.com/1.mp3" type="audio/mpeg"/>
+  </entry>
+  <entry>
+    <id>urn:post</id>
+    <title>Blog post</title>
+    <updated>2024-02-05T10:00:00Z</updated>
+    <link rel="alternate" href="https://example.com/post.html"/>
+  </entry>
+</feed>`
+
+func TestFetch(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		wantTitle string
+		wantItems []Item
+	}{
+		{
+			name:      "RSS",
+			body:      rssFeed,
+			wantTitle: "The Show",
+			wantItems: []Item{
+				// Episode 1 has no GUID, so its enclosure URL stands in.
+				{GUID: "https://example.com/1.mp3", Title: "Episode 1", Published: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC), EnclosureURL: "https://example.com/1.mp3", EnclosureType: "audio/mpeg"},
+				{GUID: "episode-2", Title: "Episode 2", Published: time.Date(2024, 1, 9, 10, 0, 0, 0, time.UTC), EnclosureURL: "https://example.com/2.mp3", EnclosureType: "audio/mpeg"},
+				{GUID: "episode-3", Title: "Episode 3", Published: time.Date(2024, 1, 16, 10, 0, 0, 0, time.UTC), EnclosureURL: "https://example.com/3.m4a", EnclosureType: "audio/x-m4a"},
+			},
+		},
+		{
+			name:      "Atom",
+			body:      atomFeed,
+			wantTitle: "The Atom Show",
+			wantItems: []Item{
+				{GUID: "https://example.com/1.mp3", Title: "First", Published: time.Date(2024, 2, 2, 10, 0, 0, 0, time.UTC), EnclosureURL: "https://example.com/1.mp3", EnclosureType: "audio/mpeg"},
+				{GUID: "urn:episode:2", Title: "Second", Published: time.Date(2024, 2, 9, 10, 0, 0, 0, time.UTC), EnclosureURL: "https://example.com/2.mp3", EnclosureType: "audio/mpeg"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/xml")
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			feed, err := Fetch(server.Client(), server.URL+"/feed.xml")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if feed.Title != tt.wantTitle {
+				t.Errorf("got title %q; want %q", feed.Title, tt.wantTitle)
+			}
+
+			if len(feed.Items) != len(tt.wantItems) {
+				t.Fatalf("got %d items; want %d: %+v", len(feed.Items), len(tt.wantItems), feed.Items)
+			}
+
+			for i, item := range feed.Items {
+				want := tt.wantItems[i]
+				if item.GUID != want.GUID || item.Title != want.Title || !item.Published.Equal(want.Published) ||
+					item.EnclosureURL != want.EnclosureURL || item.EnclosureType != want.EnclosureType {
+					t.Errorf("item %d = %+v; want %+v", i, item, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing.xml":
+			http.NotFound(w, r)
+		default:
+			w.Write([]byte("<html><body>Not a feed</body></html>"))
+		}
+	}))
+	defer server.Close()
+
+	for _, path := range []string{"/missing.xml", "/page.html"} {
+		_, err := Fetch(server.Client(), server.URL+path)
+		if err == nil {
+			t.Errorf("Fetch(%s) succeeded; want an error", path)
+		}
+	}
+}
+
+func TestParseKeepsOrderWithoutDates(t *testing.T) {
+	feed, err := Parse([]byte(`<rss><channel>
+		<item><guid>b</guid><enclosure url="https://example.com/b.mp3"/></item>
+		<item><guid>a</guid><enclosure url="https://example.com/a.mp3"/></item>
+	</channel></rss>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(feed.Items) != 2 || feed.Items[0].GUID != "b" || feed.Items[1].GUID != "a" {
+		t.Errorf("got %+v; want the items in the feed's order", feed.Items)
+	}
+}
@@ -0,0 +1,11 @@
+package models
+
+import "errors"
+
+var (
+	ErrNoRecord = errors.New("models: no matching record found")
+
+	// ErrStatusChanged is returned by JobModel.Transition when the job has
+	// already moved on from the status the caller expected.
+	ErrStatusChanged = errors.New("models: job status has changed")
+)
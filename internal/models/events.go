@@ -0,0 +1,78 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	JobEventStatus   = "status"
+	JobEventProgress = "progress"
+)
+
+// JobEvent is one entry in a job's progress history. IDs increase
+// monotonically, so clients can resume a stream from the last ID they saw.
+type JobEvent struct {
+	ID      int64
+	JobID   string
+	Type    string
+	Data    string
+	Created time.Time
+}
+
+type JobEventModel struct {
+	DB *sql.DB
+}
+
+func (m *JobEventModel) Insert(jobID string, eventType string, data string) (JobEvent, error) {
+	event := JobEvent{
+		JobID:   jobID,
+		Type:    eventType,
+		Data:    data,
+		Created: time.Now().UTC(),
+	}
+
+	stmt := `INSERT INTO job_events (job_id, type, data, created) VALUES (?, ?, ?, ?)`
+
+	result, err := m.DB.Exec(stmt, event.JobID, event.Type, event.Data, event.Created)
+	if err != nil {
+		return JobEvent{}, err
+	}
+
+	event.ID, err = result.LastInsertId()
+	if err != nil {
+		return JobEvent{}, err
+	}
+
+	return event, nil
+}
+
+// ListAfter returns the job's events with an ID greater than afterID, oldest
+// first.
+func (m *JobEventModel) ListAfter(jobID string, afterID int64) ([]JobEvent, error) {
+	stmt := `SELECT id, job_id, type, data, created FROM job_events WHERE job_id = ? AND id > ? ORDER BY id`
+
+	rows, err := m.DB.Query(stmt, jobID, afterID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []JobEvent{}
+
+	for rows.Next() {
+		var e JobEvent
+
+		err := rows.Scan(&e.ID, &e.JobID, &e.Type, &e.Data, &e.Created)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
@@ -0,0 +1,173 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrDuplicateFeed = errors.New("models: already subscribed to this feed")
+
+// Feed is a podcast subscription whose new episodes are transcribed into a
+// Notion database.
+type Feed struct {
+	ID               int64
+	UserID           string
+	URL              string
+	Title            string
+	NotionDatabaseID string
+	LastChecked      *time.Time
+	LastError        string
+	Created          time.Time
+}
+
+type FeedModel struct {
+	DB *sql.DB
+}
+
+const feedColumns = `id, user_id, url, title, notion_database_id, last_checked, last_error, created`
+
+func scanFeed(row scanner) (Feed, error) {
+	var (
+		f           Feed
+		lastChecked sql.NullTime
+	)
+
+	err := row.Scan(&f.ID, &f.UserID, &f.URL, &f.Title, &f.NotionDatabaseID, &lastChecked, &f.LastError, &f.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Feed{}, ErrNoRecord
+		}
+		return Feed{}, err
+	}
+
+	if lastChecked.Valid {
+		f.LastChecked = &lastChecked.Time
+	}
+
+	return f, nil
+}
+
+func (m *FeedModel) Insert(feed Feed) (Feed, error) {
+	feed.Created = time.Now().UTC()
+
+	stmt := `INSERT INTO feeds (user_id, url, title, notion_database_id, created) VALUES (?, ?, ?, ?, ?)`
+
+	result, err := m.DB.Exec(stmt, feed.UserID, feed.URL, feed.Title, feed.NotionDatabaseID, feed.Created)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return Feed{}, ErrDuplicateFeed
+		}
+		return Feed{}, err
+	}
+
+	feed.ID, err = result.LastInsertId()
+	if err != nil {
+		return Feed{}, err
+	}
+
+	return feed, nil
+}
+
+func (m *FeedModel) query(stmt string, args ...any) ([]Feed, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	feeds := []Feed{}
+
+	for rows.Next() {
+		f, err := scanFeed(rows)
+		if err != nil {
+			return nil, err
+		}
+		feeds = append(feeds, f)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return feeds, nil
+}
+
+func (m *FeedModel) All() ([]Feed, error) {
+	return m.query(`SELECT ` + feedColumns + ` FROM feeds ORDER BY id`)
+}
+
+func (m *FeedModel) ListForUser(userID string) ([]Feed, error) {
+	return m.query(`SELECT `+feedColumns+` FROM feeds WHERE user_id = ? ORDER BY title`, userID)
+}
+
+func (m *FeedModel) Delete(id int64, userID string) error {
+	result, err := m.DB.Exec(`DELETE FROM feeds WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// Checked records the outcome of polling the feed. An empty checkErr clears
+// any previous error.
+func (m *FeedModel) Checked(id int64, title string, checkErr string) error {
+	stmt := `UPDATE feeds SET title = CASE WHEN ? = '' THEN title ELSE ? END, last_checked = ?, last_error = ?
+	WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, title, title, time.Now().UTC(), checkErr, id)
+	return err
+}
+
+// Claim records an episode as taken before it is queued, reporting false if
+// it was already recorded. Claiming in a single statement means that polls
+// running at the same time can't both queue the episode.
+func (m *FeedModel) Claim(feedID int64, guid string, title string) (bool, error) {
+	stmt := `INSERT INTO feed_items (feed_id, guid, title, created) VALUES (?, ?, ?, ?)
+	ON CONFLICT (feed_id, guid) DO NOTHING`
+
+	result, err := m.DB.Exec(stmt, feedID, guid, title, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// SetJob links a claimed episode to the job that was queued for it.
+func (m *FeedModel) SetJob(feedID int64, guid string, jobID string) error {
+	_, err := m.DB.Exec(`UPDATE feed_items SET job_id = ? WHERE feed_id = ? AND guid = ?`, jobID, feedID, guid)
+	return err
+}
+
+// Release gives up a claim on an episode that couldn't be queued, so it is
+// tried again on the next poll.
+func (m *FeedModel) Release(feedID int64, guid string) error {
+	_, err := m.DB.Exec(`DELETE FROM feed_items WHERE feed_id = ? AND guid = ? AND job_id IS NULL`, feedID, guid)
+	return err
+}
+
+// MarkSeen records an episode without queueing it, so it is never queued.
+// It is used for the older episodes already in a feed when subscribing.
+func (m *FeedModel) MarkSeen(feedID int64, guid string, title string) error {
+	stmt := `INSERT OR IGNORE INTO feed_items (feed_id, guid, title, created) VALUES (?, ?, ?, ?)`
+
+	_, err := m.DB.Exec(stmt, feedID, guid, title, time.Now().UTC())
+	return err
+}
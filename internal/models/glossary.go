@@ -0,0 +1,120 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// GlossaryTerm is a name, acronym or product term that transcripts should
+// spell correctly. Misspellings lists the ways Whisper tends to get it
+// wrong, which are replaced with Term after transcription. Shared terms
+// apply to everyone in the owner's Notion workspace.
+type GlossaryTerm struct {
+	ID           int64
+	UserID       string
+	WorkspaceID  string
+	Term         string
+	Misspellings []string
+	Shared       bool
+	Created      time.Time
+}
+
+type GlossaryModel struct {
+	DB *sql.DB
+}
+
+const glossaryColumns = `id, user_id, workspace_id, term, misspellings, shared, created`
+
+func scanGlossaryTerm(row scanner) (GlossaryTerm, error) {
+	var (
+		t            GlossaryTerm
+		misspellings string
+	)
+
+	err := row.Scan(&t.ID, &t.UserID, &t.WorkspaceID, &t.Term, &misspellings, &t.Shared, &t.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return GlossaryTerm{}, ErrNoRecord
+		}
+		return GlossaryTerm{}, err
+	}
+
+	if misspellings != "" {
+		t.Misspellings = strings.Split(misspellings, "\n")
+	}
+
+	return t, nil
+}
+
+func (m *GlossaryModel) Insert(term GlossaryTerm) (GlossaryTerm, error) {
+	term.Created = time.Now().UTC()
+
+	stmt := `INSERT INTO glossary_terms (user_id, workspace_id, term, misspellings, shared, created)
+	VALUES (?, ?, ?, ?, ?, ?)`
+
+	result, err := m.DB.Exec(stmt, term.UserID, term.WorkspaceID, term.Term,
+		strings.Join(term.Misspellings, "\n"), term.Shared, term.Created)
+	if err != nil {
+		return GlossaryTerm{}, err
+	}
+
+	term.ID, err = result.LastInsertId()
+	if err != nil {
+		return GlossaryTerm{}, err
+	}
+
+	return term, nil
+}
+
+// ForUser returns the user's own terms along with the terms shared by
+// anyone in their workspace, alphabetically.
+func (m *GlossaryModel) ForUser(userID string) ([]GlossaryTerm, error) {
+	stmt := `SELECT ` + glossaryColumns + ` FROM glossary_terms
+	WHERE user_id = ?
+	OR (shared = 1 AND workspace_id = (SELECT workspace_id FROM users WHERE id = ?))
+	ORDER BY term COLLATE NOCASE`
+
+	rows, err := m.DB.Query(stmt, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	terms := []GlossaryTerm{}
+
+	for rows.Next() {
+		t, err := scanGlossaryTerm(rows)
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return terms, nil
+}
+
+// Delete removes one of the user's own terms. Shared terms can only be
+// deleted by the person who added them.
+func (m *GlossaryModel) Delete(id int64, userID string) error {
+	result, err := m.DB.Exec(`DELETE FROM glossary_terms WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// IdempotencyKeyLifetime is how long an Idempotency-Key is remembered.
+// After that the same key can be used for a new request.
+const IdempotencyKeyLifetime = 24 * time.Hour
+
+// IdempotencyKey records the job an API request created, so that a client
+// retrying the request with the same Idempotency-Key gets that job back
+// instead of a second one. RequestHash identifies what was submitted, to
+// catch a key being reused for a different request, and JobID is empty
+// while the first request is still being handled.
+type IdempotencyKey struct {
+	UserID      string
+	Key         string
+	RequestHash string
+	JobID       string
+	Created     time.Time
+}
+
+type IdempotencyKeyModel struct {
+	DB *sql.DB
+}
+
+// Reserve claims the key for a request, returning true if it was free. If
+// it wasn't, it returns the request that claimed it instead.
+func (m *IdempotencyKeyModel) Reserve(userID string, key string, requestHash string) (IdempotencyKey, bool, error) {
+	now := time.Now().UTC()
+
+	_, err := m.DB.Exec(`DELETE FROM idempotency_keys WHERE created < ?`, now.Add(-IdempotencyKeyLifetime))
+	if err != nil {
+		return IdempotencyKey{}, false, err
+	}
+
+	stmt := `INSERT INTO idempotency_keys (user_id, key, request_hash, created) VALUES (?, ?, ?, ?)
+	ON CONFLICT (user_id, key) DO NOTHING`
+
+	result, err := m.DB.Exec(stmt, userID, key, requestHash, now)
+	if err != nil {
+		return IdempotencyKey{}, false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return IdempotencyKey{}, false, err
+	}
+
+	if n == 1 {
+		return IdempotencyKey{UserID: userID, Key: key, RequestHash: requestHash, Created: now}, true, nil
+	}
+
+	var k IdempotencyKey
+
+	stmt = `SELECT user_id, key, request_hash, job_id, created FROM idempotency_keys WHERE user_id = ? AND key = ?`
+
+	err = m.DB.QueryRow(stmt, userID, key).Scan(&k.UserID, &k.Key, &k.RequestHash, &k.JobID, &k.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return IdempotencyKey{}, false, ErrNoRecord
+		}
+		return IdempotencyKey{}, false, err
+	}
+
+	return k, false, nil
+}
+
+// Exists reports whether the key has been claimed by a request that is
+// still remembered.
+func (m *IdempotencyKeyModel) Exists(userID string, key string) (bool, error) {
+	var exists bool
+
+	stmt := `SELECT EXISTS(SELECT true FROM idempotency_keys WHERE user_id = ? AND key = ? AND created >= ?)`
+
+	err := m.DB.QueryRow(stmt, userID, key, time.Now().UTC().Add(-IdempotencyKeyLifetime)).Scan(&exists)
+	return exists, err
+}
+
+// SetJob records the job created by the request that reserved the key.
+func (m *IdempotencyKeyModel) SetJob(userID string, key string, jobID string) error {
+	stmt := `UPDATE idempotency_keys SET job_id = ? WHERE user_id = ? AND key = ?`
+
+	_, err := m.DB.Exec(stmt, jobID, userID, key)
+	return err
+}
+
+// Release frees a key whose request failed without creating a job, so the
+// client can retry it.
+func (m *IdempotencyKeyModel) Release(userID string, key string) error {
+	stmt := `DELETE FROM idempotency_keys WHERE user_id = ? AND key = ? AND job_id = ''`
+
+	_, err := m.DB.Exec(stmt, userID, key)
+	return err
+}
@@ -0,0 +1,121 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIdempotencyKeyReserve(t *testing.T) {
+	db := newTestDB(t)
+	keys := &IdempotencyKeyModel{DB: db}
+
+	check := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, reserved, err := keys.Reserve("user", "key", "hash")
+	check(err)
+	if !reserved {
+		t.Fatal("a new key wasn't reserved")
+	}
+
+	// A retry is told about the first request, whose job isn't known yet.
+	k, reserved, err := keys.Reserve("user", "key", "other-hash")
+	check(err)
+	if reserved || k.RequestHash != "hash" || k.JobID != "" {
+		t.Errorf("retry got %+v, reserved %t; want the first request's", k, reserved)
+	}
+
+	check(keys.SetJob("user", "key", "job"))
+
+	k, reserved, err = keys.Reserve("user", "key", "hash")
+	check(err)
+	if reserved || k.JobID != "job" {
+		t.Errorf("retry got %+v, reserved %t; want job", k, reserved)
+	}
+
+	// Keys are per user.
+	_, reserved, err = keys.Reserve("other", "key", "hash")
+	check(err)
+	if !reserved {
+		t.Error("another user's key clashed")
+	}
+
+	exists, err := keys.Exists("user", "key")
+	check(err)
+	if !exists {
+		t.Error("Exists got false for a reserved key")
+	}
+}
+
+func TestIdempotencyKeyRelease(t *testing.T) {
+	db := newTestDB(t)
+	keys := &IdempotencyKeyModel{DB: db}
+
+	check := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, _, err := keys.Reserve("user", "failed", "hash")
+	check(err)
+	check(keys.Release("user", "failed"))
+
+	_, reserved, err := keys.Reserve("user", "failed", "hash")
+	check(err)
+	if !reserved {
+		t.Error("a released key couldn't be reserved again")
+	}
+
+	// A key whose request created a job is kept.
+	_, _, err = keys.Reserve("user", "done", "hash")
+	check(err)
+	check(keys.SetJob("user", "done", "job"))
+	check(keys.Release("user", "done"))
+
+	k, reserved, err := keys.Reserve("user", "done", "hash")
+	check(err)
+	if reserved || k.JobID != "job" {
+		t.Errorf("got %+v, reserved %t; want the key kept with its job", k, reserved)
+	}
+}
+
+func TestIdempotencyKeyExpires(t *testing.T) {
+	db := newTestDB(t)
+	keys := &IdempotencyKeyModel{DB: db}
+
+	_, _, err := keys.Reserve("user", "key", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = keys.SetJob("user", "key", "job")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec(`UPDATE idempotency_keys SET created = ?`, time.Now().UTC().Add(-IdempotencyKeyLifetime-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exists, err := keys.Exists("user", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("Exists got true for an expired key")
+	}
+
+	k, reserved, err := keys.Reserve("user", "key", "new-hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reserved || k.RequestHash != "new-hash" {
+		t.Errorf("got %+v, reserved %t; want the expired key reserved afresh", k, reserved)
+	}
+}
@@ -0,0 +1,365 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	JobQueued       = "queued"
+	JobTranscribing = "transcribing"
+	JobSummarizing  = "summarizing"
+	JobReview       = "review"
+	JobPublishing   = "publishing"
+	JobCompleted    = "completed"
+	JobFailed       = "failed"
+)
+
+// Job is one audio file's trip through the pipeline. Jobs with Review set
+// stop in JobReview after summarizing until the user approves them.
+// Language is the spoken language the user chose, empty for Whisper to
+// detect, and DetectedLanguage is what Whisper reported hearing. Template
+// is a JSON copy of the SummaryTemplate the job was submitted with, so
+// editing or deleting the template doesn't change jobs already using it.
+// Usage adds up what the job has cost in OpenAI calls so far. Jobs with
+// Preprocess set have silence trimmed and loudness evened out before
+// transcription, recording the audio's length before and after. Jobs with
+// Diarize set label who said what, and Speakers holds the labelled
+// transcript as JSON. Segments holds the transcript's timing as Whisper
+// reported it, also as JSON. Jobs with AttachAudio set embed the original
+// audio in their Notion page. NotionBlockIDs lists, as JSON, the blocks the
+// job added to its Notion page, so running it again can replace them
+// without touching anything the user added. AudioHash is the hex SHA-256
+// of the uploaded audio, used to spot the same recording being submitted
+// twice.
+type Job struct {
+	ID               string
+	UserID           string
+	NotionDatabaseID string
+	Filename         string
+	StoragePath      string
+	ContentType      string
+	Status           string
+	Error            string
+	Transcript       string
+	Summary          string
+	NotionPageID     string
+	NotionPageURL    string
+	Review           bool
+	Language         string
+	Translate        bool
+	SummaryLanguage  string
+	DetectedLanguage string
+	Template         string
+	Usage            Usage
+	Preprocess       bool
+	CompressSilence  bool
+	OriginalSeconds  float64
+	ProcessedSeconds float64
+	Diarize          bool
+	Speakers         string
+	Segments         string
+	AttachAudio      bool
+	NotionBlockIDs   string
+	AudioHash        string
+	Created          time.Time
+	Updated          time.Time
+}
+
+type JobModel struct {
+	DB *sql.DB
+}
+
+const jobColumns = `id, user_id, notion_database_id, filename, storage_path, content_type, status, error,
+	transcript, summary, notion_page_id, notion_page_url, review, language, translate, summary_language, detected_language, template,
+	audio_seconds, prompt_tokens, completion_tokens, cost, preprocess, compress_silence, original_seconds, processed_seconds,
+	diarize, speakers, segments, attach_audio, notion_block_ids, audio_hash, created, updated`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanJob(row scanner) (Job, error) {
+	var j Job
+
+	err := row.Scan(&j.ID, &j.UserID, &j.NotionDatabaseID, &j.Filename, &j.StoragePath, &j.ContentType,
+		&j.Status, &j.Error, &j.Transcript, &j.Summary, &j.NotionPageID, &j.NotionPageURL, &j.Review, &j.Language, &j.Translate, &j.SummaryLanguage, &j.DetectedLanguage, &j.Template,
+		&j.Usage.AudioSeconds, &j.Usage.PromptTokens, &j.Usage.CompletionTokens, &j.Usage.Cost,
+		&j.Preprocess, &j.CompressSilence, &j.OriginalSeconds, &j.ProcessedSeconds,
+		&j.Diarize, &j.Speakers, &j.Segments, &j.AttachAudio, &j.NotionBlockIDs, &j.AudioHash, &j.Created, &j.Updated)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, ErrNoRecord
+		}
+		return Job{}, err
+	}
+
+	return j, nil
+}
+
+// Insert creates a queued job and returns it with its generated ID and
+// timestamps filled in.
+func (m *JobModel) Insert(job Job) (Job, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return Job{}, err
+	}
+
+	job.ID = id.String()
+	job.Status = JobQueued
+	job.Created = time.Now().UTC()
+	job.Updated = job.Created
+
+	stmt := `INSERT INTO jobs (id, user_id, notion_database_id, filename, storage_path, content_type, status, review,
+	language, translate, summary_language, template, preprocess, compress_silence, diarize, attach_audio, audio_hash, created, updated)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return Job{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(stmt, job.ID, job.UserID, job.NotionDatabaseID, job.Filename, job.StoragePath,
+		job.ContentType, job.Status, job.Review, job.Language, job.Translate, job.SummaryLanguage, job.Template,
+		job.Preprocess, job.CompressSilence, job.Diarize, job.AttachAudio, job.AudioHash, job.Created, job.Updated)
+	if err != nil {
+		return Job{}, err
+	}
+
+	err = startRun(tx, job.ID, job.Created)
+	if err != nil {
+		return Job{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return Job{}, err
+	}
+
+	return job, nil
+}
+
+// startRun records that a job has been queued to run. Usage is recorded
+// against the job's latest run as well as the job, so that it counts in
+// the month the work was done rather than the month the job was created.
+func startRun(tx *sql.Tx, jobID string, started time.Time) error {
+	_, err := tx.Exec(`INSERT INTO job_runs (job_id, started) VALUES (?, ?)`, jobID, started)
+	return err
+}
+
+func (m *JobModel) Get(id string) (Job, error) {
+	stmt := `SELECT ` + jobColumns + ` FROM jobs WHERE id = ?`
+
+	return scanJob(m.DB.QueryRow(stmt, id))
+}
+
+// ListForUser returns the user's jobs, newest first.
+func (m *JobModel) ListForUser(userID string, limit int, offset int) ([]Job, error) {
+	stmt := `SELECT ` + jobColumns + ` FROM jobs WHERE user_id = ?
+	ORDER BY created DESC LIMIT ? OFFSET ?`
+
+	rows, err := m.DB.Query(stmt, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// FindDuplicate returns the user's latest job that hasn't failed for the
+// same audio and Notion database, or ErrNoRecord if there isn't one.
+func (m *JobModel) FindDuplicate(userID string, notionDatabaseID string, audioHash string) (Job, error) {
+	stmt := `SELECT ` + jobColumns + ` FROM jobs WHERE user_id = ? AND notion_database_id = ? AND audio_hash = ?
+	AND status != ? ORDER BY created DESC LIMIT 1`
+
+	return scanJob(m.DB.QueryRow(stmt, userID, notionDatabaseID, audioHash, JobFailed))
+}
+
+func (m *JobModel) SetStatus(id string, status string) error {
+	stmt := `UPDATE jobs SET status = ?, updated = ? WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, status, time.Now().UTC(), id)
+	return err
+}
+
+func (m *JobModel) SetTranscript(id string, transcript string) error {
+	stmt := `UPDATE jobs SET transcript = ?, updated = ? WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, transcript, time.Now().UTC(), id)
+	return err
+}
+
+func (m *JobModel) SetDetectedLanguage(id string, language string) error {
+	stmt := `UPDATE jobs SET detected_language = ?, updated = ? WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, language, time.Now().UTC(), id)
+	return err
+}
+
+func (m *JobModel) SetSpeakers(id string, speakers string) error {
+	stmt := `UPDATE jobs SET speakers = ?, updated = ? WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, speakers, time.Now().UTC(), id)
+	return err
+}
+
+func (m *JobModel) SetSegments(id string, segments string) error {
+	stmt := `UPDATE jobs SET segments = ?, updated = ? WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, segments, time.Now().UTC(), id)
+	return err
+}
+
+func (m *JobModel) SetSummary(id string, summary string) error {
+	stmt := `UPDATE jobs SET summary = ?, updated = ? WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, summary, time.Now().UTC(), id)
+	return err
+}
+
+// SetDurations records the length of the job's audio before and after
+// preprocessing.
+func (m *JobModel) SetDurations(id string, originalSeconds float64, processedSeconds float64) error {
+	stmt := `UPDATE jobs SET original_seconds = ?, processed_seconds = ?, updated = ? WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, originalSeconds, processedSeconds, time.Now().UTC(), id)
+	return err
+}
+
+// AddUsage adds to the audio, tokens and cost recorded against the job and
+// its latest run.
+func (m *JobModel) AddUsage(id string, usage Usage) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `UPDATE jobs SET audio_seconds = audio_seconds + ?, prompt_tokens = prompt_tokens + ?,
+	completion_tokens = completion_tokens + ?, cost = cost + ?, updated = ? WHERE id = ?`
+
+	_, err = tx.Exec(stmt, usage.AudioSeconds, usage.PromptTokens, usage.CompletionTokens, usage.Cost,
+		time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+
+	stmt = `UPDATE job_runs SET audio_seconds = audio_seconds + ?, prompt_tokens = prompt_tokens + ?,
+	completion_tokens = completion_tokens + ?, cost = cost + ?
+	WHERE id = (SELECT MAX(id) FROM job_runs WHERE job_id = ?)`
+
+	_, err = tx.Exec(stmt, usage.AudioSeconds, usage.PromptTokens, usage.CompletionTokens, usage.Cost, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetError records a problem with the job without failing it.
+func (m *JobModel) SetError(id string, reason string) error {
+	stmt := `UPDATE jobs SET error = ?, updated = ? WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, reason, time.Now().UTC(), id)
+	return err
+}
+
+// Complete records the Notion page the job was published to and the
+// blocks it added there.
+func (m *JobModel) Complete(id string, notionPageID string, notionPageURL string, notionBlockIDs string) error {
+	stmt := `UPDATE jobs SET status = ?, notion_page_id = ?, notion_page_url = ?, notion_block_ids = ?, error = '', updated = ?
+	WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, JobCompleted, notionPageID, notionPageURL, notionBlockIDs, time.Now().UTC(), id)
+	return err
+}
+
+func (m *JobModel) Fail(id string, reason string) error {
+	stmt := `UPDATE jobs SET status = ?, error = ?, updated = ? WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, JobFailed, reason, time.Now().UTC(), id)
+	return err
+}
+
+// Rerun queues a completed or failed job to be processed again, returning
+// ErrStatusChanged if it is in any other status. The job keeps its Notion
+// page to update unless newPage is set, in which case it forgets the page
+// and publishes to a new one.
+func (m *JobModel) Rerun(id string, newPage bool) error {
+	stmt := `UPDATE jobs SET status = ?, error = '', updated = ? WHERE id = ? AND status IN (?, ?)`
+	if newPage {
+		stmt = `UPDATE jobs SET status = ?, error = '', notion_page_id = '', notion_page_url = '', notion_block_ids = '',
+		updated = ? WHERE id = ? AND status IN (?, ?)`
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+
+	result, err := tx.Exec(stmt, JobQueued, now, id, JobCompleted, JobFailed)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrStatusChanged
+	}
+
+	err = startRun(tx, id, now)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Transition moves a job from one status to another, returning
+// ErrStatusChanged if it is no longer in the from status, so that two
+// requests racing to act on the same job can't both succeed.
+func (m *JobModel) Transition(id string, from string, to string) error {
+	stmt := `UPDATE jobs SET status = ?, updated = ? WHERE id = ? AND status = ?`
+
+	result, err := m.DB.Exec(stmt, to, time.Now().UTC(), id, from)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrStatusChanged
+	}
+
+	return nil
+}
@@ -0,0 +1,242 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migrations are applied in order and the number applied is tracked in
+// SQLite's user_version pragma. Only ever append to this list.
+var migrations = []string{
+	`CREATE TABLE users (
+		id TEXT NOT NULL PRIMARY KEY,
+		name TEXT NOT NULL,
+		email TEXT NOT NULL,
+		workspace_id TEXT NOT NULL,
+		workspace_name TEXT NOT NULL,
+		bot_id TEXT NOT NULL,
+		access_token TEXT NOT NULL,
+		created DATETIME NOT NULL,
+		updated DATETIME NOT NULL
+	);
+	CREATE UNIQUE INDEX idx_users_access_token ON users(access_token);`,
+
+	`CREATE TABLE jobs (
+		id TEXT NOT NULL PRIMARY KEY,
+		user_id TEXT NOT NULL REFERENCES users(id),
+		notion_database_id TEXT NOT NULL,
+		filename TEXT NOT NULL,
+		storage_path TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		status TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		transcript TEXT NOT NULL DEFAULT '',
+		summary TEXT NOT NULL DEFAULT '',
+		notion_page_id TEXT NOT NULL DEFAULT '',
+		notion_page_url TEXT NOT NULL DEFAULT '',
+		created DATETIME NOT NULL,
+		updated DATETIME NOT NULL
+	);
+	CREATE INDEX idx_jobs_user_created ON jobs(user_id, created);`,
+
+	`CREATE TABLE api_tokens (
+		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		user_id TEXT NOT NULL REFERENCES users(id),
+		name TEXT NOT NULL,
+		scopes TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		hash BLOB NOT NULL,
+		created DATETIME NOT NULL,
+		last_used DATETIME,
+		revoked DATETIME
+	);
+	CREATE UNIQUE INDEX idx_api_tokens_hash ON api_tokens(hash);
+	CREATE INDEX idx_api_tokens_user ON api_tokens(user_id);`,
+
+	`CREATE TABLE feeds (
+		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		user_id TEXT NOT NULL REFERENCES users(id),
+		url TEXT NOT NULL,
+		title TEXT NOT NULL,
+		notion_database_id TEXT NOT NULL,
+		last_checked DATETIME,
+		last_error TEXT NOT NULL DEFAULT '',
+		created DATETIME NOT NULL
+	);
+	CREATE UNIQUE INDEX idx_feeds_user_url ON feeds(user_id, url);
+	CREATE TABLE feed_items (
+		feed_id INTEGER NOT NULL REFERENCES feeds(id) ON DELETE CASCADE,
+		guid TEXT NOT NULL,
+		title TEXT NOT NULL,
+		job_id TEXT REFERENCES jobs(id),
+		created DATETIME NOT NULL,
+		PRIMARY KEY (feed_id, guid)
+	);`,
+
+	`CREATE TABLE webhooks (
+		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		user_id TEXT NOT NULL REFERENCES users(id),
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL,
+		created DATETIME NOT NULL
+	);
+	CREATE INDEX idx_webhooks_user ON webhooks(user_id);
+	CREATE TABLE webhook_deliveries (
+		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+		job_id TEXT NOT NULL REFERENCES jobs(id),
+		event TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		response_status INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		next_attempt DATETIME NOT NULL,
+		created DATETIME NOT NULL,
+		updated DATETIME NOT NULL
+	);
+	CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt);
+	CREATE INDEX idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id, created);`,
+
+	`CREATE TABLE job_events (
+		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		job_id TEXT NOT NULL REFERENCES jobs(id),
+		type TEXT NOT NULL,
+		data TEXT NOT NULL,
+		created DATETIME NOT NULL
+	);
+	CREATE INDEX idx_job_events_job ON job_events(job_id, id);`,
+
+	`ALTER TABLE jobs ADD COLUMN review INTEGER NOT NULL DEFAULT 0;`,
+
+	`CREATE TABLE glossary_terms (
+		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		user_id TEXT NOT NULL REFERENCES users(id),
+		workspace_id TEXT NOT NULL,
+		term TEXT NOT NULL,
+		misspellings TEXT NOT NULL DEFAULT '',
+		shared INTEGER NOT NULL DEFAULT 0,
+		created DATETIME NOT NULL
+	);
+	CREATE INDEX idx_glossary_terms_user ON glossary_terms(user_id);
+	CREATE INDEX idx_glossary_terms_workspace ON glossary_terms(workspace_id, shared);`,
+
+	`ALTER TABLE jobs ADD COLUMN language TEXT NOT NULL DEFAULT '';
+	ALTER TABLE jobs ADD COLUMN translate INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE jobs ADD COLUMN summary_language TEXT NOT NULL DEFAULT '';
+	ALTER TABLE jobs ADD COLUMN detected_language TEXT NOT NULL DEFAULT '';`,
+
+	`CREATE TABLE summary_templates (
+		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		user_id TEXT NOT NULL REFERENCES users(id),
+		workspace_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		prompt TEXT NOT NULL,
+		fields TEXT NOT NULL,
+		shared INTEGER NOT NULL DEFAULT 0,
+		created DATETIME NOT NULL
+	);
+	CREATE INDEX idx_summary_templates_user ON summary_templates(user_id);
+	CREATE INDEX idx_summary_templates_workspace ON summary_templates(workspace_id, shared);
+	ALTER TABLE jobs ADD COLUMN template TEXT NOT NULL DEFAULT '';`,
+
+	`ALTER TABLE jobs ADD COLUMN audio_seconds REAL NOT NULL DEFAULT 0;
+	ALTER TABLE jobs ADD COLUMN prompt_tokens INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE jobs ADD COLUMN completion_tokens INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE jobs ADD COLUMN cost REAL NOT NULL DEFAULT 0;
+	CREATE TABLE job_runs (
+		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		job_id TEXT NOT NULL REFERENCES jobs(id),
+		audio_seconds REAL NOT NULL DEFAULT 0,
+		prompt_tokens INTEGER NOT NULL DEFAULT 0,
+		completion_tokens INTEGER NOT NULL DEFAULT 0,
+		cost REAL NOT NULL DEFAULT 0,
+		started DATETIME NOT NULL
+	);
+	CREATE INDEX idx_job_runs_job ON job_runs(job_id, id);
+	CREATE INDEX idx_job_runs_started ON job_runs(started);
+	INSERT INTO job_runs (job_id, started) SELECT id, created FROM jobs;`,
+
+	`CREATE TABLE quota_overrides (
+		scope TEXT NOT NULL,
+		subject_id TEXT NOT NULL,
+		minutes_per_month INTEGER NOT NULL,
+		jobs_per_day INTEGER NOT NULL,
+		concurrent_jobs INTEGER NOT NULL,
+		updated_by TEXT NOT NULL,
+		updated DATETIME NOT NULL,
+		PRIMARY KEY (scope, subject_id)
+	);`,
+
+	`ALTER TABLE jobs ADD COLUMN preprocess INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE jobs ADD COLUMN compress_silence INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE jobs ADD COLUMN original_seconds REAL NOT NULL DEFAULT 0;
+	ALTER TABLE jobs ADD COLUMN processed_seconds REAL NOT NULL DEFAULT 0;`,
+
+	`ALTER TABLE jobs ADD COLUMN diarize INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE jobs ADD COLUMN speakers TEXT NOT NULL DEFAULT '';`,
+
+	`ALTER TABLE jobs ADD COLUMN segments TEXT NOT NULL DEFAULT '';`,
+
+	`ALTER TABLE summary_templates ADD COLUMN layout TEXT NOT NULL DEFAULT '';
+	ALTER TABLE summary_templates ADD COLUMN section_order TEXT NOT NULL DEFAULT '';`,
+
+	`ALTER TABLE jobs ADD COLUMN attach_audio INTEGER NOT NULL DEFAULT 0;`,
+
+	`ALTER TABLE jobs ADD COLUMN notion_block_ids TEXT NOT NULL DEFAULT '';`,
+
+	`ALTER TABLE jobs ADD COLUMN audio_hash TEXT NOT NULL DEFAULT '';
+	CREATE INDEX idx_jobs_audio_hash ON jobs(user_id, audio_hash);
+	CREATE TABLE idempotency_keys (
+		user_id TEXT NOT NULL REFERENCES users(id),
+		key TEXT NOT NULL,
+		request_hash TEXT NOT NULL,
+		job_id TEXT NOT NULL DEFAULT '',
+		created DATETIME NOT NULL,
+		PRIMARY KEY (user_id, key)
+	);`,
+
+	`CREATE TABLE transcript_cache (
+		key TEXT NOT NULL PRIMARY KEY,
+		result TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		created DATETIME NOT NULL,
+		last_used DATETIME NOT NULL
+	);
+	CREATE INDEX idx_transcript_cache_last_used ON transcript_cache(last_used);`,
+}
+
+func Migrate(db *sql.DB) error {
+	var version int
+	err := db.QueryRow("PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(migrations[i])
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+
+		_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1))
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
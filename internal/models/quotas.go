@@ -0,0 +1,114 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	QuotaScopeUser      = "user"
+	QuotaScopeWorkspace = "workspace"
+)
+
+// Quota limits how much a user or a Notion workspace can transcribe. A
+// zero limit means no limit.
+type Quota struct {
+	MinutesPerMonth int
+	JobsPerDay      int
+	ConcurrentJobs  int
+}
+
+// QuotaOverride replaces the configured quota for one user or workspace,
+// named by Scope and SubjectID. UpdatedBy is the admin who set it.
+type QuotaOverride struct {
+	Scope     string
+	SubjectID string
+	Quota
+	UpdatedBy string
+	Updated   time.Time
+}
+
+type QuotaOverrideModel struct {
+	DB *sql.DB
+}
+
+const quotaOverrideColumns = `scope, subject_id, minutes_per_month, jobs_per_day, concurrent_jobs, updated_by, updated`
+
+func scanQuotaOverride(row scanner) (QuotaOverride, error) {
+	var o QuotaOverride
+
+	err := row.Scan(&o.Scope, &o.SubjectID, &o.MinutesPerMonth, &o.JobsPerDay, &o.ConcurrentJobs, &o.UpdatedBy, &o.Updated)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return QuotaOverride{}, ErrNoRecord
+		}
+		return QuotaOverride{}, err
+	}
+
+	return o, nil
+}
+
+// Set creates or replaces the override for the user or workspace.
+func (m *QuotaOverrideModel) Set(override QuotaOverride) error {
+	stmt := `INSERT INTO quota_overrides (` + quotaOverrideColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (scope, subject_id) DO UPDATE SET minutes_per_month = excluded.minutes_per_month,
+	jobs_per_day = excluded.jobs_per_day, concurrent_jobs = excluded.concurrent_jobs,
+	updated_by = excluded.updated_by, updated = excluded.updated`
+
+	_, err := m.DB.Exec(stmt, override.Scope, override.SubjectID, override.MinutesPerMonth, override.JobsPerDay,
+		override.ConcurrentJobs, override.UpdatedBy, time.Now().UTC())
+	return err
+}
+
+func (m *QuotaOverrideModel) Get(scope string, subjectID string) (QuotaOverride, error) {
+	stmt := `SELECT ` + quotaOverrideColumns + ` FROM quota_overrides WHERE scope = ? AND subject_id = ?`
+
+	return scanQuotaOverride(m.DB.QueryRow(stmt, scope, subjectID))
+}
+
+func (m *QuotaOverrideModel) All() ([]QuotaOverride, error) {
+	stmt := `SELECT ` + quotaOverrideColumns + ` FROM quota_overrides ORDER BY scope, subject_id`
+
+	rows, err := m.DB.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := []QuotaOverride{}
+
+	for rows.Next() {
+		o, err := scanQuotaOverride(rows)
+		if err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, o)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return overrides, nil
+}
+
+func (m *QuotaOverrideModel) Delete(scope string, subjectID string) error {
+	stmt := `DELETE FROM quota_overrides WHERE scope = ? AND subject_id = ?`
+
+	result, err := m.DB.Exec(stmt, scope, subjectID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// TemplateField is one part of a summary template's output, such as
+// "decisions" or "key_quotes". The model returns each field as a list of
+// strings, which are published under Heading as blocks of type Block.
+type TemplateField struct {
+	Key         string `json:"key"`
+	Description string `json:"description"`
+	Heading     string `json:"heading"`
+	Block       string `json:"block"`
+}
+
+// SummaryTemplate tells the summarizer what to produce from a transcript.
+// Built-in templates are identified by Key and custom ones by ID. Shared
+// custom templates can be used by everyone in the owner's workspace.
+// Layout is how the Notion page is laid out, and Order the order of its
+// sections, naming the transcript, the summary and the fields by key;
+// both are left empty for the defaults.
+type SummaryTemplate struct {
+	ID          int64           `json:"id,omitempty"`
+	Key         string          `json:"key,omitempty"`
+	UserID      string          `json:"-"`
+	WorkspaceID string          `json:"-"`
+	Name        string          `json:"name"`
+	Prompt      string          `json:"prompt"`
+	Fields      []TemplateField `json:"fields"`
+	Layout      string          `json:"layout,omitempty"`
+	Order       []string        `json:"order,omitempty"`
+	Shared      bool            `json:"-"`
+	Created     time.Time       `json:"-"`
+}
+
+type SummaryTemplateModel struct {
+	DB *sql.DB
+}
+
+const templateColumns = `id, user_id, workspace_id, name, prompt, fields, layout, section_order, shared, created`
+
+func scanTemplate(row scanner) (SummaryTemplate, error) {
+	var (
+		t      SummaryTemplate
+		fields string
+		order  string
+	)
+
+	err := row.Scan(&t.ID, &t.UserID, &t.WorkspaceID, &t.Name, &t.Prompt, &fields, &t.Layout, &order, &t.Shared, &t.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return SummaryTemplate{}, ErrNoRecord
+		}
+		return SummaryTemplate{}, err
+	}
+
+	err = json.Unmarshal([]byte(fields), &t.Fields)
+	if err != nil {
+		return SummaryTemplate{}, err
+	}
+
+	if order != "" {
+		err = json.Unmarshal([]byte(order), &t.Order)
+		if err != nil {
+			return SummaryTemplate{}, err
+		}
+	}
+
+	return t, nil
+}
+
+func (m *SummaryTemplateModel) Insert(t SummaryTemplate) (SummaryTemplate, error) {
+	t.Created = time.Now().UTC()
+
+	fields, err := json.Marshal(t.Fields)
+	if err != nil {
+		return SummaryTemplate{}, err
+	}
+
+	var order []byte
+	if len(t.Order) > 0 {
+		order, err = json.Marshal(t.Order)
+		if err != nil {
+			return SummaryTemplate{}, err
+		}
+	}
+
+	stmt := `INSERT INTO summary_templates (user_id, workspace_id, name, prompt, fields, layout, section_order, shared, created)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := m.DB.Exec(stmt, t.UserID, t.WorkspaceID, t.Name, t.Prompt, string(fields), t.Layout, string(order), t.Shared, t.Created)
+	if err != nil {
+		return SummaryTemplate{}, err
+	}
+
+	t.ID, err = result.LastInsertId()
+	if err != nil {
+		return SummaryTemplate{}, err
+	}
+
+	return t, nil
+}
+
+// GetForUser returns the template if the user owns it or it has been shared
+// with their workspace.
+func (m *SummaryTemplateModel) GetForUser(id int64, userID string) (SummaryTemplate, error) {
+	stmt := `SELECT ` + templateColumns + ` FROM summary_templates
+	WHERE id = ? AND (user_id = ?
+	OR (shared = 1 AND workspace_id = (SELECT workspace_id FROM users WHERE id = ?)))`
+
+	return scanTemplate(m.DB.QueryRow(stmt, id, userID, userID))
+}
+
+// ForUser returns the user's own templates along with those shared by
+// anyone in their workspace, by name.
+func (m *SummaryTemplateModel) ForUser(userID string) ([]SummaryTemplate, error) {
+	stmt := `SELECT ` + templateColumns + ` FROM summary_templates
+	WHERE user_id = ?
+	OR (shared = 1 AND workspace_id = (SELECT workspace_id FROM users WHERE id = ?))
+	ORDER BY name COLLATE NOCASE`
+
+	rows, err := m.DB.Query(stmt, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []SummaryTemplate{}
+
+	for rows.Next() {
+		t, err := scanTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return templates, nil
+}
+
+func (m *SummaryTemplateModel) Delete(id int64, userID string) error {
+	result, err := m.DB.Exec(`DELETE FROM summary_templates WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+// newTestDB returns an empty, migrated in-memory database that is closed
+// when the test finishes.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", "file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: gets a database of its own.
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	err = Migrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
@@ -0,0 +1,184 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	ScopeRead   = "read"
+	ScopeSubmit = "submit"
+)
+
+// tokenPrefix marks personal API tokens so they are easy to recognise in
+// logs and secret scanners.
+const tokenPrefix = "ttn_"
+
+// APIToken is a personal access token for calling the API without a browser
+// session. Only a SHA-256 hash of the token is stored.
+type APIToken struct {
+	ID       int64
+	UserID   string
+	Name     string
+	Scopes   []string
+	Prefix   string
+	Created  time.Time
+	LastUsed *time.Time
+	Revoked  *time.Time
+}
+
+func (t APIToken) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+type APITokenModel struct {
+	DB *sql.DB
+}
+
+func hashToken(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
+
+// Insert generates a new token for the user and returns its plaintext value,
+// which is never retrievable again.
+func (m *APITokenModel) Insert(userID string, name string, scopes []string) (string, APIToken, error) {
+	randomBytes := make([]byte, 20)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", APIToken{}, err
+	}
+
+	plaintext := tokenPrefix + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))
+
+	token := APIToken{
+		UserID:  userID,
+		Name:    name,
+		Scopes:  scopes,
+		Prefix:  plaintext[:len(tokenPrefix)+6],
+		Created: time.Now().UTC(),
+	}
+
+	stmt := `INSERT INTO api_tokens (user_id, name, scopes, prefix, hash, created) VALUES (?, ?, ?, ?, ?, ?)`
+
+	result, err := m.DB.Exec(stmt, token.UserID, token.Name, strings.Join(scopes, ","), token.Prefix,
+		hashToken(plaintext), token.Created)
+	if err != nil {
+		return "", APIToken{}, err
+	}
+
+	token.ID, err = result.LastInsertId()
+	if err != nil {
+		return "", APIToken{}, err
+	}
+
+	return plaintext, token, nil
+}
+
+const apiTokenColumns = `id, user_id, name, scopes, prefix, created, last_used, revoked`
+
+func scanAPIToken(row scanner) (APIToken, error) {
+	var (
+		t        APIToken
+		scopes   string
+		lastUsed sql.NullTime
+		revoked  sql.NullTime
+	)
+
+	err := row.Scan(&t.ID, &t.UserID, &t.Name, &scopes, &t.Prefix, &t.Created, &lastUsed, &revoked)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIToken{}, ErrNoRecord
+		}
+		return APIToken{}, err
+	}
+
+	if scopes != "" {
+		t.Scopes = strings.Split(scopes, ",")
+	}
+	if lastUsed.Valid {
+		t.LastUsed = &lastUsed.Time
+	}
+	if revoked.Valid {
+		t.Revoked = &revoked.Time
+	}
+
+	return t, nil
+}
+
+// GetActive returns the unrevoked token matching the plaintext value and
+// records that it has been used.
+func (m *APITokenModel) GetActive(plaintext string) (APIToken, error) {
+	if !strings.HasPrefix(plaintext, tokenPrefix) {
+		return APIToken{}, ErrNoRecord
+	}
+
+	stmt := `SELECT ` + apiTokenColumns + ` FROM api_tokens WHERE hash = ? AND revoked IS NULL`
+
+	token, err := scanAPIToken(m.DB.QueryRow(stmt, hashToken(plaintext)))
+	if err != nil {
+		return APIToken{}, err
+	}
+
+	_, err = m.DB.Exec(`UPDATE api_tokens SET last_used = ? WHERE id = ?`, time.Now().UTC(), token.ID)
+	if err != nil {
+		return APIToken{}, err
+	}
+
+	return token, nil
+}
+
+// ListForUser returns all of the user's tokens, including revoked ones,
+// newest first.
+func (m *APITokenModel) ListForUser(userID string) ([]APIToken, error) {
+	stmt := `SELECT ` + apiTokenColumns + ` FROM api_tokens WHERE user_id = ? ORDER BY created DESC`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (m *APITokenModel) Revoke(id int64, userID string) error {
+	stmt := `UPDATE api_tokens SET revoked = ? WHERE id = ? AND user_id = ? AND revoked IS NULL`
+
+	result, err := m.DB.Exec(stmt, time.Now().UTC(), id, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestAPITokenInsertStoresOnlyAHash(t *testing.T) {
+	db := newTestDB(t)
+	tokens := &APITokenModel{DB: db}
+
+	plaintext, token, err := tokens.Insert("user", "laptop", []string{ScopeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(plaintext, tokenPrefix) {
+		t.Errorf("token %q doesn't start with %q", plaintext, tokenPrefix)
+	}
+	if token.Prefix != plaintext[:len(tokenPrefix)+6] {
+		t.Errorf("got prefix %q for token %q", token.Prefix, plaintext)
+	}
+
+	var hash []byte
+	err = db.QueryRow(`SELECT hash FROM api_tokens WHERE id = ?`, token.ID).Scan(&hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := sha256.Sum256([]byte(plaintext))
+	if !bytes.Equal(hash, want[:]) {
+		t.Errorf("stored hash %x; want the SHA-256 of the token, %x", hash, want)
+	}
+
+	other, _, err := tokens.Insert("user", "laptop", []string{ScopeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == plaintext {
+		t.Errorf("two tokens are both %q", plaintext)
+	}
+}
+
+func TestAPITokenGetActive(t *testing.T) {
+	db := newTestDB(t)
+	tokens := &APITokenModel{DB: db}
+
+	plaintext, inserted, err := tokens.Insert("user", "script", []string{ScopeRead, ScopeSubmit})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := tokens.GetActive(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.ID != inserted.ID || token.UserID != "user" || !slices.Equal(token.Scopes, []string{ScopeRead, ScopeSubmit}) {
+		t.Errorf("got %+v; want %+v", token, inserted)
+	}
+
+	token, err = tokens.GetActive(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.LastUsed == nil {
+		t.Errorf("using the token didn't record when")
+	}
+
+	// Only the exact token is accepted, prefix and all.
+	for _, wrong := range []string{
+		"",
+		strings.TrimPrefix(plaintext, tokenPrefix),
+		"xyz_" + strings.TrimPrefix(plaintext, tokenPrefix),
+		plaintext + "a",
+		strings.ToUpper(plaintext),
+	} {
+		_, err := tokens.GetActive(wrong)
+		if !errors.Is(err, ErrNoRecord) {
+			t.Errorf("GetActive(%q) = %v; want ErrNoRecord", wrong, err)
+		}
+	}
+
+	err = tokens.Revoke(inserted.ID, "other")
+	if !errors.Is(err, ErrNoRecord) {
+		t.Errorf("revoking another user's token: got %v; want ErrNoRecord", err)
+	}
+
+	err = tokens.Revoke(inserted.ID, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = tokens.GetActive(plaintext)
+	if !errors.Is(err, ErrNoRecord) {
+		t.Errorf("revoked token: got %v; want ErrNoRecord", err)
+	}
+}
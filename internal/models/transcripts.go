@@ -0,0 +1,85 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// TranscriptCacheModel stores transcription results by a key the pipeline
+// derives from the audio and everything else that affects the transcript,
+// so the same recording isn't paid for twice. Entries expire after TTL,
+// and once the results add up to more than MaxBytes the least recently
+// used are evicted.
+type TranscriptCacheModel struct {
+	DB       *sql.DB
+	TTL      time.Duration
+	MaxBytes int64
+}
+
+// Get returns the cached result for key, or ErrNoRecord if there isn't one
+// or it has expired.
+func (m *TranscriptCacheModel) Get(key string) (string, error) {
+	now := time.Now().UTC()
+
+	var result string
+
+	stmt := `SELECT result FROM transcript_cache WHERE key = ? AND created >= ?`
+
+	err := m.DB.QueryRow(stmt, key, now.Add(-m.TTL)).Scan(&result)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNoRecord
+		}
+		return "", err
+	}
+
+	_, err = m.DB.Exec(`UPDATE transcript_cache SET last_used = ? WHERE key = ?`, now, key)
+	if err != nil {
+		return "", err
+	}
+
+	return result, nil
+}
+
+// Put caches result under key, then evicts whatever has expired or no
+// longer fits.
+func (m *TranscriptCacheModel) Put(key string, result string) error {
+	now := time.Now().UTC()
+
+	stmt := `INSERT INTO transcript_cache (key, result, size, created, last_used) VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT (key) DO UPDATE SET result = excluded.result, size = excluded.size,
+	created = excluded.created, last_used = excluded.last_used`
+
+	_, err := m.DB.Exec(stmt, key, result, len(result), now, now)
+	if err != nil {
+		return err
+	}
+
+	return m.evict(now)
+}
+
+func (m *TranscriptCacheModel) evict(now time.Time) error {
+	_, err := m.DB.Exec(`DELETE FROM transcript_cache WHERE created < ?`, now.Add(-m.TTL))
+	if err != nil {
+		return err
+	}
+
+	// A result bigger than the whole cache can never be kept, and would
+	// otherwise push out everything used before it.
+	_, err = m.DB.Exec(`DELETE FROM transcript_cache WHERE size > ?`, m.MaxBytes)
+	if err != nil {
+		return err
+	}
+
+	// Keep the most recently used entries that fit within MaxBytes between
+	// them.
+	stmt := `DELETE FROM transcript_cache WHERE key IN (
+		SELECT key FROM (
+			SELECT key, SUM(size) OVER (ORDER BY last_used DESC, key) AS total FROM transcript_cache
+		) WHERE total > ?
+	)`
+
+	_, err = m.DB.Exec(stmt, m.MaxBytes)
+	return err
+}
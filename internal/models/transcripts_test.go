@@ -0,0 +1,165 @@
+package models
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// cachedKeys returns the keys in the transcript cache, in order.
+func cachedKeys(t *testing.T, m *TranscriptCacheModel) []string {
+	t.Helper()
+
+	rows, err := m.DB.Query(`SELECT key FROM transcript_cache ORDER BY key`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		err = rows.Scan(&key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// age moves a cache entry's timestamps back by d.
+func age(t *testing.T, m *TranscriptCacheModel, key string, column string, d time.Duration) {
+	t.Helper()
+
+	_, err := m.DB.Exec(`UPDATE transcript_cache SET `+column+` = ? WHERE key = ?`, time.Now().UTC().Add(-d), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// put caches a result, failing the test if it can't.
+func put(t *testing.T, m *TranscriptCacheModel, key string, result string) {
+	t.Helper()
+
+	err := m.Put(key, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTranscriptCache(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxBytes int64
+		run      func(t *testing.T, m *TranscriptCacheModel)
+		want     []string
+	}{
+		{
+			name:     "stores and replaces results",
+			maxBytes: 100,
+			run: func(t *testing.T, m *TranscriptCacheModel) {
+				put(t, m, "a", "first")
+				put(t, m, "a", "second")
+			},
+			want: []string{"a"},
+		},
+		{
+			name:     "expired entries are evicted",
+			maxBytes: 100,
+			run: func(t *testing.T, m *TranscriptCacheModel) {
+				put(t, m, "old", "result")
+				age(t, m, "old", "created", 2*time.Hour)
+				put(t, m, "new", "result")
+			},
+			want: []string{"new"},
+		},
+		{
+			name:     "use doesn't extend the lifetime",
+			maxBytes: 100,
+			run: func(t *testing.T, m *TranscriptCacheModel) {
+				put(t, m, "old", "result")
+				age(t, m, "old", "created", 59*time.Minute)
+				m.Get("old")
+				age(t, m, "old", "created", 61*time.Minute)
+				put(t, m, "new", "result")
+			},
+			want: []string{"new"},
+		},
+		{
+			name:     "least recently used are evicted",
+			maxBytes: 10,
+			run: func(t *testing.T, m *TranscriptCacheModel) {
+				put(t, m, "a", "aaaa")
+				put(t, m, "b", "bbbb")
+				age(t, m, "a", "last_used", 2*time.Minute)
+				age(t, m, "b", "last_used", time.Minute)
+
+				// Reading a makes b the least recently used.
+				m.Get("a")
+				put(t, m, "c", "cccc")
+			},
+			want: []string{"a", "c"},
+		},
+		{
+			name:     "evicts as many as needed",
+			maxBytes: 10,
+			run: func(t *testing.T, m *TranscriptCacheModel) {
+				put(t, m, "a", "aaaa")
+				put(t, m, "b", "bbbb")
+				age(t, m, "a", "last_used", 2*time.Minute)
+				age(t, m, "b", "last_used", time.Minute)
+				put(t, m, "c", "cccccccc")
+			},
+			want: []string{"c"},
+		},
+		{
+			name:     "an entry bigger than the cache isn't kept",
+			maxBytes: 10,
+			run: func(t *testing.T, m *TranscriptCacheModel) {
+				put(t, m, "a", "aaaa")
+				put(t, m, "huge", "this is more than ten bytes")
+			},
+			want: []string{"a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &TranscriptCacheModel{DB: newTestDB(t), TTL: time.Hour, MaxBytes: tt.maxBytes}
+
+			tt.run(t, m)
+
+			if got := cachedKeys(t, m); !slices.Equal(got, tt.want) {
+				t.Errorf("cache holds %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranscriptCacheGet(t *testing.T) {
+	m := &TranscriptCacheModel{DB: newTestDB(t), TTL: time.Hour, MaxBytes: 100}
+
+	_, err := m.Get("missing")
+	if !errors.Is(err, ErrNoRecord) {
+		t.Errorf("got error %v for a missing key; want ErrNoRecord", err)
+	}
+
+	put(t, m, "key", "first")
+	put(t, m, "key", "second")
+
+	result, err := m.Get("key")
+	if err != nil || result != "second" {
+		t.Errorf("Get() = %q, %v; want the latest result", result, err)
+	}
+
+	// An expired entry isn't returned even before it is evicted.
+	age(t, m, "key", "created", 2*time.Hour)
+
+	_, err = m.Get("key")
+	if !errors.Is(err, ErrNoRecord) {
+		t.Errorf("got error %v for an expired key; want ErrNoRecord", err)
+	}
+}
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Usage is what a job used of OpenAI: seconds of audio sent to Whisper,
+// tokens sent to and received from the chat model, and what that cost in
+// US dollars at the prices configured when it ran.
+type Usage struct {
+	AudioSeconds     float64
+	PromptTokens     int
+	CompletionTokens int
+	Cost             float64
+}
+
+// AudioMinutes is AudioSeconds in minutes, the unit Whisper is billed in.
+func (u Usage) AudioMinutes() float64 {
+	return u.AudioSeconds / 60
+}
+
+// MonthlyUsage is the usage of the jobs run in one calendar month (UTC),
+// written as YYYY-MM. A job run again counts again, in the month that run
+// started, as does what the run used.
+type MonthlyUsage struct {
+	Month string
+	Jobs  int
+	Usage
+}
+
+type UsageModel struct {
+	DB *sql.DB
+}
+
+// usageSince returns the first month included when reporting the last
+// months months, counting the current one.
+func usageSince(months int) string {
+	now := time.Now().UTC()
+	return time.Date(now.Year(), now.Month()-time.Month(months-1), 1, 0, 0, 0, 0, time.UTC).Format("2006-01")
+}
+
+// MonthlyForUser returns the user's usage for each of the last months
+// months that had any runs, newest first.
+func (m *UsageModel) MonthlyForUser(userID string, months int) ([]MonthlyUsage, error) {
+	stmt := `SELECT substr(job_runs.started, 1, 7) AS month, COUNT(*), SUM(job_runs.audio_seconds),
+	SUM(job_runs.prompt_tokens), SUM(job_runs.completion_tokens), SUM(job_runs.cost)
+	FROM job_runs JOIN jobs ON jobs.id = job_runs.job_id
+	WHERE jobs.user_id = ? AND substr(job_runs.started, 1, 7) >= ?
+	GROUP BY month ORDER BY month DESC`
+
+	return m.query(stmt, userID, usageSince(months))
+}
+
+// MonthlyForWorkspace returns the usage of everyone in the Notion
+// workspace for each of the last months months that had any runs,
+// newest first.
+func (m *UsageModel) MonthlyForWorkspace(workspaceID string, months int) ([]MonthlyUsage, error) {
+	stmt := `SELECT substr(job_runs.started, 1, 7) AS month, COUNT(*), SUM(job_runs.audio_seconds),
+	SUM(job_runs.prompt_tokens), SUM(job_runs.completion_tokens), SUM(job_runs.cost)
+	FROM job_runs JOIN jobs ON jobs.id = job_runs.job_id
+	WHERE jobs.user_id IN (SELECT id FROM users WHERE workspace_id = ?) AND substr(job_runs.started, 1, 7) >= ?
+	GROUP BY month ORDER BY month DESC`
+
+	return m.query(stmt, workspaceID, usageSince(months))
+}
+
+func (m *UsageModel) query(stmt string, args ...any) ([]MonthlyUsage, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := []MonthlyUsage{}
+
+	for rows.Next() {
+		var u MonthlyUsage
+
+		err = rows.Scan(&u.Month, &u.Jobs, &u.AudioSeconds, &u.PromptTokens, &u.CompletionTokens, &u.Cost)
+		if err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return usage, nil
+}
+
+// QuotaUsage is what counts against a quota right now: the audio
+// transcribed this month and the jobs run today (both UTC), the jobs that
+// haven't finished, and those of them being worked on. Running a job again
+// counts as another job, and its audio counts again in the month it runs.
+type QuotaUsage struct {
+	AudioSeconds float64
+	JobsToday    int
+	PendingJobs  int
+	RunningJobs  int
+}
+
+// QuotaForUser returns the user's current QuotaUsage as it stood for job:
+// the job's current run, and any run started after it, aren't counted.
+// Pass the zero Job to check a job that hasn't been created yet.
+func (m *UsageModel) QuotaForUser(userID string, job Job) (QuotaUsage, error) {
+	return m.quotaUsage(`user_id = ?`, userID, job)
+}
+
+// QuotaForWorkspace returns the current QuotaUsage of everyone in the
+// Notion workspace as it stood for job, like QuotaForUser.
+func (m *UsageModel) QuotaForWorkspace(workspaceID string, job Job) (QuotaUsage, error) {
+	return m.quotaUsage(`user_id IN (SELECT id FROM users WHERE workspace_id = ?)`, workspaceID, job)
+}
+
+func (m *UsageModel) quotaUsage(where string, subjectID string, job Job) (QuotaUsage, error) {
+	now := time.Now().UTC()
+
+	// Runs are counted up to the start of the job's current run, or up to
+	// now for a job that hasn't been created yet.
+	stmt := `WITH current_run AS (
+		SELECT COALESCE((SELECT MAX(id) FROM job_runs WHERE job_id = ?), (SELECT COALESCE(MAX(id), 0) + 1 FROM job_runs)) AS id
+	)
+	SELECT
+	(SELECT COALESCE(SUM(CASE WHEN substr(started, 1, 7) = ? THEN job_runs.audio_seconds END), 0)
+		FROM job_runs JOIN jobs ON jobs.id = job_runs.job_id WHERE ` + where + ` AND job_runs.id != (SELECT id FROM current_run)),
+	(SELECT COUNT(CASE WHEN substr(started, 1, 10) = ? AND job_runs.id < (SELECT id FROM current_run) THEN 1 END)
+		FROM job_runs JOIN jobs ON jobs.id = job_runs.job_id WHERE ` + where + `),
+	(SELECT COUNT(CASE WHEN status IN (?, ?, ?, ?) THEN 1 END) FROM jobs WHERE ` + where + ` AND id != ?),
+	(SELECT COUNT(CASE WHEN status IN (?, ?, ?) THEN 1 END) FROM jobs WHERE ` + where + ` AND id != ?)`
+
+	var u QuotaUsage
+
+	err := m.DB.QueryRow(stmt, job.ID,
+		now.Format("2006-01"), subjectID,
+		now.Format("2006-01-02"), subjectID,
+		JobQueued, JobTranscribing, JobSummarizing, JobPublishing, subjectID, job.ID,
+		JobTranscribing, JobSummarizing, JobPublishing, subjectID, job.ID,
+	).Scan(&u.AudioSeconds, &u.JobsToday, &u.PendingJobs, &u.RunningJobs)
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+
+	return u, nil
+}
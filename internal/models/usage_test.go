@@ -0,0 +1,149 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMonthlyUsageGroupsByRunStart(t *testing.T) {
+	db := newTestDB(t)
+	jobs := &JobModel{DB: db}
+	usage := &UsageModel{DB: db}
+	users := &UserModel{DB: db}
+
+	check := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check(users.Upsert(User{ID: "user", WorkspaceID: "workspace", AccessToken: "a"}))
+	check(users.Upsert(User{ID: "colleague", WorkspaceID: "workspace", AccessToken: "b"}))
+	check(users.Upsert(User{ID: "stranger", WorkspaceID: "elsewhere", AccessToken: "c"}))
+
+	insert := func(userID string, usage Usage) Job {
+		t.Helper()
+		job, err := jobs.Insert(Job{UserID: userID, Filename: "audio.mp3"})
+		check(err)
+		check(jobs.AddUsage(job.ID, usage))
+		return job
+	}
+
+	now := time.Now().UTC()
+	thisMonth := now.Format("2006-01")
+	lastMonth := time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.UTC)
+
+	insert("user", Usage{AudioSeconds: 60, PromptTokens: 100, CompletionTokens: 10, Cost: 0.5})
+
+	// Created last month, but only run this month: it counts this month.
+	late := insert("user", Usage{AudioSeconds: 30, PromptTokens: 50, CompletionTokens: 5, Cost: 0.25})
+	_, err := db.Exec(`UPDATE jobs SET created = ? WHERE id = ?`, lastMonth, late.ID)
+	check(err)
+
+	// Created and run last month.
+	early := insert("user", Usage{AudioSeconds: 90, Cost: 1})
+	_, err = db.Exec(`UPDATE job_runs SET started = ? WHERE job_id = ?`, lastMonth, early.ID)
+	check(err)
+
+	insert("colleague", Usage{AudioSeconds: 120, Cost: 2})
+	insert("stranger", Usage{AudioSeconds: 600, Cost: 10})
+
+	got, err := usage.MonthlyForUser("user", 12)
+	check(err)
+
+	want := []MonthlyUsage{
+		{Month: thisMonth, Jobs: 2, Usage: Usage{AudioSeconds: 90, PromptTokens: 150, CompletionTokens: 15, Cost: 0.75}},
+		{Month: lastMonth.Format("2006-01"), Jobs: 1, Usage: Usage{AudioSeconds: 90, Cost: 1}},
+	}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("MonthlyForUser got %+v; want %+v", got, want)
+	}
+
+	// Last month falls outside a one-month report.
+	got, err = usage.MonthlyForUser("user", 1)
+	check(err)
+	if len(got) != 1 || got[0].Month != thisMonth {
+		t.Errorf("MonthlyForUser for one month got %+v", got)
+	}
+
+	got, err = usage.MonthlyForWorkspace("workspace", 1)
+	check(err)
+
+	wantWorkspace := MonthlyUsage{Month: thisMonth, Jobs: 3, Usage: Usage{AudioSeconds: 210, PromptTokens: 150, CompletionTokens: 15, Cost: 2.75}}
+	if len(got) != 1 || got[0] != wantWorkspace {
+		t.Errorf("MonthlyForWorkspace got %+v; want %+v", got, wantWorkspace)
+	}
+}
+
+func TestQuotaForUserCountsRuns(t *testing.T) {
+	db := newTestDB(t)
+	jobs := &JobModel{DB: db}
+	usage := &UsageModel{DB: db}
+
+	insert := func(userID string) Job {
+		t.Helper()
+		job, err := jobs.Insert(Job{UserID: userID, Filename: "audio.mp3"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return job
+	}
+
+	check := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first job is run, failed and run again, transcribing audio both
+	// times; then a second job is queued.
+	first := insert("user")
+	check(jobs.AddUsage(first.ID, Usage{AudioSeconds: 120}))
+	check(jobs.Fail(first.ID, "failed"))
+	check(jobs.Rerun(first.ID, false))
+	check(jobs.AddUsage(first.ID, Usage{AudioSeconds: 60}))
+
+	second := insert("user")
+
+	// Another user's jobs never count.
+	other := insert("other")
+	check(jobs.AddUsage(other.ID, Usage{AudioSeconds: 600}))
+
+	tests := []struct {
+		name string
+		job  Job
+		want QuotaUsage
+	}{
+		{"new job", Job{}, QuotaUsage{AudioSeconds: 180, JobsToday: 3, PendingJobs: 2}},
+		{"second job", second, QuotaUsage{AudioSeconds: 180, JobsToday: 2, PendingJobs: 1}},
+		{"first job's rerun", first, QuotaUsage{AudioSeconds: 120, JobsToday: 1, PendingJobs: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := usage.QuotaForUser("user", tt.job)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// A run from an earlier month counts against neither today's jobs nor
+	// this month's minutes, even though its job ran again today.
+	_, err := db.Exec(`UPDATE job_runs SET started = ? WHERE id = (SELECT MIN(id) FROM job_runs WHERE job_id = ?)`,
+		time.Now().UTC().AddDate(0, -2, 0), first.ID)
+	check(err)
+
+	got, err := usage.QuotaForUser("user", Job{})
+	check(err)
+
+	want := QuotaUsage{AudioSeconds: 60, JobsToday: 2, PendingJobs: 2}
+	if got != want {
+		t.Errorf("after moving the first run back: got %+v; want %+v", got, want)
+	}
+}
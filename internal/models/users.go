@@ -0,0 +1,75 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// User is the Notion user who authorised the integration, along with the
+// workspace connection and access token from the OAuth exchange.
+type User struct {
+	ID            string
+	Name          string
+	Email         string
+	WorkspaceID   string
+	WorkspaceName string
+	BotID         string
+	AccessToken   string
+	Created       time.Time
+	Updated       time.Time
+}
+
+type UserModel struct {
+	DB *sql.DB
+}
+
+// Upsert stores the user, replacing the connection details of an existing
+// user with the same ID.
+func (m *UserModel) Upsert(user User) error {
+	stmt := `INSERT INTO users (id, name, email, workspace_id, workspace_name, bot_id, access_token, created, updated)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(id) DO UPDATE SET
+		name = excluded.name,
+		email = excluded.email,
+		workspace_id = excluded.workspace_id,
+		workspace_name = excluded.workspace_name,
+		bot_id = excluded.bot_id,
+		access_token = excluded.access_token,
+		updated = excluded.updated`
+
+	now := time.Now().UTC()
+
+	_, err := m.DB.Exec(stmt, user.ID, user.Name, user.Email, user.WorkspaceID, user.WorkspaceName,
+		user.BotID, user.AccessToken, now, now)
+	return err
+}
+
+func (m *UserModel) Get(id string) (User, error) {
+	stmt := `SELECT id, name, email, workspace_id, workspace_name, bot_id, access_token, created, updated
+	FROM users WHERE id = ?`
+
+	return scanUser(m.DB.QueryRow(stmt, id))
+}
+
+func (m *UserModel) GetByAccessToken(accessToken string) (User, error) {
+	stmt := `SELECT id, name, email, workspace_id, workspace_name, bot_id, access_token, created, updated
+	FROM users WHERE access_token = ?`
+
+	return scanUser(m.DB.QueryRow(stmt, accessToken))
+}
+
+func scanUser(row scanner) (User, error) {
+	var u User
+
+	err := row.Scan(&u.ID, &u.Name, &u.Email, &u.WorkspaceID, &u.WorkspaceName, &u.BotID,
+		&u.AccessToken, &u.Created, &u.Updated)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, ErrNoRecord
+		}
+		return User{}, err
+	}
+
+	return u, nil
+}
@@ -0,0 +1,226 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	EventJobCompleted = "job.completed"
+	EventJobFailed    = "job.failed"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is a URL that is notified when one of the user's jobs finishes.
+// Payloads are signed with Secret so receivers can verify them.
+type Webhook struct {
+	ID      int64
+	UserID  string
+	URL     string
+	Secret  string
+	Events  []string
+	Created time.Time
+}
+
+func (w Webhook) Subscribed(event string) bool {
+	return slices.Contains(w.Events, event)
+}
+
+type WebhookDelivery struct {
+	ID             int64
+	WebhookID      int64
+	JobID          string
+	Event          string
+	Payload        string
+	Status         string
+	Attempts       int
+	ResponseStatus int
+	LastError      string
+	NextAttempt    time.Time
+	Created        time.Time
+	Updated        time.Time
+}
+
+type WebhookModel struct {
+	DB *sql.DB
+}
+
+const webhookColumns = `id, user_id, url, secret, events, created`
+
+func scanWebhook(row scanner) (Webhook, error) {
+	var (
+		w      Webhook
+		events string
+	)
+
+	err := row.Scan(&w.ID, &w.UserID, &w.URL, &w.Secret, &events, &w.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Webhook{}, ErrNoRecord
+		}
+		return Webhook{}, err
+	}
+
+	if events != "" {
+		w.Events = strings.Split(events, ",")
+	}
+
+	return w, nil
+}
+
+// Insert registers the webhook with a newly generated signing secret.
+func (m *WebhookModel) Insert(userID string, url string, events []string) (Webhook, error) {
+	randomBytes := make([]byte, 24)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	w := Webhook{
+		UserID:  userID,
+		URL:     url,
+		Secret:  "whsec_" + hex.EncodeToString(randomBytes),
+		Events:  events,
+		Created: time.Now().UTC(),
+	}
+
+	stmt := `INSERT INTO webhooks (user_id, url, secret, events, created) VALUES (?, ?, ?, ?, ?)`
+
+	result, err := m.DB.Exec(stmt, w.UserID, w.URL, w.Secret, strings.Join(events, ","), w.Created)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	w.ID, err = result.LastInsertId()
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	return w, nil
+}
+
+func (m *WebhookModel) Get(id int64) (Webhook, error) {
+	return scanWebhook(m.DB.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, id))
+}
+
+func (m *WebhookModel) ListForUser(userID string) ([]Webhook, error) {
+	rows, err := m.DB.Query(`SELECT `+webhookColumns+` FROM webhooks WHERE user_id = ? ORDER BY created`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (m *WebhookModel) Delete(id int64, userID string) error {
+	result, err := m.DB.Exec(`DELETE FROM webhooks WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// Enqueue records a pending delivery that is due immediately.
+func (m *WebhookModel) Enqueue(webhookID int64, jobID string, event string, payload string) error {
+	stmt := `INSERT INTO webhook_deliveries (webhook_id, job_id, event, payload, status, next_attempt, created, updated)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	now := time.Now().UTC()
+
+	_, err := m.DB.Exec(stmt, webhookID, jobID, event, payload, DeliveryPending, now, now, now)
+	return err
+}
+
+const deliveryColumns = `id, webhook_id, job_id, event, payload, status, attempts, response_status, last_error,
+	next_attempt, created, updated`
+
+func (m *WebhookModel) queryDeliveries(stmt string, args ...any) ([]WebhookDelivery, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+
+	for rows.Next() {
+		var d WebhookDelivery
+
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.JobID, &d.Event, &d.Payload, &d.Status, &d.Attempts,
+			&d.ResponseStatus, &d.LastError, &d.NextAttempt, &d.Created, &d.Updated)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// Due returns pending deliveries whose next attempt is at or before now.
+func (m *WebhookModel) Due(now time.Time, limit int) ([]WebhookDelivery, error) {
+	stmt := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries
+	WHERE status = ? AND next_attempt <= ? ORDER BY next_attempt LIMIT ?`
+
+	return m.queryDeliveries(stmt, DeliveryPending, now.UTC(), limit)
+}
+
+// RecentDeliveries returns the delivery log across all of the user's
+// webhooks, newest first.
+func (m *WebhookModel) RecentDeliveries(userID string, limit int) ([]WebhookDelivery, error) {
+	stmt := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries
+	WHERE webhook_id IN (SELECT id FROM webhooks WHERE user_id = ?)
+	ORDER BY created DESC, id DESC LIMIT ?`
+
+	return m.queryDeliveries(stmt, userID, limit)
+}
+
+// RecordAttempt stores the outcome of an attempt. The delivery stays pending
+// with the given next attempt time unless status is final.
+func (m *WebhookModel) RecordAttempt(id int64, status string, responseStatus int, lastError string, nextAttempt time.Time) error {
+	stmt := `UPDATE webhook_deliveries SET status = ?, attempts = attempts + 1, response_status = ?,
+	last_error = ?, next_attempt = ?, updated = ? WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, status, responseStatus, lastError, nextAttempt.UTC(), time.Now().UTC(), id)
+	return err
+}
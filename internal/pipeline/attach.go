@@ -0,0 +1,186 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"time"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+)
+
+// maxNotionUploadSize is the largest file Notion accepts in a single-part
+// upload. Larger recordings are linked to instead.
+const maxNotionUploadSize = 20 << 20
+
+// notionUploadTimeout bounds sending a recording to Notion, so a stalled
+// upload can't hold up the job forever.
+const notionUploadTimeout = 5 * time.Minute
+
+// defaultAudioLinkExpiry is how long a signed link to a job's audio works
+// when the Pipeline doesn't say.
+const defaultAudioLinkExpiry = 7 * 24 * time.Hour
+
+var ErrAudioLinkUnavailable = errors.New("the storage backend can't sign links to the audio")
+
+// FileBlock is the body of a Notion block that shows a file, either one
+// uploaded to Notion or one linked to elsewhere.
+type FileBlock struct {
+	Type       string         `json:"type"`
+	External   *ExternalFile  `json:"external,omitempty"`
+	FileUpload *FileUploadRef `json:"file_upload,omitempty"`
+}
+
+type ExternalFile struct {
+	URL string `json:"url"`
+}
+
+type FileUploadRef struct {
+	ID string `json:"id"`
+}
+
+type notionFileUpload struct {
+	Id     string `json:"id"`
+	Status string `json:"status"`
+}
+
+func (p *Pipeline) audioLinkExpiry() time.Duration {
+	if p.AudioLinkExpiry > 0 {
+		return p.AudioLinkExpiry
+	}
+	return defaultAudioLinkExpiry
+}
+
+// createAudioElement creates a block that plays the job's original audio.
+// The audio is uploaded to Notion if it's small enough and the workspace
+// accepts it. Otherwise the block links to it in storage with a signed URL
+// that expires after AudioLinkExpiry.
+func (p *Pipeline) createAudioElement(job models.Job, notionAccessToken string) (Children, error) {
+	audio, err := p.Storage.Read(job.StoragePath)
+	if err != nil {
+		return Children{}, err
+	}
+
+	if len(audio) <= maxNotionUploadSize {
+		id, err := uploadNotionFile(audio, job.Filename, job.ContentType, notionAccessToken)
+		if err == nil {
+			return Children{
+				Object: "block",
+				Audio:  &FileBlock{Type: "file_upload", FileUpload: &FileUploadRef{ID: id}},
+			}, nil
+		}
+		p.Logger.Warn("Uploading audio to Notion failed, linking to it instead", "job", job.ID, "error", err.Error())
+	}
+
+	signer, ok := p.Storage.(URLSigner)
+	if !ok {
+		return Children{}, ErrAudioLinkUnavailable
+	}
+
+	url, err := signer.SignedURL(job.StoragePath, p.audioLinkExpiry())
+	if err != nil {
+		return Children{}, err
+	}
+
+	return Children{
+		Object: "block",
+		Audio:  &FileBlock{Type: "external", External: &ExternalFile{URL: url}},
+	}, nil
+}
+
+// uploadNotionFile sends a file to Notion's file upload API in a single
+// part and returns the upload's ID, which blocks can then refer to.
+func uploadNotionFile(b []byte, filename string, contentType string, notionAccessToken string) (string, error) {
+	marshalled, err := json.Marshal(map[string]string{
+		"mode":         "single_part",
+		"filename":     filename,
+		"content_type": contentType,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	auth := generateAuthHeader("bearer", notionAccessToken)
+
+	resp, err := doNotionApiRequest("file_uploads", marshalled, auth, "POST")
+	if err != nil {
+		return "", err
+	}
+
+	var upload notionFileUpload
+	err = decodeNotionResponse(resp, &upload)
+	if err != nil {
+		return "", err
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename=%q`, filename))
+	header.Set("Content-Type", contentType)
+
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = part.Write(b)
+	if err != nil {
+		return "", err
+	}
+	writer.Close()
+
+	req, err := http.NewRequest("POST", "https://api.notion.com/v1/file_uploads/"+upload.Id+"/send", body)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Add("Content-Type", writer.FormDataContentType())
+	req.Header.Add("Notion-Version", "2022-06-28")
+	req.Header.Add("Authorization", auth)
+
+	client := &http.Client{Timeout: notionUploadTimeout}
+	resp, err = client.Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	err = decodeNotionResponse(resp, &upload)
+	if err != nil {
+		return "", err
+	}
+
+	if upload.Status != "uploaded" {
+		return "", fmt.Errorf("notion file upload is %s", upload.Status)
+	}
+
+	return upload.Id, nil
+}
+
+// decodeNotionResponse reads a response from Notion's API into v, or
+// returns Notion's error message.
+func decodeNotionResponse(resp *http.Response, v any) error {
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var notionError NotionApiError
+		err = json.Unmarshal(b, &notionError)
+		if err != nil || notionError.Message == "" {
+			return fmt.Errorf("notion request failed: %s", resp.Status)
+		}
+		return errors.New(notionError.Message)
+	}
+
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,127 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+)
+
+// signingStorage is LocalStorage that can sign links, as Azure storage can.
+type signingStorage struct {
+	LocalStorage
+}
+
+func (s *signingStorage) SignedURL(savedPath string, expiry time.Duration) (string, error) {
+	return "https://storage.example.com/" + savedPath + "?expires=" + expiry.String(), nil
+}
+
+// fakeNotionUploads sends Notion API requests to a test server that accepts
+// file uploads, failing them if fail is set, and returns the number of
+// files sent to it.
+func fakeNotionUploads(t *testing.T, fail bool) *int {
+	t.Helper()
+
+	var sent int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/file_uploads":
+			json.NewEncoder(w).Encode(notionFileUpload{Id: "upload", Status: "pending"})
+		case "/v1/file_uploads/upload/send":
+			sent++
+			if fail {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"message": "file too large for this workspace"})
+				return
+			}
+			json.NewEncoder(w).Encode(notionFileUpload{Id: "upload", Status: "uploaded"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	target, _ := url.Parse(server.URL)
+
+	transport := http.DefaultTransport
+	http.DefaultTransport = roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		r.URL.Scheme = target.Scheme
+		r.URL.Host = target.Host
+		return transport.RoundTrip(r)
+	})
+	t.Cleanup(func() { http.DefaultTransport = transport })
+
+	return &sent
+}
+
+func TestCreateAudioElement(t *testing.T) {
+	tests := []struct {
+		name       string
+		size       int
+		signs      bool
+		uploadFail bool
+		wantType   string
+		wantSent   int
+		wantErr    error
+	}{
+		{name: "small enough to upload", size: 1 << 10, signs: true, wantType: "file_upload", wantSent: 1},
+		{name: "at the upload limit", size: maxNotionUploadSize, wantType: "file_upload", wantSent: 1},
+		{name: "too large to upload", size: maxNotionUploadSize + 1, signs: true, wantType: "external"},
+		{name: "upload refused", size: 1 << 10, signs: true, uploadFail: true, wantType: "external", wantSent: 1},
+		{name: "too large without signed links", size: maxNotionUploadSize + 1, wantErr: ErrAudioLinkUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent := fakeNotionUploads(t, tt.uploadFail)
+
+			local := LocalStorage{Dir: t.TempDir()}
+			var storage Storage = &local
+			if tt.signs {
+				storage = &signingStorage{local}
+			}
+
+			path, err := storage.Write(make([]byte, tt.size), "audio.mp3", "audio/mpeg")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			p := &Pipeline{
+				Logger:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+				Storage:         storage,
+				AudioLinkExpiry: time.Hour,
+			}
+			job := models.Job{ID: "job", Filename: "audio.mp3", ContentType: "audio/mpeg", StoragePath: path}
+
+			block, err := p.createAudioElement(job, "secret_notion_token")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("got error %v; want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if block.Audio == nil || block.Audio.Type != tt.wantType {
+				t.Fatalf("got audio block %+v; want type %q", block.Audio, tt.wantType)
+			}
+			if tt.wantType == "external" && block.Audio.External.URL != "https://storage.example.com/"+path+"?expires=1h0m0s" {
+				t.Errorf("linked to %q", block.Audio.External.URL)
+			}
+
+			if *sent != tt.wantSent {
+				t.Errorf("sent %d files to Notion; want %d", *sent, tt.wantSent)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package pipeline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+)
+
+// transcriptionProvider names the service transcripts come from, so cached
+// results from different services are never mixed up.
+const transcriptionProvider = "openai"
+
+// transcriptCacheKey identifies a transcription by everything that decides
+// what comes back: the audio, how it was prepared, the service and model,
+// whether it was translated, the spoken language and the vocabulary
+// prompt.
+func transcriptCacheKey(job models.Job, audio []byte, prompt string) (string, error) {
+	audioHash := job.AudioHash
+	if audioHash == "" {
+		sum := sha256.Sum256(audio)
+		audioHash = hex.EncodeToString(sum[:])
+	}
+
+	b, err := json.Marshal([]any{
+		audioHash, job.Preprocess, job.CompressSilence,
+		transcriptionProvider, transcriptionModel, job.Translate, job.Language, prompt,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// cachedTranscription looks for a cached transcription. The cache only
+// saves money, so if it can't be read the audio is transcribed again.
+func (p *Pipeline) cachedTranscription(job models.Job, key string) (WhisperApiResponse, bool) {
+	if p.TranscriptCache == nil {
+		return WhisperApiResponse{}, false
+	}
+
+	result, err := p.TranscriptCache.Get(key)
+	if err != nil {
+		if !errors.Is(err, models.ErrNoRecord) {
+			p.Logger.Warn("Couldn't read the transcript cache", "job", job.ID, "error", err.Error())
+		}
+		return WhisperApiResponse{}, false
+	}
+
+	var transcription WhisperApiResponse
+	err = json.Unmarshal([]byte(result), &transcription)
+	if err != nil {
+		p.Logger.Warn("Couldn't read the transcript cache", "job", job.ID, "error", err.Error())
+		return WhisperApiResponse{}, false
+	}
+	transcription.Cached = true
+
+	p.Logger.Debug("Using a cached transcript", "job", job.ID)
+
+	return transcription, true
+}
+
+func (p *Pipeline) cacheTranscription(job models.Job, key string, transcription WhisperApiResponse) {
+	if p.TranscriptCache == nil {
+		return
+	}
+
+	b, err := json.Marshal(transcription)
+	if err == nil {
+		err = p.TranscriptCache.Put(key, string(b))
+	}
+
+	if err != nil {
+		p.Logger.Warn("Couldn't cache the transcript", "job", job.ID, "error", err.Error())
+	}
+}
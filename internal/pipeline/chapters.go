@@ -0,0 +1,251 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+)
+
+// minChapterSeconds is the shortest recording that is divided into
+// chapters. Anything shorter is quick enough to skim.
+const minChapterSeconds = 10 * 60
+
+// timestampInterval is how often the transcript sent to the chat model is
+// marked with the time it was said.
+const timestampInterval = 30
+
+// timestampMarker matches the markers added to the transcript, in case the
+// model copies any into its paragraphs.
+var timestampMarker = regexp.MustCompile(`\[\d{1,2}(?::\d{2}){1,2}\]\s*`)
+
+// Chapter is a stretch of a recording about one topic. Start is the time
+// it begins, as a timestamp like "12:34" or "1:02:03".
+type Chapter struct {
+	Title   string `json:"title"`
+	Start   string `json:"start"`
+	Summary string `json:"summary"`
+}
+
+// Seconds returns how far into the recording the chapter starts.
+func (c Chapter) Seconds() float64 {
+	seconds, _ := parseTimestamp(c.Start)
+	return seconds
+}
+
+// chaptersProperty describes the chapters the chat model is asked for.
+var chaptersProperty = PropertyDefinition{
+	Description: "The chapters of the recording, in order, starting a new one wherever the topic changes",
+	Type:        "array",
+	Items: &PropertyDefinition{
+		Type: "object",
+		Properties: map[string]PropertyDefinition{
+			"title": {
+				Description: "A short title for the chapter",
+				Type:        "string",
+			},
+			"start": {
+				Description: "The timestamp the chapter starts at, copied from the nearest marker such as 12:34",
+				Type:        "string",
+			},
+			"summary": {
+				Description: "A one-line summary of the chapter",
+				Type:        "string",
+			},
+		},
+		Required:             []string{"start", "summary", "title"},
+		AdditionalProperties: new(bool),
+	},
+}
+
+// JobSegments returns the timing of the job's transcript, which is empty
+// if Whisper didn't report any.
+func JobSegments(job models.Job) ([]WhisperSegment, error) {
+	var segments []WhisperSegment
+
+	if job.Segments == "" {
+		return segments, nil
+	}
+
+	err := json.Unmarshal([]byte(job.Segments), &segments)
+	return segments, err
+}
+
+// wantsChapters reports whether a recording with these segments is long
+// enough to be divided into chapters.
+func wantsChapters(segments []WhisperSegment) bool {
+	return len(segments) > 0 && segments[len(segments)-1].End >= minChapterSeconds
+}
+
+// parseTimestamp parses a timestamp like "12:34" or "1:02:03" into seconds.
+func parseTimestamp(timestamp string) (float64, error) {
+	parts := strings.Split(strings.Trim(strings.TrimSpace(timestamp), "[]"), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("%q isn't a timestamp like 12:34", timestamp)
+	}
+
+	var seconds float64
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("%q isn't a timestamp like 12:34", timestamp)
+		}
+		seconds = seconds*60 + float64(n)
+	}
+
+	return seconds, nil
+}
+
+// formatTimestamp writes seconds as a timestamp like "12:34", or "1:02:03"
+// from the first hour on.
+func formatTimestamp(seconds float64) string {
+	s := int(seconds)
+
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// timeline maps positions in a transcript to times in the recording, using
+// the timed segments it was transcribed from. Positions are scaled by the
+// transcript's length, so it still lines up after paragraphs are reflowed,
+// speakers are named or small corrections are made.
+type timeline struct {
+	segments []WhisperSegment
+	offsets  []int
+	runes    int
+}
+
+func newTimeline(segments []WhisperSegment) timeline {
+	t := timeline{segments: segments, offsets: make([]int, len(segments))}
+
+	for i, segment := range segments {
+		t.offsets[i] = t.runes
+		t.runes += utf8.RuneCountInString(segment.Text)
+	}
+
+	return t
+}
+
+// at returns the time of the rune at offset in a transcript of length runes.
+func (t timeline) at(offset int, runes int) float64 {
+	if len(t.segments) == 0 || runes == 0 {
+		return 0
+	}
+
+	position := int(float64(offset) / float64(runes) * float64(t.runes))
+
+	i := len(t.offsets) - 1
+	for i > 0 && t.offsets[i] > position {
+		i--
+	}
+
+	segment := t.segments[i]
+	length := utf8.RuneCountInString(segment.Text)
+	if length == 0 {
+		return segment.Start
+	}
+
+	within := math.Min(float64(position-t.offsets[i])/float64(length), 1)
+	return segment.Start + (segment.End-segment.Start)*within
+}
+
+// markTimestamps adds a marker like "[12:34]" to the start of a sentence of
+// the transcript every timestampInterval seconds, so that the chat model
+// can tell when each chapter starts.
+func markTimestamps(transcript string, segments []WhisperSegment) string {
+	t := newTimeline(segments)
+	runes := []rune(transcript)
+
+	var (
+		b    strings.Builder
+		last = -math.MaxFloat64
+	)
+
+	sentenceStart := true
+
+	for i, r := range runes {
+		if sentenceStart && !unicode.IsSpace(r) {
+			if seconds := t.at(i, len(runes)); seconds-last >= timestampInterval {
+				b.WriteString("[" + formatTimestamp(seconds) + "] ")
+				last = seconds
+			}
+			sentenceStart = false
+		}
+
+		b.WriteRune(r)
+
+		if r == '\n' || (r == '.' || r == '!' || r == '?') && i+1 < len(runes) && unicode.IsSpace(runes[i+1]) {
+			sentenceStart = true
+		}
+	}
+
+	return b.String()
+}
+
+// stripTimestamps removes any markers the model copied into its paragraphs.
+func stripTimestamps(text string) string {
+	return timestampMarker.ReplaceAllString(text, "")
+}
+
+// chapterStarts returns the index of the first of the transcript's
+// paragraphs in each chapter: the paragraph that starts closest to the
+// chapter. The first chapter always starts with the first paragraph, and
+// chapters never go back in the transcript.
+func chapterStarts(chapters []Chapter, paragraphs []string, segments []WhisperSegment) []int {
+	t := newTimeline(segments)
+	text := strings.Join(paragraphs, "\n\n")
+	runes := utf8.RuneCountInString(text)
+
+	times := make([]float64, len(paragraphs))
+	offset := 0
+	for i, paragraph := range paragraphs {
+		times[i] = t.at(offset, runes)
+		offset += utf8.RuneCountInString(paragraph) + 2
+	}
+
+	starts := make([]int, len(chapters))
+	if len(paragraphs) == 0 {
+		return starts
+	}
+
+	for i := 1; i < len(chapters); i++ {
+		start := chapters[i].Seconds()
+		best := starts[i-1]
+
+		for j := best; j < len(paragraphs); j++ {
+			if math.Abs(times[j]-start) < math.Abs(times[best]-start) {
+				best = j
+			}
+		}
+
+		starts[i] = best
+	}
+
+	return starts
+}
+
+// validateChapters checks that every chapter has a title and a timestamp
+// that can be read.
+func validateChapters(chapters []Chapter) error {
+	for i, chapter := range chapters {
+		if strings.TrimSpace(chapter.Title) == "" {
+			return fmt.Errorf("chapter %d has no title", i+1)
+		}
+
+		_, err := parseTimestamp(chapter.Start)
+		if err != nil {
+			return fmt.Errorf("chapter %d's start %w", i+1, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,176 @@
+package pipeline
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		timestamp string
+		want      float64
+		ok        bool
+	}{
+		{"0:00", 0, true},
+		{"12:34", 754, true},
+		{"1:02:03", 3723, true},
+		{"[5:07]", 307, true},
+		{" 90:00 ", 5400, true},
+		{"12", 0, false},
+		{"1:2:3:4", 0, false},
+		{"ab:cd", 0, false},
+		{"-1:00", 0, false},
+		{"", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.timestamp, func(t *testing.T) {
+			got, err := parseTimestamp(tt.timestamp)
+			if (err == nil) != tt.ok || got != tt.want {
+				t.Errorf("parseTimestamp(%q) = %v, %v; want %v, ok %t", tt.timestamp, got, err, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestFormatTimestamp(t *testing.T) {
+	tests := []struct {
+		seconds float64
+		want    string
+	}{
+		{0, "0:00"},
+		{59.9, "0:59"},
+		{754, "12:34"},
+		{3599, "59:59"},
+		{3600, "1:00:00"},
+		{3723, "1:02:03"},
+	}
+
+	for _, tt := range tests {
+		if got := formatTimestamp(tt.seconds); got != tt.want {
+			t.Errorf("formatTimestamp(%v) = %q; want %q", tt.seconds, got, tt.want)
+		}
+	}
+}
+
+// evenSegments returns a segment for each text, each lasting seconds.
+func evenSegments(texts []string, seconds float64) []WhisperSegment {
+	segments := make([]WhisperSegment, len(texts))
+	for i, text := range texts {
+		segments[i] = WhisperSegment{Start: float64(i) * seconds, End: float64(i+1) * seconds, Text: text}
+	}
+	return segments
+}
+
+func TestMarkTimestamps(t *testing.T) {
+	var sentences []string
+	for i := range 6 {
+		sentences = append(sentences, fmt.Sprintf("Sentence %d.", i))
+	}
+
+	tests := []struct {
+		name       string
+		transcript string
+		seconds    float64
+		want       string
+	}{
+		{
+			name:       "every other sentence",
+			transcript: strings.Join(sentences, " "),
+			seconds:    15,
+			want:       "[0:00] Sentence 0. Sentence 1. [0:30] Sentence 2. Sentence 3. [1:00] Sentence 4. Sentence 5.",
+		},
+		{
+			name:       "every sentence",
+			transcript: strings.Join(sentences, " "),
+			seconds:    40,
+			want:       "[0:00] Sentence 0. [0:40] Sentence 1. [1:20] Sentence 2. [2:00] Sentence 3. [2:40] Sentence 4. [3:20] Sentence 5.",
+		},
+		{
+			name:       "paragraphs",
+			transcript: strings.Join(sentences, "\n"),
+			seconds:    20,
+			want:       "[0:00] Sentence 0.\nSentence 1.\n[0:40] Sentence 2.\nSentence 3.\n[1:20] Sentence 4.\nSentence 5.",
+		},
+		{
+			name:       "hours",
+			transcript: strings.Join(sentences, " "),
+			seconds:    1800,
+			want:       "[0:00] Sentence 0. [30:00] Sentence 1. [1:00:00] Sentence 2. [1:30:00] Sentence 3. [2:00:00] Sentence 4. [2:30:00] Sentence 5.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			marked := markTimestamps(tt.transcript, evenSegments(sentences, tt.seconds))
+			if marked != tt.want {
+				t.Errorf("markTimestamps() = %q; want %q", marked, tt.want)
+			}
+
+			if stripped := stripTimestamps(marked); stripped != tt.transcript {
+				t.Errorf("stripTimestamps() = %q; want the original transcript %q", stripped, tt.transcript)
+			}
+		})
+	}
+}
+
+func TestMarkTimestampsOnlyAtSentences(t *testing.T) {
+	// A full stop that isn't followed by a space, as in 3.5, doesn't start
+	// a sentence, so no marker goes after it however long it took.
+	transcript := "Growth was 3.5 percent. Then it fell."
+	segments := []WhisperSegment{
+		{Start: 0, End: 60, Text: "Growth was 3."},
+		{Start: 60, End: 120, Text: "5 percent. "},
+		{Start: 120, End: 180, Text: "Then it fell."},
+	}
+
+	want := "[0:00] Growth was 3.5 percent. [2:00] Then it fell."
+	if got := markTimestamps(transcript, segments); got != want {
+		t.Errorf("markTimestamps() = %q; want %q", got, want)
+	}
+}
+
+func TestChapterStarts(t *testing.T) {
+	// Four paragraphs, each taking a minute to say.
+	paragraphs := make([]string, 4)
+	for i := range paragraphs {
+		paragraphs[i] = strings.Repeat(fmt.Sprint(i), 98)
+	}
+	segments := evenSegments(paragraphs, 60)
+
+	chapters := func(starts ...string) []Chapter {
+		var chapters []Chapter
+		for _, start := range starts {
+			chapters = append(chapters, Chapter{Title: "Chapter " + start, Start: start})
+		}
+		return chapters
+	}
+
+	tests := []struct {
+		name       string
+		chapters   []Chapter
+		paragraphs []string
+		segments   []WhisperSegment
+		want       []int
+	}{
+		{"on paragraph boundaries", chapters("0:00", "1:00", "3:00"), paragraphs, segments, []int{0, 1, 3}},
+		{"closest paragraph", chapters("0:00", "1:40", "2:20"), paragraphs, segments, []int{0, 2, 2}},
+		{"first chapter starts the transcript", chapters("2:00", "3:00"), paragraphs, segments, []int{0, 3}},
+		{"never goes back", chapters("0:00", "3:00", "1:00"), paragraphs, segments, []int{0, 3, 3}},
+		{"past the end", chapters("0:00", "59:00"), paragraphs, segments, []int{0, 3}},
+		{"no chapters", nil, paragraphs, segments, []int{}},
+		{"no paragraphs", chapters("0:00", "1:00"), nil, segments, []int{0, 0}},
+		{"no timing", chapters("0:00", "1:00"), paragraphs, nil, []int{0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := chapterStarts(tt.chapters, tt.paragraphs, tt.segments)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("chapterStarts() = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+)
+
+var ErrDiarizationUnavailable = errors.New("speaker diarization isn't configured")
+
+// SpeakerSegment is a stretch of a recording that a Diarizer attributes to
+// one speaker, in seconds from the start. Speaker is whatever label the
+// diarizer uses; only whether two segments share it matters.
+type SpeakerSegment struct {
+	Speaker string  `json:"speaker"`
+	Start   float64 `json:"start"`
+	End     float64 `json:"end"`
+}
+
+// Diarizer works out who is speaking when in a recording.
+type Diarizer interface {
+	Diarize(audio []byte, filename string) ([]SpeakerSegment, error)
+}
+
+// defaultDiarizeTimeout bounds a diarization request when HTTPDiarizer has
+// no Client of its own. Diarizing a long recording takes a while, but a
+// service that has stopped responding mustn't hold up the job forever.
+const defaultDiarizeTimeout = 10 * time.Minute
+
+// HTTPDiarizer sends recordings to a diarization service over HTTP, such as
+// a pyannote server run alongside the app. The audio is POSTed to URL as
+// the multipart field "file", and the service replies with
+// {"segments": [{"speaker": "A", "start": 0.0, "end": 4.2}, ...]}.
+type HTTPDiarizer struct {
+	URL    string
+	Client *http.Client
+}
+
+func (d *HTTPDiarizer) Diarize(audio []byte, filename string) ([]SpeakerSegment, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = part.Write(audio)
+	if err != nil {
+		return nil, err
+	}
+	writer.Close()
+
+	client := d.Client
+	if client == nil {
+		client = &http.Client{Timeout: defaultDiarizeTimeout}
+	}
+
+	resp, err := client.Post(d.URL, writer.FormDataContentType(), body)
+	if err != nil {
+		return nil, fmt.Errorf("diarizing: %w", err)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("diarizing: %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+
+	var result struct {
+		Segments []SpeakerSegment `json:"segments"`
+	}
+
+	err = json.Unmarshal(b, &result)
+	if err != nil {
+		return nil, fmt.Errorf("diarizing: %w", err)
+	}
+
+	return result.Segments, nil
+}
+
+// SpeakerTurn is what one speaker said before someone else spoke.
+type SpeakerTurn struct {
+	Speaker string `json:"speaker"`
+	Text    string `json:"text"`
+}
+
+// Speakers is who said what in a diarized transcript. Speakers are labelled
+// "Speaker 1", "Speaker 2" and so on in the order they first speak, and
+// Names holds the real names users have given them.
+type Speakers struct {
+	Turns []SpeakerTurn     `json:"turns"`
+	Names map[string]string `json:"names,omitempty"`
+}
+
+// Name returns the name given to the speaker with label, or the label if
+// they haven't been named.
+func (s Speakers) Name(label string) string {
+	if name := s.Names[label]; name != "" {
+		return name
+	}
+	return label
+}
+
+// Labels returns each speaker's label once, in the order they first speak.
+func (s Speakers) Labels() []string {
+	var labels []string
+
+	for _, turn := range s.Turns {
+		if !slices.Contains(labels, turn.Speaker) {
+			labels = append(labels, turn.Speaker)
+		}
+	}
+
+	return labels
+}
+
+// Transcript writes the turns out as paragraphs prefixed with the speaker's
+// name, which is how the summarizer sees who said what.
+func (s Speakers) Transcript() string {
+	paragraphs := make([]string, len(s.Turns))
+
+	for i, turn := range s.Turns {
+		paragraphs[i] = s.Name(turn.Speaker) + ": " + turn.Text
+	}
+
+	return strings.Join(paragraphs, "\n\n")
+}
+
+// JobSpeakers returns the job's speaker-labelled transcript, which is empty
+// if the job wasn't diarized.
+func JobSpeakers(job models.Job) (Speakers, error) {
+	var speakers Speakers
+
+	if job.Speakers == "" {
+		return speakers, nil
+	}
+
+	err := json.Unmarshal([]byte(job.Speakers), &speakers)
+	return speakers, err
+}
+
+// diarize labels the transcribed segments of the job's audio with who said
+// them.
+func (p *Pipeline) diarize(audio []byte, filename string, segments []WhisperSegment) (Speakers, error) {
+	if p.Diarizer == nil {
+		return Speakers{}, ErrDiarizationUnavailable
+	}
+
+	speakerSegments, err := p.Diarizer.Diarize(audio, filename)
+	if err != nil {
+		return Speakers{}, err
+	}
+
+	return Speakers{Turns: assignSpeakers(segments, speakerSegments)}, nil
+}
+
+// assignSpeakers gives each transcribed segment to the speaker whose
+// segments overlap it most, or who spoke nearest to it if none do, and
+// joins consecutive segments by the same speaker into turns.
+func assignSpeakers(segments []WhisperSegment, speakerSegments []SpeakerSegment) []SpeakerTurn {
+	var (
+		turns  []SpeakerTurn
+		labels = map[string]string{}
+	)
+
+	for _, segment := range segments {
+		text := strings.TrimSpace(segment.Text)
+		if text == "" {
+			continue
+		}
+
+		speaker := speakerFor(segment, speakerSegments)
+
+		label, ok := labels[speaker]
+		if !ok {
+			label = fmt.Sprintf("Speaker %d", len(labels)+1)
+			labels[speaker] = label
+		}
+
+		if len(turns) > 0 && turns[len(turns)-1].Speaker == label {
+			turns[len(turns)-1].Text += " " + text
+			continue
+		}
+
+		turns = append(turns, SpeakerTurn{Speaker: label, Text: text})
+	}
+
+	return turns
+}
+
+func speakerFor(segment WhisperSegment, speakerSegments []SpeakerSegment) string {
+	overlaps := map[string]float64{}
+
+	var (
+		best     string
+		nearest  string
+		distance = -1.0
+	)
+
+	middle := (segment.Start + segment.End) / 2
+
+	for _, s := range speakerSegments {
+		overlap := min(segment.End, s.End) - max(segment.Start, s.Start)
+		if overlap > 0 {
+			overlaps[s.Speaker] += overlap
+			if best == "" || overlaps[s.Speaker] > overlaps[best] {
+				best = s.Speaker
+			}
+		}
+
+		d := max(s.Start-middle, middle-s.End, 0)
+		if distance < 0 || d < distance {
+			nearest, distance = s.Speaker, d
+		}
+	}
+
+	if best != "" {
+		return best
+	}
+	return nearest
+}
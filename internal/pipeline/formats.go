@@ -0,0 +1,146 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AudioFormat is a container format recognised by its leading bytes.
+// Formats Whisper doesn't read are converted with ffmpeg before they are
+// sent.
+type AudioFormat struct {
+	ContentType string
+	Extension   string
+	Whisper     bool
+}
+
+var (
+	formatMP3  = AudioFormat{ContentType: "audio/mpeg", Extension: "mp3", Whisper: true}
+	formatMP4  = AudioFormat{ContentType: "video/mp4", Extension: "mp4", Whisper: true}
+	formatM4A  = AudioFormat{ContentType: "audio/mp4", Extension: "m4a", Whisper: true}
+	formatMPEG = AudioFormat{ContentType: "video/mpeg", Extension: "mpeg", Whisper: true}
+	formatWAV  = AudioFormat{ContentType: "audio/wav", Extension: "wav", Whisper: true}
+	formatOgg  = AudioFormat{ContentType: "audio/ogg", Extension: "ogg", Whisper: true}
+	formatWebM = AudioFormat{ContentType: "audio/webm", Extension: "webm", Whisper: true}
+	formatFLAC = AudioFormat{ContentType: "audio/flac", Extension: "flac", Whisper: true}
+	formatAAC  = AudioFormat{ContentType: "audio/aac", Extension: "aac"}
+	formatMKV  = AudioFormat{ContentType: "video/x-matroska", Extension: "mkv"}
+	formatMOV  = AudioFormat{ContentType: "video/quicktime", Extension: "mov"}
+	format3GP  = AudioFormat{ContentType: "audio/3gpp", Extension: "3gp"}
+	formatAIFF = AudioFormat{ContentType: "audio/aiff", Extension: "aiff"}
+	formatAMR  = AudioFormat{ContentType: "audio/amr", Extension: "amr"}
+)
+
+// AudioExtensions are the file extensions of the formats that can be
+// submitted, with their leading dot.
+var AudioExtensions = []string{
+	".mp3", ".mpga", ".mp4", ".m4a", ".mpeg", ".wav", ".ogg", ".oga", ".opus", ".webm",
+	".flac", ".aac", ".mkv", ".mka", ".mov", ".3gp", ".aif", ".aiff", ".amr",
+}
+
+// detectAudioFormat identifies audio by its container's signature. Unlike
+// http.DetectContentType it knows the formats recorders and phones produce,
+// such as m4a, Opus and AAC.
+func detectAudioFormat(b []byte) (AudioFormat, bool) {
+	switch {
+	case bytes.HasPrefix(b, []byte("ID3")):
+		return formatMP3, true
+	case len(b) >= 12 && bytes.HasPrefix(b, []byte("RIFF")) && string(b[8:12]) == "WAVE":
+		return formatWAV, true
+	case bytes.HasPrefix(b, []byte("fLaC")):
+		return formatFLAC, true
+	case bytes.HasPrefix(b, []byte("OggS")):
+		return formatOgg, true
+	case bytes.HasPrefix(b, []byte("#!AMR")):
+		return formatAMR, true
+	case len(b) >= 12 && bytes.HasPrefix(b, []byte("FORM")) && (string(b[8:12]) == "AIFF" || string(b[8:12]) == "AIFC"):
+		return formatAIFF, true
+	case bytes.HasPrefix(b, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return detectMatroska(b), true
+	case bytes.HasPrefix(b, []byte{0x00, 0x00, 0x01, 0xBA}), bytes.HasPrefix(b, []byte{0x00, 0x00, 0x01, 0xB3}):
+		return formatMPEG, true
+	case len(b) >= 12 && string(b[4:8]) == "ftyp":
+		return detectISOBaseMedia(b), true
+	case len(b) >= 2 && b[0] == 0xFF && b[1]&0xF6 == 0xF0:
+		// An ADTS frame header: MPEG sync bits with the layer set to zero.
+		return formatAAC, true
+	case len(b) >= 2 && b[0] == 0xFF && b[1]&0xE0 == 0xE0 && b[1]&0x06 != 0:
+		// An MPEG audio frame header, as in an MP3 without ID3 tags.
+		return formatMP3, true
+	}
+
+	return AudioFormat{}, false
+}
+
+// detectMatroska tells WebM, which Whisper reads, from other Matroska files
+// by the DocType in the EBML header.
+func detectMatroska(b []byte) AudioFormat {
+	header := b[:min(len(b), 64)]
+
+	if bytes.Contains(header, []byte("webm")) {
+		return formatWebM
+	}
+	return formatMKV
+}
+
+// detectISOBaseMedia names an MP4-family file by the major brand in its
+// ftyp box.
+func detectISOBaseMedia(b []byte) AudioFormat {
+	brand := string(b[8:12])
+
+	switch {
+	case brand == "M4A " || brand == "M4B ":
+		return formatM4A
+	case brand == "qt  ":
+		return formatMOV
+	case strings.HasPrefix(brand, "3g"):
+		return format3GP
+	}
+
+	size := int(binary.BigEndian.Uint32(b[0:4]))
+	if size > len(b) {
+		size = len(b)
+	}
+
+	// Some encoders use a generic major brand and only list M4A among the
+	// compatible brands.
+	if size > 16 && bytes.Contains(b[16:size], []byte("M4A ")) {
+		return formatM4A
+	}
+	return formatMP4
+}
+
+// transcode converts audio Whisper can't read into small mono MP3 using
+// ffmpeg. It works through temporary files rather than pipes, as MP4-family
+// files often keep their index at the end, which ffmpeg can't reach in a
+// pipe.
+func (p *Pipeline) transcode(audio []byte, format AudioFormat) ([]byte, error) {
+	if p.FFmpeg == "" {
+		return nil, fmt.Errorf("converting %s audio needs ffmpeg, which isn't configured", format.Extension)
+	}
+
+	dir, err := os.MkdirTemp("", "transcode-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "input."+format.Extension)
+	output := filepath.Join(dir, "output.mp3")
+
+	err = os.WriteFile(input, audio, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = p.ffmpeg("-i", input, "-vn", "-ac", "1", "-ar", "16000", "-c:a", "libmp3lame", "-b:a", "32k", output)
+	if err != nil {
+		return nil, fmt.Errorf("converting %s audio: %w", format.Extension, err)
+	}
+
+	return os.ReadFile(output)
+}
@@ -0,0 +1,63 @@
+package pipeline
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// ftyp builds the start of an MP4-family file: an ftyp box with the major
+// brand, a minor version and the compatible brands.
+func ftyp(major string, compatible ...string) []byte {
+	size := 16 + 4*len(compatible)
+
+	b := make([]byte, 4, size+8)
+	binary.BigEndian.PutUint32(b, uint32(size))
+	b = append(b, "ftyp"+major+"\x00\x00\x02\x00"...)
+	for _, brand := range compatible {
+		b = append(b, brand...)
+	}
+
+	// The next box, which mustn't be mistaken for a compatible brand.
+	return append(b, "\x00\x00\x00\x08M4A "...)
+}
+
+func TestDetectAudioFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		want   AudioFormat
+		ok     bool
+	}{
+		{"MP3 with ID3", []byte("ID3\x04\x00\x00\x00\x00\x00\x00"), formatMP3, true},
+		{"MP3 frame", []byte{0xFF, 0xFB, 0x90, 0x64}, formatMP3, true},
+		{"ADTS AAC", []byte{0xFF, 0xF1, 0x50, 0x80}, formatAAC, true},
+		{"WAV", []byte("RIFF\x24\x00\x00\x00WAVEfmt "), formatWAV, true},
+		{"RIFF that isn't WAV", []byte("RIFF\x24\x00\x00\x00AVI LIST"), AudioFormat{}, false},
+		{"FLAC", []byte("fLaC\x00\x00\x00\x22"), formatFLAC, true},
+		{"Ogg", []byte("OggS\x00\x02"), formatOgg, true},
+		{"AMR", []byte("#!AMR\n"), formatAMR, true},
+		{"AIFF", []byte("FORM\x00\x00\x00\x00AIFFCOMM"), formatAIFF, true},
+		{"AIFF-C", []byte("FORM\x00\x00\x00\x00AIFCFVER"), formatAIFF, true},
+		{"WebM", []byte("\x1A\x45\xDF\xA3\x9F\x42\x86\x81\x01\x42\x82\x84webm"), formatWebM, true},
+		{"Matroska", []byte("\x1A\x45\xDF\xA3\xA3\x42\x86\x81\x01\x42\x82\x88matroska"), formatMKV, true},
+		{"MPEG program stream", []byte{0x00, 0x00, 0x01, 0xBA, 0x44}, formatMPEG, true},
+		{"M4A brand", ftyp("M4A ", "M4A ", "mp42"), formatM4A, true},
+		{"M4B audiobook", ftyp("M4B "), formatM4A, true},
+		{"M4A among compatible brands", ftyp("mp42", "isom", "M4A "), formatM4A, true},
+		{"MP4", ftyp("isom", "iso2", "mp41"), formatMP4, true},
+		{"QuickTime", ftyp("qt  "), formatMOV, true},
+		{"3GP", ftyp("3gp4", "isom"), format3GP, true},
+		{"text", []byte("hello, world"), AudioFormat{}, false},
+		{"too short", []byte("RI"), AudioFormat{}, false},
+		{"empty", nil, AudioFormat{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := detectAudioFormat(tt.header)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("detectAudioFormat(%q) = %+v, %t; want %+v, %t", tt.header, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package pipeline
+
+import "strings"
+
+// Language is a language Whisper can transcribe, identified by its
+// ISO-639-1 code.
+type Language struct {
+	Code string
+	Name string
+}
+
+// Languages are the languages offered for transcription and summaries.
+// Whisper understands more than this, but these are the ones it handles
+// well enough to be worth choosing over auto-detection.
+var Languages = []Language{
+	{"ar", "Arabic"},
+	{"zh", "Chinese"},
+	{"cs", "Czech"},
+	{"da", "Danish"},
+	{"nl", "Dutch"},
+	{"en", "English"},
+	{"fi", "Finnish"},
+	{"fr", "French"},
+	{"de", "German"},
+	{"el", "Greek"},
+	{"hi", "Hindi"},
+	{"id", "Indonesian"},
+	{"it", "Italian"},
+	{"ja", "Japanese"},
+	{"ko", "Korean"},
+	{"no", "Norwegian"},
+	{"pl", "Polish"},
+	{"pt", "Portuguese"},
+	{"ro", "Romanian"},
+	{"ru", "Russian"},
+	{"es", "Spanish"},
+	{"sv", "Swedish"},
+	{"tr", "Turkish"},
+	{"uk", "Ukrainian"},
+	{"vi", "Vietnamese"},
+}
+
+// LanguageName returns the English name of the language with the given
+// code, and whether it is one of the supported Languages.
+func LanguageName(code string) (string, bool) {
+	for _, language := range Languages {
+		if language.Code == code {
+			return language.Name, true
+		}
+	}
+
+	return "", false
+}
+
+// languageCode maps the language name Whisper reports in its verbose output,
+// such as "german", to its code. Names it doesn't know are returned as is.
+func languageCode(name string) string {
+	for _, language := range Languages {
+		if strings.EqualFold(language.Name, name) || language.Code == name {
+			return language.Code
+		}
+	}
+
+	return name
+}
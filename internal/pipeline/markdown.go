@@ -0,0 +1,327 @@
+package pipeline
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxNotionTextRunes is Notion's limit on the content of one rich text
+// object.
+const maxNotionTextRunes = 2000
+
+var (
+	markdownHeading  = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*$`)
+	markdownToDo     = regexp.MustCompile(`^[-*+]\s+\[([ xX])\]\s+(.*)$`)
+	markdownBullet   = regexp.MustCompile(`^[-*+]\s+(.*)$`)
+	markdownNumbered = regexp.MustCompile(`^\d+[.)]\s+(.*)$`)
+	markdownQuote    = regexp.MustCompile(`^>\s?(.*)$`)
+	markdownDivider  = regexp.MustCompile(`^(?:-\s*){3,}$|^(?:\*\s*){3,}$|^(?:_\s*){3,}$`)
+)
+
+// notionCodeLanguages are the code block languages Notion knows, of those
+// a summary is likely to contain. Anything else is shown as plain text.
+var notionCodeLanguages = []string{
+	"bash", "c", "c++", "c#", "css", "go", "html", "java", "javascript", "json", "kotlin", "markdown",
+	"php", "python", "ruby", "rust", "shell", "sql", "swift", "typescript", "xml", "yaml",
+}
+
+// markdownToBlocks converts the Markdown the summarizer writes into Notion
+// blocks. It understands the subset a model is likely to use: headings,
+// bulleted and numbered lists, to-dos, quotes, fenced code, dividers and
+// paragraphs, with bold, italics, inline code and links within them. Lists
+// aren't nested; indented items become items of the same list.
+func markdownToBlocks(markdown string) []Children {
+	var (
+		blocks    []Children
+		paragraph []string
+		quote     []string
+	)
+
+	flush := func() {
+		if len(paragraph) > 0 {
+			blocks = append(blocks, newRichTextBlocks(BlockParagraph, markdownToRichText(strings.Join(paragraph, " ")))...)
+			paragraph = nil
+		}
+		if len(quote) > 0 {
+			blocks = append(blocks, newRichTextBlocks(BlockQuote, markdownToRichText(strings.Join(quote, "\n")))...)
+			quote = nil
+		}
+	}
+
+	lines := strings.Split(strings.ReplaceAll(markdown, "\r\n", "\n"), "\n")
+
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+
+		if m := markdownQuote.FindStringSubmatch(line); m != nil {
+			if len(paragraph) > 0 {
+				flush()
+			}
+			quote = append(quote, m[1])
+			continue
+		}
+
+		switch {
+		case line == "":
+			flush()
+
+		case strings.HasPrefix(line, "```"):
+			flush()
+
+			language := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(line, "```")))
+			if !slices.Contains(notionCodeLanguages, language) {
+				language = "plain text"
+			}
+
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				code = append(code, lines[i])
+			}
+
+			for _, chunk := range chunkRichText(plainRichText(strings.Join(code, "\n"))) {
+				block := &Block{
+					RichText: chunk,
+					Language: language,
+				}
+				blocks = append(blocks, Children{Object: "block", Code: block})
+			}
+
+		case markdownDivider.MatchString(line):
+			flush()
+			blocks = append(blocks, Children{Object: "block", Divider: &Divider{}})
+
+		case markdownHeading.MatchString(line):
+			flush()
+
+			m := markdownHeading.FindStringSubmatch(line)
+			for _, element := range newRichTextBlocks(BlockParagraph, markdownToRichText(m[2])) {
+				block := element.Paragraph
+				element.Paragraph = nil
+
+				switch len(m[1]) {
+				case 1:
+					element.Heading1 = block
+				case 2:
+					element.Heading2 = block
+				default:
+					element.Heading3 = block
+				}
+				blocks = append(blocks, element)
+			}
+
+		case markdownToDo.MatchString(line):
+			flush()
+
+			m := markdownToDo.FindStringSubmatch(line)
+			for _, element := range newRichTextBlocks(BlockToDo, markdownToRichText(m[2])) {
+				element.ToDo.Checked = m[1] != " "
+				blocks = append(blocks, element)
+			}
+
+		case markdownBullet.MatchString(line):
+			flush()
+			m := markdownBullet.FindStringSubmatch(line)
+			blocks = append(blocks, newRichTextBlocks(BlockBulletedList, markdownToRichText(m[1]))...)
+
+		case markdownNumbered.MatchString(line):
+			flush()
+			m := markdownNumbered.FindStringSubmatch(line)
+			blocks = append(blocks, newRichTextBlocks(BlockNumberedList, markdownToRichText(m[1]))...)
+
+		default:
+			if len(quote) > 0 {
+				flush()
+			}
+			paragraph = append(paragraph, line)
+		}
+	}
+
+	flush()
+
+	return blocks
+}
+
+// markdownToRichText converts Markdown's inline formatting into Notion rich
+// text: **bold** or __bold__, *italics* or _italics_, `code` and
+// [links](https://example.com). A backslash escapes the character after it,
+// and markers that are never closed, or that are surrounded by spaces, are
+// kept as they are.
+func markdownToRichText(text string) []RichText {
+	var (
+		rich         []RichText
+		run          strings.Builder
+		bold, italic bool
+	)
+
+	emit := func(content string, annotations Annotations, link string) {
+		for _, chunk := range splitRunes(content, maxNotionTextRunes) {
+			richText := RichText{Text: Text{Content: chunk}}
+			if annotations != (Annotations{}) {
+				a := annotations
+				richText.Annotations = &a
+			}
+			if link != "" {
+				richText.Text.Link = &Link{URL: link}
+			}
+			rich = append(rich, richText)
+		}
+	}
+
+	flush := func() {
+		if run.Len() > 0 {
+			emit(run.String(), Annotations{Bold: bold, Italic: italic}, "")
+			run.Reset()
+		}
+	}
+
+	for i := 0; i < len(text); {
+		rest := text[i:]
+
+		switch {
+		case rest[0] == '\\' && len(rest) > 1 && unicode.IsPunct(rune(rest[1])):
+			run.WriteByte(rest[1])
+			i += 2
+			continue
+
+		case rest[0] == '`':
+			if end := strings.IndexByte(rest[1:], '`'); end >= 0 {
+				flush()
+				emit(rest[1:end+1], Annotations{Bold: bold, Italic: italic, Code: true}, "")
+				i += end + 2
+				continue
+			}
+
+		case rest[0] == '[':
+			if label, url, n, ok := markdownLink(rest); ok {
+				flush()
+				emit(label, Annotations{Bold: bold, Italic: italic}, url)
+				i += n
+				continue
+			}
+
+		case strings.HasPrefix(rest, "**") || strings.HasPrefix(rest, "__"):
+			if bold && closesEmphasis(text, i) || !bold && opensEmphasis(text, i, rest[:2]) {
+				flush()
+				bold = !bold
+				i += 2
+				continue
+			}
+
+		case rest[0] == '*' || rest[0] == '_' && isEmphasisUnderscore(text, i):
+			if italic && closesEmphasis(text, i) || !italic && opensEmphasis(text, i, rest[:1]) {
+				flush()
+				italic = !italic
+				i++
+				continue
+			}
+		}
+
+		r, size := utf8.DecodeRuneInString(rest)
+		run.WriteRune(r)
+		i += size
+	}
+
+	flush()
+
+	if rich == nil {
+		rich = []RichText{}
+	}
+
+	return rich
+}
+
+// markdownLink parses a link like [label](url) at the start of s, returning
+// how many bytes it takes up.
+func markdownLink(s string) (string, string, int, bool) {
+	closeLabel := strings.Index(s, "](")
+	if closeLabel < 0 {
+		return "", "", 0, false
+	}
+
+	closeURL := strings.IndexByte(s[closeLabel+2:], ')')
+	if closeURL < 0 {
+		return "", "", 0, false
+	}
+
+	label := s[1:closeLabel]
+	url := strings.TrimSpace(s[closeLabel+2 : closeLabel+2+closeURL])
+	if label == "" || strings.ContainsAny(url, " \n") ||
+		!(strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "mailto:")) {
+		return "", "", 0, false
+	}
+
+	return label, url, closeLabel + 2 + closeURL + 1, true
+}
+
+// opensEmphasis reports whether the marker at i opens emphasis: as in
+// CommonMark, it must be followed by a non-space character and closed
+// later on, so that the asterisks in 2 * 3 * 4 are left alone.
+func opensEmphasis(text string, i int, marker string) bool {
+	start := i + len(marker)
+
+	after, _ := utf8.DecodeRuneInString(text[start:])
+	if start == len(text) || unicode.IsSpace(after) {
+		return false
+	}
+
+	for j := start + 1; j < len(text); j++ {
+		if strings.HasPrefix(text[j:], marker) && closesEmphasis(text, j) &&
+			(marker[0] != '_' || isEmphasisUnderscore(text, j)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// closesEmphasis reports whether a marker at i can close emphasis, which it
+// can only straight after a non-space character.
+func closesEmphasis(text string, i int) bool {
+	before, _ := utf8.DecodeLastRuneInString(text[:i])
+	return i > 0 && !unicode.IsSpace(before)
+}
+
+// isEmphasisUnderscore reports whether the underscore at i opens or closes
+// italics rather than being part of a word like snake_case.
+func isEmphasisUnderscore(text string, i int) bool {
+	before, _ := utf8.DecodeLastRuneInString(text[:i])
+	after, _ := utf8.DecodeRuneInString(text[i+1:])
+
+	isWord := func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r)
+	}
+
+	return i == 0 || i == len(text)-1 || !isWord(before) || !isWord(after)
+}
+
+// splitRunes splits s into pieces of at most n runes.
+func splitRunes(s string, n int) []string {
+	var pieces []string
+
+	for utf8.RuneCountInString(s) > n {
+		cut := 0
+		for j := 0; j < n; j++ {
+			_, size := utf8.DecodeRuneInString(s[cut:])
+			cut += size
+		}
+		pieces = append(pieces, s[:cut])
+		s = s[cut:]
+	}
+
+	return append(pieces, s)
+}
+
+// plainRichText is text without any formatting, split to fit Notion's
+// limit on each piece.
+func plainRichText(text string) []RichText {
+	var rich []RichText
+
+	for _, chunk := range splitRunes(text, maxNotionTextRunes) {
+		rich = append(rich, RichText{Text: Text{Content: chunk}})
+	}
+
+	return rich
+}
@@ -0,0 +1,233 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// describeRichText renders rich text compactly for comparison: each run as
+// its annotations, then its content in brackets, then any link.
+func describeRichText(rich []RichText) string {
+	var runs []string
+
+	for _, r := range rich {
+		var flags string
+		if r.Annotations != nil {
+			if r.Annotations.Bold {
+				flags += "b"
+			}
+			if r.Annotations.Italic {
+				flags += "i"
+			}
+			if r.Annotations.Code {
+				flags += "c"
+			}
+		}
+
+		run := flags + "[" + r.Text.Content + "]"
+		if r.Text.Link != nil {
+			run += "(" + r.Text.Link.URL + ")"
+		}
+		runs = append(runs, run)
+	}
+
+	return strings.Join(runs, " ")
+}
+
+func TestMarkdownToRichText(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"plain", "just text", "[just text]"},
+		{"bold", "a **b** c", "[a ] b[b] [ c]"},
+		{"bold underscores", "__b__", "b[b]"},
+		{"italics", "a *b* c", "[a ] i[b] [ c]"},
+		{"italic underscores", "_b_", "i[b]"},
+		{"bold italics", "**a *b***", "b[a ] bi[b]"},
+		{"code", "run `go test` now", "[run ] c[go test] [ now]"},
+		{"code inside bold", "**see `x`**", "b[see ] bc[x]"},
+		{"link", "see [docs](https://example.com).", "[see ] [docs](https://example.com) [.]"},
+		{"mailto link", "[mail](mailto:a@example.com)", "[mail](mailto:a@example.com)"},
+		{"relative link kept", "[docs](/docs)", "[[docs](/docs)]"},
+		{"escaped marker", `\*not italic\*`, "[*not italic*]"},
+		{"unclosed bold", "a ** b", "[a ** b]"},
+		{"unclosed italic", "a *b", "[a *b]"},
+		{"spaced asterisks", "2 * 3 * 4", "[2 * 3 * 4]"},
+		{"spaced then closed", "2 * 3 and *x*", "[2 * 3 and ] i[x]"},
+		{"space before closer", "*a * b*", "i[a * b]"},
+		{"spaced bold", "a ** b ** c", "[a ** b ** c]"},
+		{"snake case", "use snake_case_names here", "[use snake_case_names here]"},
+		{"empty", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := describeRichText(markdownToRichText(tt.text))
+			if got != tt.want {
+				t.Errorf("markdownToRichText(%q) = %s; want %s", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarkdownToRichTextLongRuns(t *testing.T) {
+	text := strings.Repeat("a", maxNotionTextRunes*2+1)
+
+	rich := markdownToRichText(text)
+	if len(rich) != 3 {
+		t.Fatalf("got %d runs; want 3", len(rich))
+	}
+
+	for _, r := range rich {
+		if n := len([]rune(r.Text.Content)); n > maxNotionTextRunes {
+			t.Errorf("run has %d runes; want at most %d", n, maxNotionTextRunes)
+		}
+	}
+}
+
+// describeBlocks renders each block as its type followed by its text.
+func describeBlocks(blocks []Children) []string {
+	var got []string
+
+	for _, b := range blocks {
+		var (
+			kind  string
+			block *Block
+		)
+
+		switch {
+		case b.Paragraph != nil:
+			kind, block = "paragraph", b.Paragraph
+		case b.Heading1 != nil:
+			kind, block = "h1", b.Heading1
+		case b.Heading2 != nil:
+			kind, block = "h2", b.Heading2
+		case b.Heading3 != nil:
+			kind, block = "h3", b.Heading3
+		case b.Bulleted != nil:
+			kind, block = "bullet", b.Bulleted
+		case b.Numbered != nil:
+			kind, block = "numbered", b.Numbered
+		case b.Quote != nil:
+			kind, block = "quote", b.Quote
+		case b.ToDo != nil:
+			kind, block = fmt.Sprintf("todo(%t)", b.ToDo.Checked), b.ToDo
+		case b.Code != nil:
+			kind, block = "code("+b.Code.Language+")", b.Code
+		case b.Divider != nil:
+			got = append(got, "divider")
+			continue
+		}
+
+		got = append(got, kind+" "+describeRichText(block.RichText))
+	}
+
+	return got
+}
+
+func TestMarkdownToBlocks(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		want     []string
+	}{
+		{
+			name:     "paragraphs",
+			markdown: "one\ntwo\n\nthree",
+			want:     []string{"paragraph [one two]", "paragraph [three]"},
+		},
+		{
+			name:     "headings",
+			markdown: "# One\n## Two ##\n#### Four",
+			want:     []string{"h1 [One]", "h2 [Two]", "h3 [Four]"},
+		},
+		{
+			name:     "lists",
+			markdown: "- a\n* **b**\n  + c\n1. d\n2) e",
+			want:     []string{"bullet [a]", "bullet b[b]", "bullet [c]", "numbered [d]", "numbered [e]"},
+		},
+		{
+			name:     "to-dos",
+			markdown: "- [ ] open\n- [x] done",
+			want:     []string{"todo(false) [open]", "todo(true) [done]"},
+		},
+		{
+			name:     "quote",
+			markdown: "> one\n> two\nafter",
+			want:     []string{"quote [one\ntwo]", "paragraph [after]"},
+		},
+		{
+			name:     "code",
+			markdown: "```go\nfunc f() {}\n```\n```brainfuck\n+\n```",
+			want:     []string{"code(go) [func f() {}]", "code(plain text) [+]"},
+		},
+		{
+			name:     "divider",
+			markdown: "above\n\n---\nbelow",
+			want:     []string{"paragraph [above]", "divider", "paragraph [below]"},
+		},
+		{
+			name:     "CRLF",
+			markdown: "# Title\r\ntext\r\n",
+			want:     []string{"h1 [Title]", "paragraph [text]"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := describeBlocks(markdownToBlocks(tt.markdown))
+			if strings.Join(got, "\n--\n") != strings.Join(tt.want, "\n--\n") {
+				t.Errorf("markdownToBlocks(%q) = %q; want %q", tt.markdown, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarkdownToBlocksRichTextLimit(t *testing.T) {
+	// Each formatted word is a run of its own, with a plain space between,
+	// making 239 runs.
+	words := strings.TrimSpace(strings.Repeat("**w** ", 120))
+
+	tests := []struct {
+		name     string
+		markdown string
+	}{
+		{"paragraph", words},
+		{"heading", "# " + words},
+		{"bullet", "- " + words},
+		{"to-do", "- [x] " + words},
+		{"quote", "> " + words},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blocks := markdownToBlocks(tt.markdown)
+			if len(blocks) != 3 {
+				t.Fatalf("got %d blocks; want the runs split over 3", len(blocks))
+			}
+
+			total := 0
+			for _, b := range blocks {
+				for _, block := range []*Block{b.Paragraph, b.Heading1, b.Bulleted, b.ToDo, b.Quote} {
+					if block == nil {
+						continue
+					}
+					if len(block.RichText) > maxNotionRichText {
+						t.Errorf("block has %d rich text objects; want at most %d", len(block.RichText), maxNotionRichText)
+					}
+					if b.ToDo != nil && !b.ToDo.Checked {
+						t.Errorf("continued to-do isn't checked")
+					}
+					total += len(block.RichText)
+				}
+			}
+
+			if total != 239 {
+				t.Errorf("got %d rich text objects in all; want 239", total)
+			}
+		})
+	}
+}
@@ -0,0 +1,762 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+)
+
+// maxNotionTextTokens keeps each block's text well under Notion's limit of
+// 2000 characters.
+const maxNotionTextTokens = 450
+
+// maxNotionChildren is the most blocks Notion accepts as the children of
+// one block in a request.
+const maxNotionChildren = 100
+
+// maxNotionRichText is the most rich text objects Notion accepts in one
+// block.
+const maxNotionRichText = 100
+
+type Parent struct {
+	Type       string `json:"type"`
+	DatabaseId string `json:"database_id"`
+}
+
+type Text struct {
+	Content string `json:"content"`
+	Link    *Link  `json:"link,omitempty"`
+}
+
+type Link struct {
+	URL string `json:"url"`
+}
+
+type RichText struct {
+	Text        Text         `json:"text"`
+	Annotations *Annotations `json:"annotations,omitempty"`
+}
+
+type Annotations struct {
+	Bold   bool `json:"bold"`
+	Italic bool `json:"italic"`
+	Code   bool `json:"code"`
+}
+
+type Block struct {
+	RichText     []RichText `json:"rich_text"`
+	Icon         *Icon      `json:"icon,omitempty"`
+	IsToggleable bool       `json:"is_toggleable,omitempty"`
+	Checked      bool       `json:"checked,omitempty"`
+	Language     string     `json:"language,omitempty"`
+	Children     []Children `json:"children,omitempty"`
+}
+
+type Divider struct{}
+
+type Title struct {
+	Text Text `json:"text"`
+}
+
+type Name struct {
+	Title []Title `json:"title"`
+}
+
+type Property struct {
+	Name Name `json:"Name"`
+}
+
+// TableOfContents is Notion's outline of a page's headings, each linking
+// to its heading.
+type TableOfContents struct{}
+
+type Children struct {
+	Object          string           `json:"object"`
+	Paragraph       *Block           `json:"paragraph,omitempty"`
+	Heading1        *Block           `json:"heading_1,omitempty"`
+	Heading2        *Block           `json:"heading_2,omitempty"`
+	Heading3        *Block           `json:"heading_3,omitempty"`
+	TableOfContents *TableOfContents `json:"table_of_contents,omitempty"`
+	Callout         *Block           `json:"callout,omitempty"`
+	Code            *Block           `json:"code,omitempty"`
+	Divider         *Divider         `json:"divider,omitempty"`
+	Audio           *FileBlock       `json:"audio,omitempty"`
+	Bulleted        *Block           `json:"bulleted_list_item,omitempty"`
+	Numbered        *Block           `json:"numbered_list_item,omitempty"`
+	Quote           *Block           `json:"quote,omitempty"`
+	ToDo            *Block           `json:"to_do,omitempty"`
+}
+
+type NotionPage struct {
+	Parent     Parent     `json:"parent"`
+	Properties Property   `json:"properties"`
+	Children   []Children `json:"children,omitempty"`
+}
+
+type NotionApiError struct {
+	Object  string `json:"object"`
+	Status  int    `json:"status"`
+	Code    string `json:"validation_error"`
+	Message string `json:"message"`
+}
+
+type TokenRequest struct {
+	GrantType   string `json:"grant_type"`
+	Code        string `json:"code"`
+	RedirectUri string `json:"redirect_uri"`
+}
+
+type NotionOwner struct {
+	Type string     `json:"type"`
+	User NotionUser `json:"user"`
+}
+
+type NotionUser struct {
+	Object    string `json:"object"`
+	Id        string `json:"id"`
+	Name      string `json:"name"`
+	AvatarUrl string `json:"avatar_url,omitempty"`
+	Type      string `json:"type"`
+	Person    struct {
+		Email string `json:"email"`
+	} `json:"person"`
+}
+
+type TokenResponse struct {
+	AccessToken          string      `json:"access_token"`
+	TokenType            string      `json:"token_type"`
+	BotId                string      `json:"bot_id"`
+	WorkspaceName        string      `json:"workspace_name"`
+	WorkspaceIcon        string      `json:"workspace_icon,omitempty"`
+	WorkspaceId          string      `json:"workspace_id"`
+	Owner                NotionOwner `json:"owner"`
+	DuplicatedTemplateId string      `json:"duplicated_template_id,omitempty"`
+	RequestId            string      `json:"request_id"`
+}
+
+type Filter struct {
+	Value    string `json:"value"`
+	Property string `json:"property"`
+}
+
+type SearchRequestBody struct {
+	Query  string  `json:"query,omitempty"`
+	Filter *Filter `json:"filter,omitempty"`
+}
+
+type Icon struct {
+	Type  string `json:"type"`
+	Emoji string `json:"emoji"`
+}
+
+type NotionResult struct {
+	Id    string  `json:"id"`
+	Title []Title `json:"title"`
+	Icon  Icon    `json:"icon"`
+}
+
+type SearchResponseBody struct {
+	Results []NotionResult `json:"results"`
+}
+
+type NotionPageResponse struct {
+	Object   string `json:"object"`
+	Id       string `json:"id"`
+	Url      string `json:"url"`
+	Archived bool   `json:"archived"`
+}
+
+// ResponseSchemaForNotion is the summarizer's output. Sections holds the
+// fields defined by the job's template, such as action_items or decisions,
+// keyed by field. In JSON the sections sit alongside logical_paragraphs and
+// summary rather than nested, matching what the model is asked to return.
+// Chapters is only set for recordings long enough to be divided into them.
+type ResponseSchemaForNotion struct {
+	LogicalParagraphs string
+	Summary           string
+	Chapters          []Chapter
+	Sections          map[string][]string
+}
+
+func (r ResponseSchemaForNotion) MarshalJSON() ([]byte, error) {
+	fields := map[string]any{
+		"logical_paragraphs": r.LogicalParagraphs,
+		"summary":            r.Summary,
+	}
+
+	if r.Chapters != nil {
+		fields["chapters"] = r.Chapters
+	}
+
+	for key, entries := range r.Sections {
+		if entries == nil {
+			entries = []string{}
+		}
+		fields[key] = entries
+	}
+
+	return json.Marshal(fields)
+}
+
+func (r *ResponseSchemaForNotion) UnmarshalJSON(b []byte) error {
+	var fields map[string]json.RawMessage
+
+	err := json.Unmarshal(b, &fields)
+	if err != nil {
+		return err
+	}
+
+	*r = ResponseSchemaForNotion{Sections: map[string][]string{}}
+
+	for key, raw := range fields {
+		switch key {
+		case "logical_paragraphs":
+			err = json.Unmarshal(raw, &r.LogicalParagraphs)
+		case "summary":
+			err = json.Unmarshal(raw, &r.Summary)
+		case "chapters":
+			if json.Unmarshal(raw, &r.Chapters) == nil {
+				continue
+			}
+			// Templates written before chapters were timed could have a
+			// field of the same name.
+			r.Chapters = nil
+			fallthrough
+		default:
+			var entries []string
+			err = json.Unmarshal(raw, &entries)
+			if err != nil {
+				// Tolerate a single string where a list was asked for.
+				var entry string
+				if json.Unmarshal(raw, &entry) == nil {
+					entries, err = []string{entry}, nil
+				}
+			}
+			r.Sections[key] = entries
+		}
+
+		if err != nil {
+			return fmt.Errorf("decoding %q: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+func generateAuthHeader(authType string, credentials string) string {
+	switch authType {
+	case "basic":
+		return "Basic " + credentials
+	default:
+		return "Bearer " + credentials
+	}
+}
+
+func doNotionApiRequest(endpoint string, payload []byte, auth string, method string) (*http.Response, error) {
+	client := &http.Client{}
+	req, err := http.NewRequest(method, "https://api.notion.com/v1/"+endpoint, bytes.NewReader(payload))
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Notion-Version", "2022-06-28")
+	req.Header.Add("Authorization", auth)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// ExchangeOAuthCode swaps the code from Notion's OAuth redirect for an access
+// token using the NOTION_CLIENT_ID and NOTION_CLIENT_SECRET credentials.
+func ExchangeOAuthCode(code string, redirectUri string) (TokenResponse, error) {
+	tokenRequest := &TokenRequest{
+		GrantType:   "authorization_code",
+		Code:        code,
+		RedirectUri: redirectUri,
+	}
+
+	marshalled, err := json.Marshal(tokenRequest)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	encodedBasicCredentials := generateAuthHeader("basic", base64.StdEncoding.EncodeToString([]byte(os.Getenv("NOTION_CLIENT_ID")+":"+os.Getenv("NOTION_CLIENT_SECRET"))))
+	resp, err := doNotionApiRequest("oauth/token", marshalled, encodedBasicCredentials, "POST")
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	var tokenResponse TokenResponse
+
+	err = json.Unmarshal(b, &tokenResponse)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	return tokenResponse, nil
+}
+
+func SearchSharedDatabases(notionAccessToken string) ([]NotionResult, error) {
+	searchRequest := &SearchRequestBody{
+		Filter: &Filter{
+			Value:    "database",
+			Property: "object",
+		},
+	}
+
+	marshalled, err := json.Marshal(searchRequest)
+	if err != nil {
+		return []NotionResult{}, err
+	}
+
+	resp, err := doNotionApiRequest("search", marshalled, generateAuthHeader("bearer", notionAccessToken), "POST")
+	if err != nil {
+		return []NotionResult{}, err
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return []NotionResult{}, err
+	}
+	defer resp.Body.Close()
+
+	var searchResponse = &SearchResponseBody{}
+
+	err = json.Unmarshal(b, searchResponse)
+	if err != nil {
+		return []NotionResult{}, err
+	}
+
+	return searchResponse.Results, nil
+}
+
+// createNotionPage creates an empty page for the transcript in the
+// database. Its content is appended separately, so that Notion reports the
+// IDs of the blocks it creates.
+func (p *Pipeline) createNotionPage(fileName string, notionPageId string, notionAccessToken string) (NotionPageResponse, error) {
+	newNotionPage := &NotionPage{
+		Parent: Parent{
+			Type:       "database_id",
+			DatabaseId: notionPageId,
+		},
+		Properties: Property{
+			Name: Name{
+				Title: []Title{
+					{
+						Text: Text{
+							Content: fileName + " Transcribed Audio",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	marshalled, err := json.Marshal(newNotionPage)
+	if err != nil {
+		return NotionPageResponse{}, err
+	}
+
+	resp, err := doNotionApiRequest("pages", marshalled, generateAuthHeader("bearer", notionAccessToken), "POST")
+	if err != nil {
+		return NotionPageResponse{}, err
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return NotionPageResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var notionError NotionApiError
+		err = json.Unmarshal(b, &notionError)
+		if err != nil {
+			return NotionPageResponse{}, err
+		}
+		return NotionPageResponse{}, errors.New(notionError.Message)
+	}
+
+	var page NotionPageResponse
+	err = json.Unmarshal(b, &page)
+	if err != nil {
+		return NotionPageResponse{}, err
+	}
+
+	return page, nil
+}
+
+func createParagraphElement(content string) Children {
+	return Children{
+		Object: "block",
+		Paragraph: &Block{
+			RichText: []RichText{
+				{
+					Text: Text{
+						Content: content,
+					},
+				},
+			},
+		},
+	}
+}
+
+func createHeading2Element(content string) Children {
+	return Children{
+		Object: "block",
+		Heading2: &Block{
+			RichText: []RichText{
+				{
+					Text: Text{
+						Content: content,
+					},
+				},
+			},
+		},
+	}
+}
+
+// createBlockElements creates the blocks for one entry of a template
+// field. Markdown fields can become any number of blocks; the others are a
+// single block of the field's type, with any inline Markdown formatted.
+func createBlockElements(blockType string, content string) []Children {
+	if blockType == BlockMarkdown {
+		return markdownToBlocks(content)
+	}
+
+	return newRichTextBlocks(blockType, markdownToRichText(content))
+}
+
+// newRichTextBlocks creates blocks of one of the template block types,
+// continuing in further blocks of the same type when there is more rich
+// text than Notion accepts in one.
+func newRichTextBlocks(blockType string, richText []RichText) []Children {
+	var blocks []Children
+
+	for _, chunk := range chunkRichText(richText) {
+		blocks = append(blocks, newRichTextBlock(blockType, chunk))
+	}
+
+	return blocks
+}
+
+// chunkRichText splits rich text into pieces Notion accepts in one block.
+// Empty rich text stays as one empty piece.
+func chunkRichText(richText []RichText) [][]RichText {
+	chunks := [][]RichText{richText[:min(maxNotionRichText, len(richText))]}
+
+	for i := maxNotionRichText; i < len(richText); i += maxNotionRichText {
+		chunks = append(chunks, richText[i:min(i+maxNotionRichText, len(richText))])
+	}
+
+	return chunks
+}
+
+// newRichTextBlock creates a block of one of the template block types.
+func newRichTextBlock(blockType string, richText []RichText) Children {
+	block := &Block{
+		RichText: richText,
+	}
+
+	child := Children{Object: "block"}
+
+	switch blockType {
+	case BlockBulletedList:
+		child.Bulleted = block
+	case BlockNumberedList:
+		child.Numbered = block
+	case BlockQuote:
+		child.Quote = block
+	case BlockToDo:
+		child.ToDo = block
+	case BlockCallout:
+		block.Icon = &Icon{Type: "emoji", Emoji: "💡"}
+		child.Callout = block
+	default:
+		child.Paragraph = block
+	}
+
+	return child
+}
+
+// createSpeakerParagraphElements creates the paragraphs for one speaker's
+// turn, the first starting with their name in bold. Long turns are split
+// between sentences to stay within Notion's limit on a block's text.
+func createSpeakerParagraphElements(name string, text string) []Children {
+	var elements []Children
+
+	for i, chunk := range splitTranscript(text, maxNotionTextTokens) {
+		element := createParagraphElement(chunk)
+
+		if i == 0 {
+			element.Paragraph.RichText = append([]RichText{{
+				Text:        Text{Content: name + ": "},
+				Annotations: &Annotations{Bold: true},
+			}}, element.Paragraph.RichText...)
+		}
+
+		elements = append(elements, element)
+	}
+
+	return elements
+}
+
+// createChapterElements creates a chapter's heading, with the time it
+// starts, and its one-line summary in italics.
+func createChapterElements(chapter Chapter) []Children {
+	heading := createHeading3Element(chapter.Start + " " + chapter.Title)
+
+	summary := createParagraphElement(chapter.Summary)
+	summary.Paragraph.RichText[0].Annotations = &Annotations{Italic: true}
+
+	return []Children{heading, summary}
+}
+
+// transcriptParagraph is one paragraph of the transcript and the blocks it
+// is written out as.
+type transcriptParagraph struct {
+	text   string
+	blocks []Children
+}
+
+// createHeading3Element creates a small heading, used for chapters and for
+// sections inside the collapsible layout's callout.
+func createHeading3Element(content string) Children {
+	return Children{
+		Object: "block",
+		Heading3: &Block{
+			RichText: []RichText{
+				{
+					Text: Text{
+						Content: content,
+					},
+				},
+			},
+		},
+	}
+}
+
+// createCalloutElements creates a callout holding children, which the
+// collapsible layout uses to put the summary and fields first. Like
+// createToggleHeadingElements, it continues in further callouts when there
+// are more children than Notion accepts in one.
+func createCalloutElements(children []Children) []Children {
+	var elements []Children
+
+	for i := 0; i < len(children); i += maxNotionChildren {
+		elements = append(elements, Children{
+			Object: "block",
+			Callout: &Block{
+				RichText: []RichText{},
+				Icon:     &Icon{Type: "emoji", Emoji: "📝"},
+				Children: children[i:min(i+maxNotionChildren, len(children))],
+			},
+		})
+	}
+
+	return elements
+}
+
+// createToggleHeadingElements creates toggle headings that open to show
+// children. Notion limits how many children a block can be created with,
+// so long transcripts are continued under further toggles.
+func createToggleHeadingElements(content string, children []Children) []Children {
+	var elements []Children
+
+	for i := 0; i < len(children) || i == 0; i += maxNotionChildren {
+		heading := content
+		if i > 0 {
+			heading += " (continued)"
+		}
+
+		element := createHeading2Element(heading)
+		element.Heading2.IsToggleable = true
+		element.Heading2.Children = children[i:min(i+maxNotionChildren, len(children))]
+
+		elements = append(elements, element)
+	}
+
+	return elements
+}
+
+// createTranscriptElements creates the transcript's blocks, speaker by
+// speaker if it was diarized, grouped under a heading for each chapter if
+// it has any. It reports whether it has chapters.
+func createTranscriptElements(responseSchemaForNotion ResponseSchemaForNotion, speakers Speakers, segments []WhisperSegment) ([]Children, bool) {
+	var transcript []transcriptParagraph
+
+	if len(speakers.Turns) > 0 {
+		for _, turn := range speakers.Turns {
+			transcript = append(transcript, transcriptParagraph{
+				text:   turn.Text,
+				blocks: createSpeakerParagraphElements(speakers.Name(turn.Speaker), turn.Text),
+			})
+		}
+	} else {
+		splitParagraphs := strings.Split(responseSchemaForNotion.LogicalParagraphs, "\n\n")
+
+		for _, splitStr := range splitParagraphs {
+			transcript = append(transcript, transcriptParagraph{
+				text:   splitStr,
+				blocks: []Children{createParagraphElement(splitStr)},
+			})
+		}
+	}
+
+	chapters := responseSchemaForNotion.Chapters
+	if len(segments) == 0 {
+		chapters = nil
+	}
+
+	chapterAt := map[int][]Chapter{}
+	if len(chapters) > 0 {
+		texts := make([]string, len(transcript))
+		for i, paragraph := range transcript {
+			texts[i] = paragraph.text
+		}
+
+		for i, start := range chapterStarts(chapters, texts, segments) {
+			chapterAt[start] = append(chapterAt[start], chapters[i])
+		}
+	}
+
+	var elements []Children
+
+	for i, paragraph := range transcript {
+		for _, chapter := range chapterAt[i] {
+			elements = append(elements, createChapterElements(chapter)...)
+		}
+
+		elements = append(elements, paragraph.blocks...)
+	}
+
+	return elements, len(chapters) > 0
+}
+
+// mapChatResponseToNotionPage lays out the page's sections in the order
+// the template gives. In the classic layout each section has its own
+// heading. In the collapsible layout, the sections other than the
+// transcript are gathered into a callout and the transcript goes in a
+// toggle heading, so the summary isn't buried beneath it.
+func mapChatResponseToNotionPage(responseSchemaForNotion ResponseSchemaForNotion, template models.SummaryTemplate, speakers Speakers, segments []WhisperSegment) []Children {
+	paragraphs := []Children{}
+
+	transcript, hasChapters := createTranscriptElements(responseSchemaForNotion, speakers, segments)
+
+	// Chapters are listed in an outline at the top of the page.
+	if hasChapters {
+		paragraphs = append(paragraphs, Children{
+			Object:          "block",
+			TableOfContents: &TableOfContents{},
+		})
+	}
+
+	collapsible := template.Layout == LayoutCollapsible
+
+	var callout []Children
+	flushCallout := func() {
+		if len(callout) > 0 {
+			paragraphs = append(paragraphs, createCalloutElements(callout)...)
+			callout = nil
+		}
+	}
+
+	for _, section := range TemplateOrder(template) {
+		var (
+			heading  string
+			elements []Children
+		)
+
+		switch section {
+		case SectionTranscript:
+			if collapsible {
+				flushCallout()
+				paragraphs = append(paragraphs, createToggleHeadingElements("Transcription", transcript)...)
+			} else {
+				paragraphs = append(paragraphs, createHeading2Element("Transcription"))
+				paragraphs = append(paragraphs, transcript...)
+			}
+			continue
+
+		case SectionSummary:
+			heading = "Summary"
+			elements = markdownToBlocks(responseSchemaForNotion.Summary)
+
+		default:
+			i := slices.IndexFunc(template.Fields, func(field models.TemplateField) bool {
+				return field.Key == section
+			})
+			if i < 0 {
+				continue
+			}
+			field := template.Fields[i]
+
+			entries := responseSchemaForNotion.Sections[field.Key]
+			if len(entries) == 0 {
+				continue
+			}
+
+			heading = field.Heading
+			for _, entry := range entries {
+				elements = append(elements, createBlockElements(field.Block, entry)...)
+			}
+		}
+
+		if collapsible {
+			callout = append(callout, createHeading3Element(heading))
+			callout = append(callout, elements...)
+		} else {
+			paragraphs = append(paragraphs, createHeading2Element(heading))
+			paragraphs = append(paragraphs, elements...)
+		}
+	}
+
+	flushCallout()
+
+	return paragraphs
+}
+
+func GetBotDataFromToken(notionAccessToken string) error {
+	resp, err := doNotionApiRequest("users/me", []byte{}, generateAuthHeader("bearer", notionAccessToken), "GET")
+	if err != nil {
+		return err
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var notionError NotionApiError
+		err = json.Unmarshal(b, &notionError)
+		if err != nil {
+			return err
+		}
+		return errors.New(notionError.Message)
+	}
+
+	return nil
+}
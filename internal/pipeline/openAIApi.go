@@ -0,0 +1,353 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+)
+
+// WhisperApiResponse is a transcription. Cached is set when it came from
+// the transcript cache rather than from Whisper, so nothing was paid for.
+type WhisperApiResponse struct {
+	Text     string           `json:"text"`
+	Language string           `json:"language"`
+	Duration float64          `json:"duration"`
+	Segments []WhisperSegment `json:"segments"`
+	Cached   bool             `json:"-"`
+}
+
+// WhisperSegment is a stretch of the transcript with its timing in seconds.
+type WhisperSegment struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+}
+
+// WhisperApiError is the error body returned by all of OpenAI's endpoints,
+// not only Whisper's.
+type WhisperApiError struct {
+	Error struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+		Param   string `json:"param"`
+		Code    string `json:"code"`
+	} `json:"error"`
+}
+
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type ChatCompletion struct {
+	Model          string         `json:"model"`
+	Messages       []ChatMessage  `json:"messages"`
+	ResponseFormat ResponseFormat `json:"response_format"`
+}
+
+// PropertyDefinition is one property of a Schema. Properties, Required and
+// AdditionalProperties are only set on objects.
+type PropertyDefinition struct {
+	Description          string                        `json:"description"`
+	Type                 string                        `json:"type"`
+	Items                *PropertyDefinition           `json:"items,omitempty"`
+	Properties           map[string]PropertyDefinition `json:"properties,omitempty"`
+	Required             []string                      `json:"required,omitempty"`
+	AdditionalProperties *bool                         `json:"additionalProperties,omitempty"`
+}
+
+type Schema struct {
+	Type                 string                        `json:"type"`
+	Properties           map[string]PropertyDefinition `json:"properties"`
+	Required             []string                      `json:"required"`
+	AdditionalProperties bool                          `json:"additionalProperties"`
+}
+
+type JsonSchema struct {
+	Name   string `json:"name"`
+	Strict bool   `json:"strict"`
+	Schema Schema `json:"schema"`
+}
+
+type ResponseFormat struct {
+	Type       string     `json:"type"`
+	JsonSchema JsonSchema `json:"json_schema"`
+}
+
+type ChatResponse struct {
+	Choices []struct {
+		Index   int `json:"index"`
+		Message struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+			Refusal string `json:"refusal"`
+		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage ChatUsage `json:"usage"`
+}
+
+type ChatUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func doOpenAIRequest(endpoint string, payload io.Reader, method string, contentType string) (*http.Response, error) {
+	client := &http.Client{}
+
+	req, err := http.NewRequest(method, "https://api.openai.com/v1/"+endpoint, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Content-Type", contentType)
+	req.Header.Add("Authorization", "Bearer "+os.Getenv("OPENAI_API_KEY"))
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// sendTranscriptionToWhisper transcribes the job's audio, or translates it
+// to English if the job asks for that. The verbose response format is used
+// so that Whisper reports the language it detected. Results are kept in the
+// transcript cache, which is checked first.
+func (p *Pipeline) sendTranscriptionToWhisper(job models.Job, audio []byte, filename string, prompt string) (WhisperApiResponse, error) {
+	if p.MockOpenAI {
+		b, err := os.ReadFile("./mocks/completed-transcription.txt")
+		if err != nil {
+			p.Logger.Error(err.Error())
+			return WhisperApiResponse{}, err
+		}
+		return WhisperApiResponse{Text: string(b), Language: job.Language}, nil
+	}
+
+	cacheKey, err := transcriptCacheKey(job, audio, prompt)
+	if err != nil {
+		return WhisperApiResponse{}, err
+	}
+
+	if cached, ok := p.cachedTranscription(job, cacheKey); ok {
+		return cached, nil
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		return WhisperApiResponse{}, err
+	}
+
+	_, err = part.Write(audio)
+	if err != nil {
+		return WhisperApiResponse{}, err
+	}
+
+	fields := map[string]string{
+		"model":           transcriptionModel,
+		"response_format": "verbose_json",
+		"prompt":          prompt,
+	}
+
+	endpoint := "audio/transcriptions"
+	if job.Translate {
+		// The translations endpoint always produces English and doesn't
+		// take a language.
+		endpoint = "audio/translations"
+	} else {
+		fields["language"] = job.Language
+	}
+
+	for name, value := range fields {
+		if value == "" {
+			continue
+		}
+
+		err = writer.WriteField(name, value)
+		if err != nil {
+			return WhisperApiResponse{}, err
+		}
+	}
+	writer.Close() // don't defer this
+
+	resp, err := doOpenAIRequest(endpoint, body, "POST", writer.FormDataContentType())
+	if err != nil {
+		return WhisperApiResponse{}, err
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return WhisperApiResponse{}, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var whisperError WhisperApiError
+		err = json.Unmarshal(b, &whisperError)
+		if err != nil {
+			return WhisperApiResponse{}, err
+		}
+		return WhisperApiResponse{}, errors.New(whisperError.Error.Message)
+	}
+
+	var whisperResponse WhisperApiResponse
+	err = json.Unmarshal(b, &whisperResponse)
+	if err != nil {
+		return WhisperApiResponse{}, err
+	}
+
+	p.cacheTranscription(job, cacheKey, whisperResponse)
+
+	return whisperResponse, nil
+}
+
+// summarySchema asks for a summary and a list of strings for each of the
+// template's fields, for the transcript's paragraphs if withParagraphs is
+// set and for its chapters if withChapters is. Every field is required, as
+// strict structured output demands.
+func summarySchema(template models.SummaryTemplate, withParagraphs bool, withChapters bool) Schema {
+	properties := map[string]PropertyDefinition{
+		"summary": {
+			Description: "The summary of the transcribed audio, which may use Markdown for emphasis, lists and links",
+			Type:        "string",
+		},
+	}
+
+	if withParagraphs {
+		properties["logical_paragraphs"] = PropertyDefinition{
+			Description: "The logical paragraphs of the transcribed audio",
+			Type:        "string",
+		}
+	}
+
+	if withChapters {
+		properties["chapters"] = chaptersProperty
+	}
+
+	for _, field := range template.Fields {
+		description := field.Description
+		if field.Block == BlockMarkdown {
+			description = strings.TrimRight(description, ".") + ". Each entry is Markdown, which may use headings, lists, to-dos, quotes, code, bold, italics and links"
+		}
+
+		properties[field.Key] = PropertyDefinition{
+			Description: description,
+			Type:        "array",
+			Items: &PropertyDefinition{
+				Type: "string",
+			},
+		}
+	}
+
+	return Schema{
+		Type:                 "object",
+		Properties:           properties,
+		Required:             slices.Sorted(maps.Keys(properties)),
+		AdditionalProperties: false,
+	}
+}
+
+// formatAndSummarizeTranscription asks the chat model for the paragraphs,
+// summary and template sections of a transcript, and for its chapters if
+// withChapters is set, in which case the transcript is marked with
+// timestamps. Long transcripts are sent in parts, and part and parts tell
+// the model where this one falls.
+func (p *Pipeline) formatAndSummarizeTranscription(job models.Job, transcribedText string, summaryLanguage string, template models.SummaryTemplate, withChapters bool, part int, parts int) (ResponseSchemaForNotion, error) {
+	systemPrompt := "You are an assistant who's job is to take an audio transcription and first break up the text into logical paragraphs. Each paragraph needs to be under 2000 characters. " + template.Prompt
+	if withChapters {
+		systemPrompt += " The transcription is marked with timestamps like [12:34] saying when it was said; leave them out of the paragraphs. " +
+			"Also divide it into chapters wherever the topic changes, giving each a short title, the timestamp it starts at and a one-line summary."
+	}
+	if parts > 1 {
+		systemPrompt += fmt.Sprintf(" This is part %d of %d of a longer transcription. Only summarize this part; it will be combined with the others later.", part, parts)
+	}
+	if summaryLanguage != "" {
+		systemPrompt += " Keep the paragraphs in the language of the transcription, but write the summary and action items in " + summaryLanguage + "."
+	}
+
+	return p.completeStructured(job, systemPrompt, transcribedText, summarySchema(template, true, withChapters))
+}
+
+// mergeSummaries asks the chat model to combine the summaries and sections
+// of consecutive parts of a transcript into one. partials is the JSON of
+// those parts without their paragraphs.
+func (p *Pipeline) mergeSummaries(job models.Job, partials string, summaryLanguage string, template models.SummaryTemplate) (ResponseSchemaForNotion, error) {
+	systemPrompt := "You are an assistant who's job is to combine the summaries of consecutive parts of one long audio transcription, given as a JSON array in order. " +
+		"Write a single summary of the whole recording rather than a summary of each part, and merge each list, removing duplicates and keeping the order things were said in. " +
+		"The parts were summarized with these instructions: " + template.Prompt
+	if summaryLanguage != "" {
+		systemPrompt += " Write the summary and action items in " + summaryLanguage + "."
+	}
+
+	return p.completeStructured(job, systemPrompt, partials, summarySchema(template, false, false))
+}
+
+func (p *Pipeline) createChatCompletion(messages []ChatMessage, schema Schema) (ChatResponse, error) {
+	var chatResponse ChatResponse
+
+	if p.MockOpenAI {
+		b, err := os.ReadFile("./mocks/completed-summary.json")
+		if err != nil {
+			return chatResponse, err
+		}
+
+		err = json.Unmarshal(b, &chatResponse)
+		return chatResponse, err
+	}
+
+	chatCompletion := &ChatCompletion{
+		Model:    chatModel,
+		Messages: messages,
+		ResponseFormat: ResponseFormat{
+			Type: "json_schema",
+			JsonSchema: JsonSchema{
+				Name:   "response_schema",
+				Strict: true,
+				Schema: schema,
+			},
+		},
+	}
+
+	marshalled, err := json.Marshal(chatCompletion)
+	if err != nil {
+		return chatResponse, err
+	}
+
+	resp, err := doOpenAIRequest("chat/completions", bytes.NewReader(marshalled), "POST", "application/json")
+
+	if err != nil {
+		return chatResponse, err
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return chatResponse, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiError WhisperApiError
+		err = json.Unmarshal(b, &apiError)
+		if err != nil || apiError.Error.Message == "" {
+			return chatResponse, fmt.Errorf("chat completion failed: %s", resp.Status)
+		}
+		return chatResponse, errors.New(apiError.Error.Message)
+	}
+
+	err = json.Unmarshal(b, &chatResponse)
+	return chatResponse, err
+}
@@ -0,0 +1,199 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+)
+
+type notionBlock struct {
+	Id       string `json:"id"`
+	Archived bool   `json:"archived"`
+}
+
+type notionBlockList struct {
+	Results []notionBlock `json:"results"`
+}
+
+type appendBlocksRequest struct {
+	Children []Children `json:"children"`
+	After    string     `json:"after,omitempty"`
+}
+
+// publishNotionPage puts the job's content on its Notion page and returns
+// the page with the IDs of the blocks added to it. A job that was published
+// before has the content it added then replaced, as long as its page is
+// still there. Otherwise a new page is created.
+func (p *Pipeline) publishNotionPage(job models.Job, children []Children, notionAccessToken string) (NotionPageResponse, []string, error) {
+	var previous []string
+
+	if job.NotionBlockIDs != "" {
+		err := json.Unmarshal([]byte(job.NotionBlockIDs), &previous)
+		if err != nil {
+			return NotionPageResponse{}, nil, err
+		}
+	}
+
+	// A page published before blocks were recorded can't tell the job's
+	// blocks from the user's, so it is left alone.
+	if job.NotionPageID != "" && len(previous) > 0 {
+		page, found, err := getNotionPage(job.NotionPageID, notionAccessToken)
+		if err != nil {
+			return NotionPageResponse{}, nil, err
+		}
+
+		if found && !page.Archived {
+			ids, err := p.replaceNotionBlocks(job, page.Id, previous, children, notionAccessToken)
+			return page, ids, err
+		}
+
+		p.Logger.Info("The job's Notion page is gone, creating a new one", "job", job.ID)
+	}
+
+	page, err := p.createNotionPage(job.Filename, job.NotionDatabaseID, notionAccessToken)
+	if err != nil {
+		return NotionPageResponse{}, nil, err
+	}
+
+	ids, err := appendNotionBlocks(page.Id, "", children, notionAccessToken)
+	if err != nil {
+		// Leave no half-written page behind.
+		archiveErr := archiveNotionPage(page.Id, notionAccessToken)
+		if archiveErr != nil {
+			p.Logger.Warn("Couldn't archive the unfinished Notion page", "job", job.ID, "error", archiveErr.Error())
+		}
+		return NotionPageResponse{}, nil, err
+	}
+
+	return page, ids, nil
+}
+
+// replaceNotionBlocks puts the new content where the job's previous content
+// starts and archives the previous content, leaving anything the user added
+// to the page where it was.
+func (p *Pipeline) replaceNotionBlocks(job models.Job, pageId string, previous []string, children []Children, notionAccessToken string) ([]string, error) {
+	after := ""
+	for _, id := range previous {
+		block, found, err := getNotionBlock(id, notionAccessToken)
+		if err != nil {
+			return nil, err
+		}
+
+		if found && !block.Archived {
+			after = id
+			break
+		}
+	}
+
+	// If the new content can't all be added, what was added is removed
+	// instead, leaving the page as it was.
+	ids, err := appendNotionBlocks(pageId, after, children, notionAccessToken)
+	if err != nil {
+		previous = ids
+	}
+
+	for _, id := range previous {
+		archiveErr := archiveNotionBlock(id, notionAccessToken)
+		if archiveErr != nil {
+			p.Logger.Warn("Couldn't remove a block from the Notion page", "job", job.ID, "block", id, "error", archiveErr.Error())
+		}
+	}
+
+	return ids, err
+}
+
+// appendNotionBlocks adds children to the end of a page or block, or after
+// the child with the ID after if it isn't empty, in batches as small as
+// Notion requires. It returns the IDs of the blocks added, including those
+// added before an error.
+func appendNotionBlocks(blockId string, after string, children []Children, notionAccessToken string) ([]string, error) {
+	var ids []string
+
+	auth := generateAuthHeader("bearer", notionAccessToken)
+
+	for start := 0; start < len(children); start += maxNotionChildren {
+		end := min(start+maxNotionChildren, len(children))
+
+		marshalled, err := json.Marshal(appendBlocksRequest{Children: children[start:end], After: after})
+		if err != nil {
+			return ids, err
+		}
+
+		resp, err := doNotionApiRequest("blocks/"+blockId+"/children", marshalled, auth, "PATCH")
+		if err != nil {
+			return ids, err
+		}
+
+		var list notionBlockList
+		err = decodeNotionResponse(resp, &list)
+		if err != nil {
+			return ids, err
+		}
+
+		// Inserting after a block can return the children that follow the
+		// new ones too, so only the first of the results are ours.
+		for _, block := range list.Results[:min(end-start, len(list.Results))] {
+			ids = append(ids, block.Id)
+		}
+
+		if len(ids) > 0 {
+			after = ids[len(ids)-1]
+		}
+	}
+
+	return ids, nil
+}
+
+// getNotionPage fetches a page, reporting whether it exists and the token
+// can see it.
+func getNotionPage(pageId string, notionAccessToken string) (NotionPageResponse, bool, error) {
+	var page NotionPageResponse
+	found, err := getNotionObject("pages/"+pageId, notionAccessToken, &page)
+	return page, found, err
+}
+
+func getNotionBlock(blockId string, notionAccessToken string) (notionBlock, bool, error) {
+	var block notionBlock
+	found, err := getNotionObject("blocks/"+blockId, notionAccessToken, &block)
+	return block, found, err
+}
+
+func getNotionObject(endpoint string, notionAccessToken string, v any) (bool, error) {
+	resp, err := doNotionApiRequest(endpoint, nil, generateAuthHeader("bearer", notionAccessToken), "GET")
+	if err != nil {
+		return false, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return false, nil
+	}
+
+	return true, decodeNotionResponse(resp, v)
+}
+
+// archiveNotionBlock moves a block to the trash, where the user can still
+// restore it from.
+func archiveNotionBlock(blockId string, notionAccessToken string) error {
+	resp, err := doNotionApiRequest("blocks/"+blockId, nil, generateAuthHeader("bearer", notionAccessToken), "DELETE")
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil
+	}
+
+	return decodeNotionResponse(resp, &notionBlock{})
+}
+
+func archiveNotionPage(pageId string, notionAccessToken string) error {
+	resp, err := doNotionApiRequest("pages/"+pageId, []byte(`{"archived":true}`), generateAuthHeader("bearer", notionAccessToken), "PATCH")
+	if err != nil {
+		return err
+	}
+
+	return decodeNotionResponse(resp, &NotionPageResponse{})
+}
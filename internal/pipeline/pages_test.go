@@ -0,0 +1,208 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+)
+
+// fakeNotionPage is a Notion page's blocks in order, served by a test
+// server that supports getting, appending and archiving them.
+type fakeNotionPage struct {
+	mu       sync.Mutex
+	blocks   []notionBlock
+	appended int
+
+	// failAfter, if set, fails every append after that many succeed.
+	failAfter int
+}
+
+// newFakeNotionPage sends Notion API requests to a fake page with blocks
+// of the given IDs.
+func newFakeNotionPage(t *testing.T, ids ...string) *fakeNotionPage {
+	t.Helper()
+
+	page := &fakeNotionPage{}
+	for _, id := range ids {
+		page.blocks = append(page.blocks, notionBlock{Id: id})
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(page.serveHTTP))
+	t.Cleanup(server.Close)
+
+	target, _ := url.Parse(server.URL)
+
+	transport := http.DefaultTransport
+	http.DefaultTransport = roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		r.URL.Scheme = target.Scheme
+		r.URL.Host = target.Host
+		return transport.RoundTrip(r)
+	})
+	t.Cleanup(func() { http.DefaultTransport = transport })
+
+	return page
+}
+
+func (page *fakeNotionPage) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	page.mu.Lock()
+	defer page.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/blocks/")
+
+	if id, ok := strings.CutSuffix(path, "/children"); ok && r.Method == http.MethodPatch && id == "page" {
+		if page.failAfter > 0 && page.appended >= page.failAfter {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(NotionApiError{Message: "too many blocks"})
+			return
+		}
+		page.appended++
+
+		var request struct {
+			Children []json.RawMessage `json:"children"`
+			After    string            `json:"after"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+
+		at := len(page.blocks)
+		if request.After != "" {
+			at = slices.IndexFunc(page.blocks, func(b notionBlock) bool { return b.Id == request.After }) + 1
+		}
+
+		var added []notionBlock
+		for range request.Children {
+			added = append(added, notionBlock{Id: fmt.Sprintf("new-%d", len(page.blocks)+len(added)+1)})
+		}
+		page.blocks = slices.Insert(page.blocks, at, added...)
+
+		// Like Notion, include the blocks that follow the new ones.
+		json.NewEncoder(w).Encode(notionBlockList{Results: page.blocks[at:]})
+		return
+	}
+
+	i := slices.IndexFunc(page.blocks, func(b notionBlock) bool { return b.Id == path })
+	if i < 0 {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(NotionApiError{Message: "not found"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		page.blocks[i].Archived = true
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	json.NewEncoder(w).Encode(page.blocks[i])
+}
+
+// visible returns the IDs of the page's blocks that aren't archived.
+func (page *fakeNotionPage) visible() []string {
+	page.mu.Lock()
+	defer page.mu.Unlock()
+
+	var ids []string
+	for _, b := range page.blocks {
+		if !b.Archived {
+			ids = append(ids, b.Id)
+		}
+	}
+	return ids
+}
+
+// archive archives blocks, as the user deleting them would.
+func (page *fakeNotionPage) archive(ids ...string) {
+	page.mu.Lock()
+	defer page.mu.Unlock()
+
+	for i := range page.blocks {
+		if slices.Contains(ids, page.blocks[i].Id) {
+			page.blocks[i].Archived = true
+		}
+	}
+}
+
+func TestReplaceNotionBlocks(t *testing.T) {
+	p := &Pipeline{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	job := models.Job{ID: "job"}
+	previous := []string{"old-1", "old-2", "old-3"}
+	children := []Children{{Object: "block"}, {Object: "block"}}
+
+	t.Run("keeps the user's blocks where they were", func(t *testing.T) {
+		page := newFakeNotionPage(t, "mine-top", "old-1", "old-2", "mine-middle", "old-3", "mine-bottom")
+
+		ids, err := p.replaceNotionBlocks(job, "page", previous, children, "secret_notion_token")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(ids) != 2 {
+			t.Fatalf("got new block IDs %v; want the 2 added", ids)
+		}
+
+		want := []string{"mine-top", ids[0], ids[1], "mine-middle", "mine-bottom"}
+		if got := page.visible(); !slices.Equal(got, want) {
+			t.Errorf("got blocks %v; want %v", got, want)
+		}
+	})
+
+	t.Run("starts from the first previous block still there", func(t *testing.T) {
+		page := newFakeNotionPage(t, "mine-top", "old-1", "old-2", "mine-middle", "old-3")
+		page.archive("old-1")
+
+		ids, err := p.replaceNotionBlocks(job, "page", previous, children, "secret_notion_token")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{"mine-top", ids[0], ids[1], "mine-middle"}
+		if got := page.visible(); !slices.Equal(got, want) {
+			t.Errorf("got blocks %v; want %v", got, want)
+		}
+	})
+
+	t.Run("appends when the previous blocks are gone", func(t *testing.T) {
+		page := newFakeNotionPage(t, "mine-top", "mine-bottom")
+
+		ids, err := p.replaceNotionBlocks(job, "page", previous, children, "secret_notion_token")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{"mine-top", "mine-bottom", ids[0], ids[1]}
+		if got := page.visible(); !slices.Equal(got, want) {
+			t.Errorf("got blocks %v; want %v", got, want)
+		}
+	})
+
+	t.Run("leaves the page as it was if adding fails", func(t *testing.T) {
+		page := newFakeNotionPage(t, "mine-top", "old-1", "old-2", "mine-middle", "old-3")
+		page.failAfter = 1
+
+		many := make([]Children, maxNotionChildren+1)
+		for i := range many {
+			many[i] = Children{Object: "block"}
+		}
+
+		_, err := p.replaceNotionBlocks(job, "page", previous, many, "secret_notion_token")
+		if err == nil {
+			t.Fatal("got no error; want the failed append's")
+		}
+
+		want := []string{"mine-top", "old-1", "old-2", "mine-middle", "old-3"}
+		if got := page.visible(); !slices.Equal(got, want) {
+			t.Errorf("got blocks %v; want %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,527 @@
+package pipeline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+)
+
+// MaxUploadSize is the largest audio file the transcription API accepts.
+const MaxUploadSize = 25 * 1024 * 1024
+
+var (
+	ErrInvalidAudioFile = errors.New("unsupported audio file type")
+	ErrFileTooLarge     = fmt.Errorf("audio file exceeds the %d MB limit", MaxUploadSize/1024/1024)
+	ErrUnknownLanguage  = errors.New("unsupported language")
+)
+
+// ReadAudio reads an uploaded audio file, working out the hex SHA-256 of
+// its content as it goes. It returns ErrFileTooLarge rather than reading
+// more than MaxUploadSize.
+func ReadAudio(r io.Reader) ([]byte, string, error) {
+	hash := sha256.New()
+
+	audio, err := io.ReadAll(io.TeeReader(io.LimitReader(r, MaxUploadSize+1), hash))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(audio) > MaxUploadSize {
+		return nil, "", ErrFileTooLarge
+	}
+
+	return audio, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Pipeline transcribes stored audio with Whisper, formats and summarizes it
+// and publishes the result to Notion, recording progress on the job.
+type Pipeline struct {
+	Logger     *slog.Logger
+	MockOpenAI bool
+	Jobs       *models.JobModel
+	Storage    Storage
+
+	// Glossary, if set, supplies each user's custom vocabulary, which is
+	// sent to Whisper as a prompt and used to correct the transcript.
+	Glossary *models.GlossaryModel
+
+	// FFmpeg is the path to the ffmpeg binary, used to convert audio in
+	// formats Whisper doesn't read. If it is empty those formats are
+	// rejected.
+	FFmpeg string
+
+	// TranscriptCache, if set, keeps transcripts so that processing the
+	// same audio again doesn't pay for it to be transcribed again.
+	TranscriptCache *models.TranscriptCacheModel
+
+	// Diarizer, if set, labels who said what for jobs that ask for it.
+	Diarizer Diarizer
+
+	// AudioLinkExpiry is how long links to audio attached to Notion pages
+	// work, when the audio is linked to rather than uploaded to Notion.
+	AudioLinkExpiry time.Duration
+
+	// SummaryConcurrency limits how many chunks of a long transcript are
+	// summarized at once. Zero uses a small default.
+	SummaryConcurrency int
+
+	// Prices is used to work out what each job costs. The zero value uses
+	// DefaultPrices.
+	Prices Prices
+
+	// Admit, if set, is called before a job starts transcribing. It may
+	// block until the job is allowed to start, and an error fails the job.
+	Admit func(job models.Job) error
+
+	// OnStatusChange, if set, is called whenever a job moves to a new status.
+	OnStatusChange func(job models.Job)
+
+	// OnProgress, if set, is called as each unit of work within the current
+	// stage finishes, with the number done out of the stage's total.
+	OnProgress func(job models.Job, done int, total int)
+}
+
+// Options are the per-job choices made when audio is submitted.
+type Options struct {
+	// Review pauses the job after summarizing so the transcript and
+	// summary can be corrected before anything is published.
+	Review bool
+
+	// Language is the ISO-639-1 code of the spoken language, or empty for
+	// Whisper to detect it.
+	Language string
+
+	// Translate produces an English transcript whatever the spoken
+	// language, using Whisper's translation endpoint.
+	Translate bool
+
+	// SummaryLanguage is the ISO-639-1 code of the language to write the
+	// summary in, or empty to use the language of the transcript.
+	SummaryLanguage string
+
+	// Preprocess trims silence from the start and end of the recording and
+	// evens out its loudness before it is transcribed. CompressSilence
+	// also shortens long pauses within it.
+	Preprocess      bool
+	CompressSilence bool
+
+	// Diarize labels each part of the transcript with who said it.
+	Diarize bool
+
+	// AttachAudio embeds the original audio in the Notion page.
+	AttachAudio bool
+
+	// Template decides what the summary contains and how it is laid out
+	// in Notion. The zero value uses the default built-in template.
+	Template models.SummaryTemplate
+
+	// AudioHash is the audio's hash from ReadAudio, if it was read that
+	// way. Otherwise CreateJob works it out.
+	AudioHash string
+}
+
+// CreateJob validates and stores the audio and records a queued job for it.
+// The job isn't processed until it is passed to Process.
+func (p *Pipeline) CreateJob(user models.User, notionDatabaseId string, filename string, audio []byte, opts Options) (models.Job, error) {
+	if len(audio) > MaxUploadSize {
+		return models.Job{}, ErrFileTooLarge
+	}
+
+	for _, code := range []string{opts.Language, opts.SummaryLanguage} {
+		if _, ok := LanguageName(code); code != "" && !ok {
+			return models.Job{}, fmt.Errorf("%w: %q", ErrUnknownLanguage, code)
+		}
+	}
+
+	format, ok := detectAudioFormat(audio)
+	if !ok {
+		return models.Job{}, ErrInvalidAudioFile
+	}
+	if !format.Whisper && p.FFmpeg == "" {
+		return models.Job{}, fmt.Errorf("%w: converting %s files isn't enabled", ErrInvalidAudioFile, format.Extension)
+	}
+	if (opts.Preprocess || opts.CompressSilence) && p.FFmpeg == "" {
+		return models.Job{}, ErrPreprocessingUnavailable
+	}
+	if opts.Diarize && p.Diarizer == nil {
+		return models.Job{}, ErrDiarizationUnavailable
+	}
+
+	template := opts.Template
+	if template.Key == "" && template.ID == 0 {
+		template, _ = BuiltinTemplate(DefaultTemplateKey)
+	}
+
+	templateJSON, err := json.Marshal(template)
+	if err != nil {
+		return models.Job{}, err
+	}
+
+	audioHash := opts.AudioHash
+	if audioHash == "" {
+		sum := sha256.Sum256(audio)
+		audioHash = hex.EncodeToString(sum[:])
+	}
+
+	savedPath, err := p.Storage.Write(audio, filename, format.ContentType)
+	if err != nil {
+		return models.Job{}, err
+	}
+
+	return p.Jobs.Insert(models.Job{
+		UserID:           user.ID,
+		NotionDatabaseID: notionDatabaseId,
+		Filename:         filename,
+		StoragePath:      savedPath,
+		ContentType:      format.ContentType,
+		Review:           opts.Review,
+		Language:         opts.Language,
+		Translate:        opts.Translate,
+		SummaryLanguage:  opts.SummaryLanguage,
+		Template:         string(templateJSON),
+		Preprocess:       opts.Preprocess || opts.CompressSilence,
+		CompressSilence:  opts.CompressSilence,
+		Diarize:          opts.Diarize,
+		AttachAudio:      opts.AttachAudio,
+		AudioHash:        audioHash,
+	})
+}
+
+func (p *Pipeline) setStatus(job *models.Job, status string) error {
+	err := p.Jobs.SetStatus(job.ID, status)
+	if err != nil {
+		return err
+	}
+
+	job.Status = status
+	if p.OnStatusChange != nil {
+		p.OnStatusChange(*job)
+	}
+
+	return nil
+}
+
+func (p *Pipeline) progress(job models.Job, done int, total int) {
+	if p.OnProgress != nil {
+		p.OnProgress(job, done, total)
+	}
+}
+
+// fail records err on the job and reports the failure. It returns the
+// updated job and err so callers can return it directly.
+func (p *Pipeline) fail(job models.Job, err error) (models.Job, error) {
+	p.Logger.Error(err.Error(), "job", job.ID)
+
+	failErr := p.Jobs.Fail(job.ID, err.Error())
+	if failErr != nil {
+		p.Logger.Error(failErr.Error(), "job", job.ID)
+	}
+
+	job.Status = models.JobFailed
+	job.Error = err.Error()
+	if p.OnStatusChange != nil {
+		p.OnStatusChange(job)
+	}
+
+	return job, err
+}
+
+// Process runs the job through to a Notion page, or to JobReview if the job
+// asked to be reviewed first. Failures are recorded on the job as well as
+// returned, so callers running it in the background can ignore the error.
+func (p *Pipeline) Process(job models.Job, notionAccessToken string) (models.Job, error) {
+	if p.Admit != nil {
+		err := p.Admit(job)
+		if err != nil {
+			return p.fail(job, err)
+		}
+	}
+
+	err := p.setStatus(&job, models.JobTranscribing)
+	if err != nil {
+		return p.fail(job, err)
+	}
+
+	glossary, err := p.glossaryFor(job)
+	if err != nil {
+		return p.fail(job, err)
+	}
+
+	audio, filename, err := p.prepareAudio(job)
+	if err != nil {
+		return p.fail(job, err)
+	}
+
+	transcription, err := p.sendTranscriptionToWhisper(job, audio, filename, whisperPrompt(glossary))
+	if err != nil {
+		return p.fail(job, err)
+	}
+	if !transcription.Cached {
+		err = p.recordTranscriptionUsage(job, transcription.Duration)
+		if err != nil {
+			return p.fail(job, err)
+		}
+	}
+
+	transcribedText := applyGlossary(transcription.Text, glossary)
+	p.Logger.Debug("Whisper transcription completed", "job", job.ID, "language", transcription.Language)
+	p.progress(job, 1, 1)
+
+	// A translation reports English rather than what was spoken, so only a
+	// transcription tells us anything about the recording.
+	if !job.Translate {
+		job.DetectedLanguage = languageCode(transcription.Language)
+
+		err = p.Jobs.SetDetectedLanguage(job.ID, job.DetectedLanguage)
+		if err != nil {
+			return p.fail(job, err)
+		}
+	}
+
+	segments := transcription.Segments
+	for i := range segments {
+		segments[i].Text = applyGlossary(segments[i].Text, glossary)
+	}
+
+	if len(segments) > 0 {
+		b, err := json.Marshal(segments)
+		if err != nil {
+			return p.fail(job, err)
+		}
+
+		err = p.Jobs.SetSegments(job.ID, string(b))
+		if err != nil {
+			return p.fail(job, err)
+		}
+		job.Segments = string(b)
+	}
+
+	if job.Diarize {
+		if len(segments) == 0 {
+			segments = []WhisperSegment{{End: transcription.Duration, Text: transcribedText}}
+		}
+
+		speakers, err := p.diarize(audio, filename, segments)
+		if err != nil {
+			return p.fail(job, err)
+		}
+
+		b, err := json.Marshal(speakers)
+		if err != nil {
+			return p.fail(job, err)
+		}
+
+		err = p.Jobs.SetSpeakers(job.ID, string(b))
+		if err != nil {
+			return p.fail(job, err)
+		}
+		job.Speakers = string(b)
+
+		transcribedText = speakers.Transcript()
+	}
+
+	err = p.Jobs.SetTranscript(job.ID, transcribedText)
+	if err != nil {
+		return p.fail(job, err)
+	}
+	job.Transcript = transcribedText
+
+	job, err = p.summarize(job)
+	if err != nil {
+		return p.fail(job, err)
+	}
+
+	if job.Review {
+		err = p.setStatus(&job, models.JobReview)
+		if err != nil {
+			return p.fail(job, err)
+		}
+		return job, nil
+	}
+
+	return p.Publish(job, notionAccessToken)
+}
+
+// prepareAudio reads the job's audio from storage and preprocesses it if
+// the job asks for that, or converts it if Whisper can't read it as it is.
+// The filename returned has the extension of the audio's actual format,
+// which Whisper relies on to decode it.
+func (p *Pipeline) prepareAudio(job models.Job) ([]byte, string, error) {
+	audio, err := p.Storage.Read(job.StoragePath)
+	if err != nil {
+		return nil, "", err
+	}
+
+	format, ok := detectAudioFormat(audio)
+	if !ok {
+		return nil, "", ErrInvalidAudioFile
+	}
+
+	switch {
+	case job.Preprocess:
+		result, err := p.preprocess(audio, format, job.CompressSilence)
+		if err != nil {
+			return nil, "", err
+		}
+		p.Logger.Debug("Preprocessed audio", "job", job.ID, "original", result.originalSeconds, "processed", result.processedSeconds)
+
+		err = p.Jobs.SetDurations(job.ID, result.originalSeconds, result.processedSeconds)
+		if err != nil {
+			return nil, "", err
+		}
+		audio, format = result.audio, formatMP3
+
+	case !format.Whisper:
+		p.Logger.Debug("Converting audio", "job", job.ID, "format", format.Extension)
+
+		audio, err = p.transcode(audio, format)
+		if err != nil {
+			return nil, "", err
+		}
+		format = formatMP3
+	}
+
+	filename := strings.TrimSuffix(job.Filename, filepath.Ext(job.Filename)) + "." + format.Extension
+
+	return audio, filename, nil
+}
+
+// summarize formats and summarizes the job's transcript and saves the result
+// as the job's summary.
+func (p *Pipeline) summarize(job models.Job) (models.Job, error) {
+	err := p.setStatus(&job, models.JobSummarizing)
+	if err != nil {
+		return job, err
+	}
+
+	template, err := JobTemplate(job)
+	if err != nil {
+		return job, err
+	}
+
+	summaryLanguage, _ := LanguageName(job.SummaryLanguage)
+
+	result, err := p.summarizeTranscript(job, summaryLanguage, template)
+	if err != nil {
+		return job, err
+	}
+	p.Logger.Debug("Summary completed", "job", job.ID)
+
+	summary, err := json.Marshal(result)
+	if err != nil {
+		return job, err
+	}
+
+	err = p.Jobs.SetSummary(job.ID, string(summary))
+	if err != nil {
+		return job, err
+	}
+	job.Summary = string(summary)
+
+	return job, nil
+}
+
+// Resummarize regenerates the summary of a job in review from its current,
+// possibly edited, transcript. A failed attempt leaves the job in review
+// with its previous summary and the reason in its Error, so the user's
+// edits aren't lost.
+func (p *Pipeline) Resummarize(job models.Job) (models.Job, error) {
+	job, err := p.summarize(job)
+
+	job.Error = ""
+	if err != nil {
+		job.Error = err.Error()
+	}
+
+	setErr := p.Jobs.SetError(job.ID, job.Error)
+	if setErr != nil {
+		return job, setErr
+	}
+
+	statusErr := p.setStatus(&job, models.JobReview)
+	if err == nil {
+		err = statusErr
+	}
+
+	return job, err
+}
+
+// Publish creates the Notion page from the job's saved summary, or updates
+// the page a previous run of the job created. It is the last step of
+// Process, and is called directly to approve a reviewed job.
+func (p *Pipeline) Publish(job models.Job, notionAccessToken string) (models.Job, error) {
+	var result ResponseSchemaForNotion
+
+	err := json.Unmarshal([]byte(job.Summary), &result)
+	if err != nil {
+		return p.fail(job, err)
+	}
+
+	template, err := JobTemplate(job)
+	if err != nil {
+		return p.fail(job, err)
+	}
+
+	speakers, err := JobSpeakers(job)
+	if err != nil {
+		return p.fail(job, err)
+	}
+
+	segments, err := JobSegments(job)
+	if err != nil {
+		return p.fail(job, err)
+	}
+
+	err = p.setStatus(&job, models.JobPublishing)
+	if err != nil {
+		return p.fail(job, err)
+	}
+
+	children := mapChatResponseToNotionPage(result, template, speakers, segments)
+
+	// A page without its audio is still worth having, so failing to attach
+	// it doesn't fail the job.
+	if job.AttachAudio {
+		audio, err := p.createAudioElement(job, notionAccessToken)
+		if err != nil {
+			p.Logger.Warn("Couldn't attach the audio to the Notion page", "job", job.ID, "error", err.Error())
+		} else {
+			children = append([]Children{audio}, children...)
+		}
+	}
+
+	page, blockIDs, err := p.publishNotionPage(job, children, notionAccessToken)
+	if err != nil {
+		return p.fail(job, err)
+	}
+
+	b, err := json.Marshal(blockIDs)
+	if err != nil {
+		return p.fail(job, err)
+	}
+
+	err = p.Jobs.Complete(job.ID, page.Id, page.Url, string(b))
+	if err != nil {
+		return p.fail(job, err)
+	}
+	job.NotionPageID = page.Id
+	job.NotionPageURL = page.Url
+	job.NotionBlockIDs = string(b)
+	p.Logger.Debug("Notion page published", "job", job.ID)
+
+	job.Status = models.JobCompleted
+	if p.OnStatusChange != nil {
+		p.OnStatusChange(job)
+	}
+
+	return job, nil
+}
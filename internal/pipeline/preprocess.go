@@ -0,0 +1,213 @@
+package pipeline
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// silenceThreshold is the level below which audio counts as silence.
+	silenceThreshold = "-50dB"
+
+	// minSilence is the shortest quiet stretch trimmed from either end.
+	minSilence = 0.5
+
+	// longSilence is how long a pause within the recording has to be to be
+	// shortened when compressing silence, and keptSilence is how much of
+	// it is left.
+	longSilence = 2.0
+	keptSilence = 1.0
+)
+
+var (
+	ErrPreprocessingUnavailable = errors.New("preprocessing audio needs ffmpeg, which isn't configured")
+	ErrSilentAudio              = errors.New("the recording is silent")
+)
+
+var (
+	durationPattern     = regexp.MustCompile(`Duration: (\d+):(\d+):(\d+(?:\.\d+)?)`)
+	timePattern         = regexp.MustCompile(`time=(\d+):(\d+):(\d+(?:\.\d+)?)`)
+	silenceStartPattern = regexp.MustCompile(`silence_start: (-?\d+(?:\.\d+)?)`)
+	silenceEndPattern   = regexp.MustCompile(`silence_end: (-?\d+(?:\.\d+)?)`)
+)
+
+// silence is a quiet stretch of audio, in seconds from the start. An end of
+// -1 means it runs to the end of the recording.
+type silence struct {
+	start float64
+	end   float64
+}
+
+// preprocessed is audio after preprocessing, with its length before and
+// after.
+type preprocessed struct {
+	audio            []byte
+	originalSeconds  float64
+	processedSeconds float64
+}
+
+// preprocess trims silence from the start and end of a recording, evens out
+// its loudness and downmixes it to 16 kHz mono MP3, and if compressSilence
+// is set shortens long pauses too. Less audio means a smaller Whisper bill,
+// and level, mono speech transcribes better.
+func (p *Pipeline) preprocess(audio []byte, format AudioFormat, compressSilence bool) (preprocessed, error) {
+	if p.FFmpeg == "" {
+		return preprocessed{}, ErrPreprocessingUnavailable
+	}
+
+	dir, err := os.MkdirTemp("", "preprocess-")
+	if err != nil {
+		return preprocessed{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "input."+format.Extension)
+	output := filepath.Join(dir, "output.mp3")
+
+	err = os.WriteFile(input, audio, 0600)
+	if err != nil {
+		return preprocessed{}, err
+	}
+
+	// The first pass only measures the recording and finds its silences.
+	analysis, err := p.ffmpeg("-i", input, "-vn",
+		"-af", fmt.Sprintf("silencedetect=noise=%s:d=%g", silenceThreshold, minSilence), "-f", "null", "-")
+	if err != nil {
+		return preprocessed{}, fmt.Errorf("preprocessing audio: %w", err)
+	}
+
+	duration, silences := parseSilenceDetect(analysis)
+
+	start, end := speechBounds(duration, silences)
+
+	trim := fmt.Sprintf("atrim=start=%.3f", start)
+	if duration > 0 {
+		if end <= start {
+			return preprocessed{}, ErrSilentAudio
+		}
+		trim += fmt.Sprintf(":end=%.3f", end)
+	}
+
+	filters := []string{trim, "asetpts=PTS-STARTPTS"}
+	if compressSilence {
+		filters = append(filters, fmt.Sprintf("silenceremove=stop_periods=-1:stop_duration=%g:stop_threshold=%s:stop_silence=%g",
+			longSilence, silenceThreshold, keptSilence))
+	}
+	filters = append(filters, "loudnorm=I=-16:TP=-1.5:LRA=11")
+
+	encoding, err := p.ffmpeg("-i", input, "-vn", "-af", strings.Join(filters, ","),
+		"-ac", "1", "-ar", "16000", "-c:a", "libmp3lame", "-b:a", "32k", output)
+	if err != nil {
+		return preprocessed{}, fmt.Errorf("preprocessing audio: %w", err)
+	}
+
+	result := preprocessed{
+		originalSeconds:  duration,
+		processedSeconds: lastMatchSeconds(timePattern, encoding),
+	}
+
+	result.audio, err = os.ReadFile(output)
+	if err != nil {
+		return preprocessed{}, err
+	}
+
+	return result, nil
+}
+
+// ffmpeg runs ffmpeg with args and returns what it wrote to stderr, where
+// it reports durations, progress and filter output.
+func (p *Pipeline) ffmpeg(args ...string) (string, error) {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command(p.FFmpeg, append([]string{"-hide_banner", "-nostdin", "-y"}, args...)...)
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("ffmpeg: %w: %s", err, lastLine(stderr.String()))
+	}
+
+	return stderr.String(), nil
+}
+
+// parseSilenceDetect reads the recording's duration and the silences found
+// by ffmpeg's silencedetect filter from its log.
+func parseSilenceDetect(log string) (float64, []silence) {
+	duration := lastMatchSeconds(durationPattern, log)
+
+	var silences []silence
+
+	scanner := bufio.NewScanner(strings.NewReader(log))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if m := silenceStartPattern.FindStringSubmatch(line); m != nil {
+			start, _ := strconv.ParseFloat(m[1], 64)
+			silences = append(silences, silence{start: max(start, 0), end: -1})
+		}
+
+		if m := silenceEndPattern.FindStringSubmatch(line); m != nil && len(silences) > 0 {
+			silences[len(silences)-1].end, _ = strconv.ParseFloat(m[1], 64)
+		}
+	}
+
+	return duration, silences
+}
+
+// speechBounds returns where the speech in a recording starts and ends,
+// skipping any silence at either end. If the duration isn't known only the
+// start is meaningful.
+func speechBounds(duration float64, silences []silence) (float64, float64) {
+	start, end := 0.0, duration
+
+	if len(silences) == 0 {
+		return start, end
+	}
+
+	first := silences[0]
+	if first.start <= 0.05 {
+		if first.end < 0 {
+			return 0, 0
+		}
+		start = first.end
+	}
+
+	last := silences[len(silences)-1]
+	if duration > 0 && (last.end < 0 || last.end >= duration-0.05) {
+		end = last.start
+	}
+
+	return start, end
+}
+
+// lastMatchSeconds converts the last HH:MM:SS.ss timestamp matched by
+// pattern in log to seconds, or returns zero if there isn't one.
+func lastMatchSeconds(pattern *regexp.Regexp, log string) float64 {
+	matches := pattern.FindAllStringSubmatch(log, -1)
+	if len(matches) == 0 {
+		return 0
+	}
+	m := matches[len(matches)-1]
+
+	hours, _ := strconv.ParseFloat(m[1], 64)
+	minutes, _ := strconv.ParseFloat(m[2], 64)
+	seconds, _ := strconv.ParseFloat(m[3], 64)
+
+	return hours*3600 + minutes*60 + seconds
+}
+
+func lastLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
@@ -0,0 +1,90 @@
+package pipeline
+
+import (
+	"slices"
+	"testing"
+)
+
+// ffmpegLog is the stderr of a silencedetect pass over a recording with
+// silence at the start, one pause in the middle and silence running to
+// the end.
+const ffmpegLog = `Input #0, mp3, from 'input.mp3':
+  Duration: 00:01:05.50, start: 0.025057, bitrate: 128 kb/s
+  Stream #0:0: Audio: mp3, 44100 Hz, mono, fltp, 128 kb/s
+[silencedetect @ 0x5581] silence_start: -0.0250567
+[silencedetect @ 0x5581] silence_end: 2.31234 | silence_duration: 2.33739
+[silencedetect @ 0x5581] silence_start: 30.5
+[silencedetect @ 0x5581] silence_end: 33 | silence_duration: 2.5
+[silencedetect @ 0x5581] silence_start: 61.2
+size=N/A time=00:01:05.49 bitrate=N/A speed= 412x
+`
+
+func TestParseSilenceDetect(t *testing.T) {
+	tests := []struct {
+		name         string
+		log          string
+		wantDuration float64
+		wantSilences []silence
+	}{
+		{
+			name:         "silences",
+			log:          ffmpegLog,
+			wantDuration: 65.5,
+			wantSilences: []silence{{0, 2.31234}, {30.5, 33}, {61.2, -1}},
+		},
+		{
+			name:         "no silence",
+			log:          "  Duration: 01:02:03.25, start: 0.000000, bitrate: 64 kb/s\n",
+			wantDuration: 3723.25,
+		},
+		{
+			name:         "no duration",
+			log:          "[silencedetect @ 0x1] silence_start: 4\n[silencedetect @ 0x1] silence_end: 5.5 | silence_duration: 1.5\n",
+			wantSilences: []silence{{4, 5.5}},
+		},
+		{
+			name: "end without a start",
+			log:  "[silencedetect @ 0x1] silence_end: 5.5 | silence_duration: 1.5\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			duration, silences := parseSilenceDetect(tt.log)
+			if duration != tt.wantDuration {
+				t.Errorf("got duration %v; want %v", duration, tt.wantDuration)
+			}
+			if !slices.Equal(silences, tt.wantSilences) {
+				t.Errorf("got silences %v; want %v", silences, tt.wantSilences)
+			}
+		})
+	}
+}
+
+func TestSpeechBounds(t *testing.T) {
+	tests := []struct {
+		name      string
+		duration  float64
+		silences  []silence
+		wantStart float64
+		wantEnd   float64
+	}{
+		{"no silence", 60, nil, 0, 60},
+		{"silence at both ends", 65.5, []silence{{0, 2.3}, {30.5, 33}, {61.2, -1}}, 2.3, 61.2},
+		{"silence only in the middle", 60, []silence{{20, 25}}, 0, 60},
+		{"silence at the start", 60, []silence{{0.04, 3}, {20, 25}}, 3, 60},
+		{"silence ending just before the end", 60, []silence{{50, 59.97}}, 0, 50},
+		{"silence ending well before the end", 60, []silence{{50, 55}}, 0, 60},
+		{"silent throughout", 60, []silence{{0, -1}}, 0, 0},
+		{"unknown duration", 0, []silence{{0, 2}, {40, -1}}, 2, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := speechBounds(tt.duration, tt.silences)
+			if start != tt.wantStart || end != tt.wantEnd {
+				t.Errorf("speechBounds(%v, %v) = %v, %v; want %v, %v", tt.duration, tt.silences, start, end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/google/uuid"
+)
+
+// Storage holds uploaded audio until the pipeline has processed it. Write
+// returns the path that Read later accepts.
+type Storage interface {
+	Write(uploadedFileBytes []byte, originalFilename string, contentType string) (string, error)
+	Read(savedPath string) ([]byte, error)
+
+	// List returns the files directly under prefix, ignoring anything in
+	// nested folders.
+	List(prefix string) ([]StoredFile, error)
+	Move(srcPath string, dstPath string) error
+}
+
+// URLSigner is implemented by storage backends that can link to a file
+// for a limited time without credentials. Only AzureStorage does, with
+// shared access signatures; local storage has nothing to link to.
+type URLSigner interface {
+	SignedURL(savedPath string, expiry time.Duration) (string, error)
+}
+
+type StoredFile struct {
+	Path     string
+	Size     int64
+	Modified time.Time
+}
+
+func newSavedPath(originalFilename string) (string, error) {
+	uuid, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+
+	filename := uuid.String() + filepath.Ext(originalFilename)
+	return filepath.ToSlash(filepath.Join("uploads", filename)), nil
+}
+
+// AzureStorage stores uploads as blobs in an Azure Storage container.
+type AzureStorage struct {
+	client        *azblob.Client
+	containerName string
+}
+
+// NewAzureStorage connects to the storage account described by the
+// AZURE_STORAGE_* environment variables.
+func NewAzureStorage() (*AzureStorage, error) {
+	accountName, ok := os.LookupEnv("AZURE_STORAGE_ACCOUNT_NAME")
+	if !ok {
+		return nil, errors.New("AZURE_STORAGE_ACCOUNT_NAME could not be found")
+	}
+
+	accountKey, ok := os.LookupEnv("AZURE_STORAGE_PRIMARY_ACCOUNT_KEY")
+	if !ok {
+		return nil, errors.New("AZURE_STORAGE_PRIMARY_ACCOUNT_KEY could not be found")
+	}
+
+	containerName, ok := os.LookupEnv("AZURE_STORAGE_CONTAINER_NAME")
+	if !ok {
+		return nil, errors.New("AZURE_STORAGE_CONTAINER_NAME could not be found")
+	}
+
+	cred, err := azblob.NewSharedKeyCredential(accountName, accountKey)
+	if err != nil {
+		return nil, err
+	}
+
+	storageAccountUrl := fmt.Sprintf("https://%s.blob.core.windows.net/", accountName)
+
+	client, err := azblob.NewClientWithSharedKeyCredential(storageAccountUrl, cred, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AzureStorage{client: client, containerName: containerName}, nil
+}
+
+func (s *AzureStorage) Write(uploadedFileBytes []byte, originalFilename string, contentType string) (string, error) {
+	savedPath, err := newSavedPath(originalFilename)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = s.client.UploadBuffer(context.TODO(), s.containerName, savedPath, uploadedFileBytes, &azblob.UploadBufferOptions{
+		HTTPHeaders: &blob.HTTPHeaders{
+			BlobContentType: &contentType,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return savedPath, nil
+}
+
+func (s *AzureStorage) Read(savedPath string) ([]byte, error) {
+	downloadedData := bytes.Buffer{}
+
+	get, err := s.client.DownloadStream(context.TODO(), s.containerName, savedPath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	retryReader := get.NewRetryReader(context.TODO(), &azblob.RetryReaderOptions{})
+	_, err = downloadedData.ReadFrom(retryReader)
+	if err != nil {
+		return nil, err
+	}
+
+	err = retryReader.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return downloadedData.Bytes(), nil
+}
+
+func (s *AzureStorage) List(prefix string) ([]StoredFile, error) {
+	var files []StoredFile
+
+	pager := s.client.NewListBlobsFlatPager(s.containerName, &azblob.ListBlobsFlatOptions{
+		Prefix: &prefix,
+	})
+
+	for pager.More() {
+		page, err := pager.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range page.Segment.BlobItems {
+			if item.Name == nil || strings.Contains(strings.TrimPrefix(*item.Name, prefix), "/") {
+				continue
+			}
+
+			file := StoredFile{Path: *item.Name}
+			if item.Properties != nil {
+				if item.Properties.ContentLength != nil {
+					file.Size = *item.Properties.ContentLength
+				}
+				if item.Properties.LastModified != nil {
+					file.Modified = *item.Properties.LastModified
+				}
+			}
+			files = append(files, file)
+		}
+	}
+
+	return files, nil
+}
+
+// Move copies the blob to its new name and deletes the original. Blob
+// storage has no rename, so a failure between the two leaves both copies.
+func (s *AzureStorage) Move(srcPath string, dstPath string) error {
+	b, err := s.Read(srcPath)
+	if err != nil {
+		return err
+	}
+
+	get, err := s.client.ServiceClient().NewContainerClient(s.containerName).NewBlobClient(srcPath).GetProperties(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.UploadBuffer(context.TODO(), s.containerName, dstPath, b, &azblob.UploadBufferOptions{
+		HTTPHeaders: &blob.HTTPHeaders{
+			BlobContentType: get.ContentType,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.DeleteBlob(context.TODO(), s.containerName, srcPath, nil)
+	return err
+}
+
+// SignedURL returns a shared access signature URL that can read the blob
+// until expiry has passed.
+func (s *AzureStorage) SignedURL(savedPath string, expiry time.Duration) (string, error) {
+	blobClient := s.client.ServiceClient().NewContainerClient(s.containerName).NewBlobClient(savedPath)
+
+	return blobClient.GetSASURL(sas.BlobPermissions{Read: true}, time.Now().Add(expiry), nil)
+}
+
+// LocalStorage stores uploads on disk under Dir. It is meant for local
+// development and the in-process CLI.
+type LocalStorage struct {
+	Dir string
+}
+
+func (s *LocalStorage) Write(uploadedFileBytes []byte, originalFilename string, contentType string) (string, error) {
+	savedPath, err := newSavedPath(originalFilename)
+	if err != nil {
+		return "", err
+	}
+
+	fullPath := filepath.Join(s.Dir, filepath.FromSlash(savedPath))
+
+	err = os.MkdirAll(filepath.Dir(fullPath), 0755)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.WriteFile(fullPath, uploadedFileBytes, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	return savedPath, nil
+}
+
+func (s *LocalStorage) Read(savedPath string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.Dir, filepath.FromSlash(savedPath)))
+}
+
+func (s *LocalStorage) List(prefix string) ([]StoredFile, error) {
+	dir, namePrefix := path.Split(prefix)
+
+	entries, err := os.ReadDir(filepath.Join(s.Dir, filepath.FromSlash(dir)))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var files []StoredFile
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !strings.HasPrefix(entry.Name(), namePrefix) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, StoredFile{
+			Path:     dir + entry.Name(),
+			Size:     info.Size(),
+			Modified: info.ModTime(),
+		})
+	}
+
+	return files, nil
+}
+
+func (s *LocalStorage) Move(srcPath string, dstPath string) error {
+	dst := filepath.Join(s.Dir, filepath.FromSlash(dstPath))
+
+	err := os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(filepath.Join(s.Dir, filepath.FromSlash(srcPath)), dst)
+}
@@ -0,0 +1,136 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+)
+
+// maxRepairAttempts is how many times the model is asked to fix a reply
+// that doesn't match the schema before the job fails.
+const maxRepairAttempts = 2
+
+var (
+	ErrSummaryRefused   = errors.New("the model refused to summarize the transcript")
+	ErrSummaryFiltered  = errors.New("the model's reply was blocked by OpenAI's content filter")
+	ErrSummaryTruncated = errors.New("the model's reply was cut off before it finished")
+)
+
+// completeStructured asks the chat model for a reply matching schema and
+// checks that it does. A reply that doesn't is sent back with the problem
+// and a request to fix it, up to maxRepairAttempts times. Refusals, filtered
+// replies and replies cut off at the length limit aren't retried, as asking
+// again won't change them. The tokens used by every attempt are recorded
+// against the job.
+func (p *Pipeline) completeStructured(job models.Job, systemPrompt string, userContent string, schema Schema) (ResponseSchemaForNotion, error) {
+	messages := []ChatMessage{
+		{Role: "system", Content: systemPrompt},
+		{Role: "user", Content: userContent},
+	}
+
+	var lastErr error
+
+	for attempt := 0; attempt <= maxRepairAttempts; attempt++ {
+		chatResponse, err := p.createChatCompletion(messages, schema)
+		if err != nil {
+			return ResponseSchemaForNotion{}, err
+		}
+
+		err = p.recordChatUsage(job, chatResponse.Usage)
+		if err != nil {
+			return ResponseSchemaForNotion{}, err
+		}
+
+		if len(chatResponse.Choices) == 0 {
+			return ResponseSchemaForNotion{}, errors.New("the model returned no reply")
+		}
+		choice := chatResponse.Choices[0]
+
+		switch {
+		case choice.Message.Refusal != "":
+			return ResponseSchemaForNotion{}, fmt.Errorf("%w: %s", ErrSummaryRefused, choice.Message.Refusal)
+		case choice.FinishReason == "content_filter":
+			return ResponseSchemaForNotion{}, ErrSummaryFiltered
+		case choice.FinishReason == "length":
+			return ResponseSchemaForNotion{}, ErrSummaryTruncated
+		}
+
+		result, err := decodeStructured(choice.Message.Content, schema)
+		if err == nil {
+			return result, nil
+		}
+		lastErr = err
+
+		p.Logger.Warn("summary didn't match the schema", "attempt", attempt+1, "error", err.Error())
+
+		messages = append(messages[:2],
+			ChatMessage{Role: "assistant", Content: choice.Message.Content},
+			ChatMessage{Role: "user", Content: "That reply is invalid: " + err.Error() +
+				". Reply again with only a JSON object that matches the response schema exactly."},
+		)
+	}
+
+	return ResponseSchemaForNotion{}, fmt.Errorf("the summary was still invalid after %d attempts: %w", maxRepairAttempts+1, lastErr)
+}
+
+// decodeStructured parses content and checks it against schema: every
+// property must be present with the right type and there must be no others.
+func decodeStructured(content string, schema Schema) (ResponseSchemaForNotion, error) {
+	var fields map[string]json.RawMessage
+
+	err := json.Unmarshal([]byte(content), &fields)
+	if err != nil {
+		return ResponseSchemaForNotion{}, fmt.Errorf("it isn't a JSON object (%v)", err)
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(schema.Properties)) {
+		raw, ok := fields[key]
+		if !ok {
+			return ResponseSchemaForNotion{}, fmt.Errorf("the %q field is missing", key)
+		}
+
+		switch schema.Properties[key].Type {
+		case "string":
+			var s string
+			if json.Unmarshal(raw, &s) != nil {
+				return ResponseSchemaForNotion{}, fmt.Errorf("the %q field must be a string", key)
+			}
+		case "array":
+			if items := schema.Properties[key].Items; items != nil && items.Type == "object" {
+				var chapters []Chapter
+				if json.Unmarshal(raw, &chapters) != nil {
+					return ResponseSchemaForNotion{}, fmt.Errorf("the %q field must be an array of chapters", key)
+				}
+				err = validateChapters(chapters)
+				if err != nil {
+					return ResponseSchemaForNotion{}, err
+				}
+				continue
+			}
+
+			var list []string
+			if json.Unmarshal(raw, &list) != nil {
+				return ResponseSchemaForNotion{}, fmt.Errorf("the %q field must be an array of strings", key)
+			}
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(fields)) {
+		if _, ok := schema.Properties[key]; !ok {
+			return ResponseSchemaForNotion{}, fmt.Errorf("the %q field isn't in the schema", key)
+		}
+	}
+
+	var result ResponseSchemaForNotion
+
+	err = json.Unmarshal([]byte(content), &result)
+	if err != nil {
+		return ResponseSchemaForNotion{}, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,289 @@
+package pipeline
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestDecodeStructured(t *testing.T) {
+	template := models.SummaryTemplate{
+		Fields: []models.TemplateField{{Key: "action_items", Heading: "Action items"}},
+	}
+	schema := summarySchema(template, true, true)
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "valid",
+			content: `{"summary": "s", "logical_paragraphs": "p", "action_items": ["a"], "chapters": [{"title": "Intro", "start": "0:00", "summary": "c"}]}`,
+		},
+		{
+			name:    "not JSON",
+			content: `Here is the summary: {"summary": "s"}`,
+			wantErr: "it isn't a JSON object",
+		},
+		{
+			name:    "missing field",
+			content: `{"summary": "s", "logical_paragraphs": "p", "chapters": []}`,
+			wantErr: `the "action_items" field is missing`,
+		},
+		{
+			name:    "string instead of array",
+			content: `{"summary": "s", "logical_paragraphs": "p", "action_items": "a", "chapters": []}`,
+			wantErr: `the "action_items" field must be an array of strings`,
+		},
+		{
+			name:    "array instead of string",
+			content: `{"summary": ["s"], "logical_paragraphs": "p", "action_items": [], "chapters": []}`,
+			wantErr: `the "summary" field must be a string`,
+		},
+		{
+			name:    "extra field",
+			content: `{"summary": "s", "logical_paragraphs": "p", "action_items": [], "chapters": [], "notes": "n"}`,
+			wantErr: `the "notes" field isn't in the schema`,
+		},
+		{
+			name:    "chapters of the wrong type",
+			content: `{"summary": "s", "logical_paragraphs": "p", "action_items": [], "chapters": ["Intro"]}`,
+			wantErr: `the "chapters" field must be an array of chapters`,
+		},
+		{
+			name:    "chapter without a title",
+			content: `{"summary": "s", "logical_paragraphs": "p", "action_items": [], "chapters": [{"title": " ", "start": "0:00", "summary": "c"}]}`,
+			wantErr: "chapter 1 has no title",
+		},
+		{
+			name:    "chapter with a bad start",
+			content: `{"summary": "s", "logical_paragraphs": "p", "action_items": [], "chapters": [{"title": "Intro", "start": "soon", "summary": "c"}]}`,
+			wantErr: "chapter 1's start",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := decodeStructured(tt.content, schema)
+
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if result.Summary != "s" || result.LogicalParagraphs != "p" || len(result.Chapters) != 1 {
+					t.Errorf("got %+v", result)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v; want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// chatReply is one canned reply from the fake chat completions endpoint.
+type chatReply struct {
+	content      string
+	refusal      string
+	finishReason string
+}
+
+// fakeOpenAI sends OpenAI requests to a test server that answers chat
+// completions with replies in turn, and returns the requests it received.
+func fakeOpenAI(t *testing.T, replies ...chatReply) *[]ChatCompletion {
+	t.Helper()
+
+	var requests []ChatCompletion
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request ChatCompletion
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		requests = append(requests, request)
+
+		if len(requests) > len(replies) {
+			t.Errorf("unexpected request %d", len(requests))
+			http.Error(w, "no more replies", http.StatusInternalServerError)
+			return
+		}
+		reply := replies[len(requests)-1]
+
+		finishReason := reply.finishReason
+		if finishReason == "" {
+			finishReason = "stop"
+		}
+
+		json.NewEncoder(w).Encode(map[string]any{
+			"choices": []map[string]any{{
+				"message":       map[string]any{"role": "assistant", "content": reply.content, "refusal": reply.refusal},
+				"finish_reason": finishReason,
+			}},
+			"usage": map[string]any{"prompt_tokens": 10, "completion_tokens": 5},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	target, _ := url.Parse(server.URL)
+
+	transport := http.DefaultTransport
+	http.DefaultTransport = roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		r.URL.Scheme = target.Scheme
+		r.URL.Host = target.Host
+		return transport.RoundTrip(r)
+	})
+	t.Cleanup(func() { http.DefaultTransport = transport })
+
+	return &requests
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// newTestPipeline returns a Pipeline backed by an in-memory database,
+// with a job to record usage against.
+func newTestPipeline(t *testing.T) (*Pipeline, models.Job) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", "file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	err = models.Migrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobs := &models.JobModel{DB: db}
+
+	job, err := jobs.Insert(models.Job{UserID: "user", Filename: "audio.mp3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Pipeline{
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Jobs:   jobs,
+	}
+
+	return p, job
+}
+
+func TestCompleteStructuredRepairs(t *testing.T) {
+	schema := summarySchema(models.SummaryTemplate{}, false, false)
+
+	valid := chatReply{content: `{"summary": "fixed"}`}
+	invalid := chatReply{content: `{"summary": 1}`}
+
+	tests := []struct {
+		name     string
+		replies  []chatReply
+		want     string
+		wantErr  error
+		wantText string
+	}{
+		{
+			name:    "valid first time",
+			replies: []chatReply{valid},
+			want:    "fixed",
+		},
+		{
+			name:    "repaired",
+			replies: []chatReply{invalid, valid},
+			want:    "fixed",
+		},
+		{
+			name:    "repaired on the last attempt",
+			replies: []chatReply{invalid, {content: "not JSON"}, valid},
+			want:    "fixed",
+		},
+		{
+			name:     "still invalid",
+			replies:  []chatReply{invalid, invalid, invalid},
+			wantText: "the summary was still invalid after 3 attempts",
+		},
+		{
+			name:    "refused",
+			replies: []chatReply{{refusal: "I can't help with that"}},
+			wantErr: ErrSummaryRefused,
+		},
+		{
+			name:    "filtered",
+			replies: []chatReply{{finishReason: "content_filter"}},
+			wantErr: ErrSummaryFiltered,
+		},
+		{
+			name:    "truncated",
+			replies: []chatReply{{content: `{"summ`, finishReason: "length"}},
+			wantErr: ErrSummaryTruncated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, job := newTestPipeline(t)
+			requests := fakeOpenAI(t, tt.replies...)
+
+			result, err := p.completeStructured(job, "system", "transcript", schema)
+
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("got error %v; want %v", err, tt.wantErr)
+				}
+			case tt.wantText != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantText) {
+					t.Errorf("got error %v; want one containing %q", err, tt.wantText)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case result.Summary != tt.want:
+				t.Errorf("got summary %q; want %q", result.Summary, tt.want)
+			}
+
+			if len(*requests) != len(tt.replies) {
+				t.Errorf("made %d requests; want %d", len(*requests), len(tt.replies))
+			}
+
+			// Each repair request carries the original prompt, the last
+			// invalid reply and what was wrong with it.
+			for i, request := range (*requests)[1:] {
+				messages := request.Messages
+				if len(messages) != 4 || messages[1].Content != "transcript" ||
+					messages[2].Content != tt.replies[i].content ||
+					!strings.HasPrefix(messages[3].Content, "That reply is invalid: ") {
+					t.Errorf("repair request %d has messages %+v", i+1, messages)
+				}
+			}
+
+			got, err := p.Jobs.Get(job.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Usage.PromptTokens != 10*len(tt.replies) {
+				t.Errorf("recorded %d prompt tokens; want those of every attempt, %d", got.Usage.PromptTokens, 10*len(tt.replies))
+			}
+		})
+	}
+}
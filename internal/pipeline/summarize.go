@@ -0,0 +1,369 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+)
+
+// maxChunkTokens is the most transcript sent to the chat model at once. The
+// model writes the transcript back out as paragraphs, so this is bounded by
+// its output limit rather than its much larger context window.
+const maxChunkTokens = 6000
+
+// defaultSummaryConcurrency is how many chunks are summarized at once when
+// the Pipeline doesn't say.
+const defaultSummaryConcurrency = 3
+
+// minChunkTokens is the smallest chunk that is split again when the model's
+// reply to it is cut off.
+const minChunkTokens = 500
+
+// summarizeTranscript formats and summarizes the job's transcript, however
+// long it is.
+// Transcripts too long for one request are split into chunks that are
+// summarized concurrently and then merged into a single result. Long
+// recordings are also divided into chapters, which is done chunk by chunk
+// from timestamps marked in the transcript.
+func (p *Pipeline) summarizeTranscript(job models.Job, summaryLanguage string, template models.SummaryTemplate) (ResponseSchemaForNotion, error) {
+	segments, err := JobSegments(job)
+	if err != nil {
+		return ResponseSchemaForNotion{}, err
+	}
+
+	transcript := job.Transcript
+	// Templates written before chapters were timed could have a field of
+	// the same name, which takes their place.
+	withChapters := wantsChapters(segments) && !slices.ContainsFunc(template.Fields, func(field models.TemplateField) bool {
+		return field.Key == "chapters"
+	})
+	if withChapters {
+		transcript = markTimestamps(transcript, segments)
+	}
+
+	chunks := splitTranscript(transcript, maxChunkTokens)
+
+	chunkPartials := make([][]ResponseSchemaForNotion, len(chunks))
+
+	var (
+		mu   sync.Mutex
+		done int
+	)
+
+	err = p.forEachConcurrently(len(chunks), func(i int) error {
+		var err error
+		chunkPartials[i], err = p.summarizeChunk(job, chunks[i], summaryLanguage, template, withChapters, i+1, len(chunks))
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		done++
+		p.progress(job, done, len(chunks))
+		mu.Unlock()
+
+		return nil
+	})
+	if err != nil {
+		return ResponseSchemaForNotion{}, err
+	}
+
+	partials := slices.Concat(chunkPartials...)
+
+	if withChapters {
+		for i := range partials {
+			partials[i].LogicalParagraphs = stripTimestamps(partials[i].LogicalParagraphs)
+		}
+	}
+
+	if len(partials) == 1 {
+		return partials[0], nil
+	}
+	p.Logger.Debug("Merging chunk summaries", "job", job.ID, "chunks", len(partials))
+
+	paragraphs := make([]string, len(partials))
+	var chapters []Chapter
+	for i, partial := range partials {
+		paragraphs[i] = partial.LogicalParagraphs
+		chapters = append(chapters, partial.Chapters...)
+	}
+
+	result, err := p.reduceSummaries(job, partials, summaryLanguage, template)
+	if err != nil {
+		return ResponseSchemaForNotion{}, err
+	}
+	result.LogicalParagraphs = strings.Join(paragraphs, "\n\n")
+	result.Chapters = chapters
+
+	return result, nil
+}
+
+// summarizeChunk summarizes one chunk of a transcript. If the model's reply
+// is cut off, which happens when a chunk's paragraphs don't fit in its output,
+// the chunk is split in half and each half is summarized on its own.
+func (p *Pipeline) summarizeChunk(job models.Job, chunk string, summaryLanguage string, template models.SummaryTemplate, withChapters bool, part int, parts int) ([]ResponseSchemaForNotion, error) {
+	result, err := p.formatAndSummarizeTranscription(job, chunk, summaryLanguage, template, withChapters, part, parts)
+	if err == nil {
+		return []ResponseSchemaForNotion{result}, nil
+	}
+
+	tokens := estimateTokens(chunk)
+	if !errors.Is(err, ErrSummaryTruncated) || tokens < minChunkTokens*2 {
+		return nil, err
+	}
+
+	halves := splitTranscript(chunk, (tokens+1)/2)
+	if len(halves) < 2 {
+		return nil, err
+	}
+	p.Logger.Debug("Splitting truncated chunk", "part", part, "tokens", tokens)
+
+	var partials []ResponseSchemaForNotion
+	for _, half := range halves {
+		halfPartials, err := p.summarizeChunk(job, half, summaryLanguage, template, withChapters, part, parts)
+		if err != nil {
+			return nil, err
+		}
+		partials = append(partials, halfPartials...)
+	}
+
+	return partials, nil
+}
+
+// reduceSummaries merges partial summaries until one is left. Partials are
+// merged in groups small enough for one request, so a very long recording
+// may take several rounds.
+func (p *Pipeline) reduceSummaries(job models.Job, partials []ResponseSchemaForNotion, summaryLanguage string, template models.SummaryTemplate) (ResponseSchemaForNotion, error) {
+	for len(partials) > 1 {
+		groups, err := groupPartials(partials, maxChunkTokens)
+		if err != nil {
+			return ResponseSchemaForNotion{}, err
+		}
+
+		merged := make([]ResponseSchemaForNotion, len(groups))
+
+		err = p.forEachConcurrently(len(groups), func(i int) error {
+			if len(groups[i]) == 1 {
+				merged[i] = groups[i][0]
+				return nil
+			}
+
+			b, err := json.Marshal(groups[i])
+			if err != nil {
+				return err
+			}
+
+			merged[i], err = p.mergeSummaries(job, string(b), summaryLanguage, template)
+			return err
+		})
+		if err != nil {
+			return ResponseSchemaForNotion{}, err
+		}
+
+		partials = merged
+	}
+
+	return partials[0], nil
+}
+
+// groupPartials splits partial summaries, without their paragraphs or
+// chapters, into consecutive groups that fit within maxTokens. Every group
+// has at least two partials, even if that goes over, so that each round
+// makes progress.
+func groupPartials(partials []ResponseSchemaForNotion, maxTokens int) ([][]ResponseSchemaForNotion, error) {
+	var (
+		groups [][]ResponseSchemaForNotion
+		group  []ResponseSchemaForNotion
+		tokens int
+	)
+
+	for _, partial := range partials {
+		partial.LogicalParagraphs = ""
+		partial.Chapters = nil
+
+		b, err := json.Marshal(partial)
+		if err != nil {
+			return nil, err
+		}
+		size := estimateTokens(string(b))
+
+		if len(group) >= 2 && tokens+size > maxTokens {
+			groups = append(groups, group)
+			group, tokens = nil, 0
+		}
+
+		group = append(group, partial)
+		tokens += size
+	}
+
+	if len(group) == 1 && len(groups) > 0 {
+		groups[len(groups)-1] = append(groups[len(groups)-1], group[0])
+	} else if len(group) > 0 {
+		groups = append(groups, group)
+	}
+
+	return groups, nil
+}
+
+// splitTranscript breaks a transcript into chunks of at most maxTokens,
+// breaking between sentences where it can, between words where a single
+// sentence is too long and between characters where a single word is, as
+// happens in languages written without spaces.
+func splitTranscript(transcript string, maxTokens int) []string {
+	if estimateTokens(transcript) <= maxTokens {
+		return []string{transcript}
+	}
+
+	limit := maxTokens * 4
+
+	var (
+		chunks []string
+		chunk  strings.Builder
+		weight int
+	)
+
+	flush := func() {
+		if s := strings.TrimSpace(chunk.String()); s != "" {
+			chunks = append(chunks, s)
+		}
+		chunk.Reset()
+		weight = 0
+	}
+
+	add := func(piece string) {
+		w := tokenWeight(piece)
+		if weight > 0 && weight+tokenWeight(strings.TrimSpace(piece)) > limit {
+			flush()
+		}
+
+		chunk.WriteString(piece)
+		weight += w
+	}
+
+	for _, sentence := range splitSentences(transcript) {
+		if tokenWeight(strings.TrimSpace(sentence)) <= limit {
+			add(sentence)
+			continue
+		}
+
+		for _, word := range splitWords(sentence) {
+			if tokenWeight(strings.TrimSpace(word)) <= limit {
+				add(word)
+				continue
+			}
+
+			for _, r := range word {
+				add(string(r))
+			}
+		}
+	}
+	flush()
+
+	return chunks
+}
+
+// splitSentences splits text after each '.', '!' or '?' that is followed by
+// whitespace, and after each full-width '。', '！' or '？', which needn't be.
+// Each sentence keeps the whitespace that follows it.
+func splitSentences(text string) []string {
+	var (
+		sentences []string
+		start     int
+		terminal  bool
+		ended     bool
+	)
+
+	for i, r := range text {
+		if ended && !unicode.IsSpace(r) {
+			sentences = append(sentences, text[start:i])
+			start = i
+			terminal, ended = false, false
+		}
+
+		switch {
+		case strings.ContainsRune("。！？", r):
+			ended = true
+		case strings.ContainsRune(".!?", r):
+			terminal = true
+		case unicode.IsSpace(r):
+			ended = ended || terminal
+		default:
+			terminal = false
+		}
+	}
+
+	if start < len(text) {
+		sentences = append(sentences, text[start:])
+	}
+
+	return sentences
+}
+
+// splitWords splits text after each run of whitespace. Each word keeps the
+// whitespace that follows it.
+func splitWords(text string) []string {
+	var (
+		words   []string
+		start   int
+		inSpace bool
+	)
+
+	for i, r := range text {
+		if !unicode.IsSpace(r) && inSpace {
+			words = append(words, text[start:i])
+			start = i
+		}
+		inSpace = unicode.IsSpace(r)
+	}
+
+	if start < len(text) {
+		words = append(words, text[start:])
+	}
+
+	return words
+}
+
+// forEachConcurrently calls fn for 0 to n-1, running at most the Pipeline's
+// SummaryConcurrency at once, and returns the first error.
+func (p *Pipeline) forEachConcurrently(n int, fn func(i int) error) error {
+	limit := p.SummaryConcurrency
+	if limit < 1 {
+		limit = defaultSummaryConcurrency
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+
+	sem := make(chan struct{}, limit)
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			err := fn(i)
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return firstErr
+}
@@ -0,0 +1,216 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"hello world", 3},
+		{"Hello, world.", 5},
+		{"if (x) { return y; }", 9},
+		{"Привет, мир", 11},
+		{"今天开会", 6},
+		{"👍", 2},
+	}
+
+	for _, tt := range tests {
+		if got := estimateTokens(tt.text); got != tt.want {
+			t.Errorf("estimateTokens(%q) = %d; want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSplitTranscript(t *testing.T) {
+	tests := []struct {
+		name       string
+		transcript string
+		maxTokens  int
+		want       []string
+	}{
+		{
+			name:       "fits",
+			transcript: "Short enough.  Kept  as it is.",
+			maxTokens:  100,
+			want:       []string{"Short enough.  Kept  as it is."},
+		},
+		{
+			name:       "between sentences",
+			transcript: "One two. Three four. Five six.",
+			maxTokens:  7,
+			want:       []string{"One two. Three four.", "Five six."},
+		},
+		{
+			name:       "questions and exclamations",
+			transcript: "Is it? Yes it is! Good then.",
+			maxTokens:  7,
+			want:       []string{"Is it? Yes it is!", "Good then."},
+		},
+		{
+			name:       "long sentence between words",
+			transcript: "alpha beta gamma delta epsilon zeta",
+			maxTokens:  7,
+			want:       []string{"alpha beta gamma delta", "epsilon zeta"},
+		},
+		{
+			name:       "long sentence after a short one",
+			transcript: "Hi. alpha beta gamma delta epsilon zeta eta.",
+			maxTokens:  7,
+			want:       []string{"Hi. alpha beta gamma", "delta epsilon zeta eta."},
+		},
+		{
+			name:       "whitespace between chunks",
+			transcript: "One two.\n\nThree four.   Five six.",
+			maxTokens:  7,
+			want:       []string{"One two.\n\nThree four.", "Five six."},
+		},
+		{
+			name:       "full-width sentence endings",
+			transcript: "今天开会。我们讨论预算！下周再见？",
+			maxTokens:  11,
+			want:       []string{"今天开会。", "我们讨论预算！", "下周再见？"},
+		},
+		{
+			name:       "no spaces or punctuation",
+			transcript: "这是一个没有标点的很长的句子",
+			maxTokens:  8,
+			want:       []string{"这是一个没", "有标点的很", "长的句子"},
+		},
+		{
+			name:       "Cyrillic",
+			transcript: "Как дела? Хорошо, спасибо.",
+			maxTokens:  16,
+			want:       []string{"Как дела?", "Хорошо, спасибо."},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitTranscript(tt.transcript, tt.maxTokens)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("splitTranscript(%q, %d) = %q; want %q", tt.transcript, tt.maxTokens, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitTranscriptKeepsEverything(t *testing.T) {
+	tests := []struct {
+		name     string
+		sentence string
+		sep      string
+	}{
+		{"English", "Sentence number %d says something %s.", " "},
+		{"Russian", "Предложение номер %d говорит что-то %s.", " "},
+		{"Japanese", "これは%d番目の文で、%sです。", ""},
+		{"Chinese without punctuation", "第%d句话%s", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sentences []string
+			for i := range 200 {
+				sentences = append(sentences, fmt.Sprintf(tt.sentence, i, strings.Repeat("very ", i%7)))
+			}
+			transcript := strings.Join(sentences, tt.sep)
+
+			chunks := splitTranscript(transcript, 50)
+			if len(chunks) < 2 {
+				t.Fatalf("got %d chunks; want the transcript split", len(chunks))
+			}
+
+			for i, chunk := range chunks {
+				if n := estimateTokens(chunk); n > 50 {
+					t.Errorf("chunk %d is about %d tokens; want at most 50", i, n)
+				}
+			}
+
+			// Only the whitespace between chunks is lost.
+			joined := strings.Join(chunks, "")
+			if want := strings.Join(strings.Fields(transcript), ""); strings.Join(strings.Fields(joined), "") != want {
+				t.Errorf("the chunks don't join back into the transcript")
+			}
+		})
+	}
+}
+
+func TestGroupPartials(t *testing.T) {
+	partial := ResponseSchemaForNotion{
+		LogicalParagraphs: strings.Repeat("paragraph ", 100),
+		Summary:           "summary 00",
+		Chapters:          []Chapter{{Title: "Intro", Start: "0:00", Summary: "c"}},
+		Sections:          map[string][]string{"action_items": {"one", "two"}},
+	}
+
+	// The size of each partial once its paragraphs and chapters are left
+	// out. Their summaries are numbered, but all the same length.
+	stripped := partial
+	stripped.LogicalParagraphs = ""
+	stripped.Chapters = nil
+	b, err := json.Marshal(stripped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := estimateTokens(string(b))
+
+	tests := []struct {
+		name      string
+		partials  int
+		maxTokens int
+		want      []int
+	}{
+		{"one partial", 1, size * 10, []int{1}},
+		{"all fit", 4, size * 10, []int{4}},
+		{"two per group", 4, size * 2, []int{2, 2}},
+		{"leftover joins the last group", 5, size * 2, []int{2, 3}},
+		{"three per group", 7, size * 3, []int{3, 4}},
+		{"at least two even if too big", 4, size / 2, []int{2, 2}},
+		{"none", 0, size, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			partials := make([]ResponseSchemaForNotion, tt.partials)
+			for i := range partials {
+				partials[i] = partial
+				partials[i].Summary = fmt.Sprintf("summary %02d", i)
+			}
+
+			groups, err := groupPartials(partials, tt.maxTokens)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var sizes []int
+			var summaries []string
+			for _, group := range groups {
+				sizes = append(sizes, len(group))
+				for _, p := range group {
+					if p.LogicalParagraphs != "" || p.Chapters != nil {
+						t.Errorf("partial %q still has its paragraphs or chapters", p.Summary)
+					}
+					if len(p.Sections["action_items"]) != 2 {
+						t.Errorf("partial %q lost its sections", p.Summary)
+					}
+					summaries = append(summaries, p.Summary)
+				}
+			}
+
+			if !slices.Equal(sizes, tt.want) {
+				t.Errorf("got groups of %v; want %v", sizes, tt.want)
+			}
+
+			if !slices.IsSorted(summaries) || len(summaries) != tt.partials {
+				t.Errorf("got partials %v; want all of them in order", summaries)
+			}
+		})
+	}
+}
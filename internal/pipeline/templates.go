@@ -0,0 +1,217 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+)
+
+// The Notion block types a template field can be published as. Markdown
+// fields are converted into whichever blocks their Markdown describes.
+const (
+	BlockParagraph    = "paragraph"
+	BlockBulletedList = "bulleted_list_item"
+	BlockNumberedList = "numbered_list_item"
+	BlockQuote        = "quote"
+	BlockToDo         = "to_do"
+	BlockCallout      = "callout"
+	BlockMarkdown     = "markdown"
+)
+
+// The ways a template can lay out its Notion page. The classic layout gives
+// every section a heading, transcript first. The collapsible layout puts
+// the summary and fields in a callout at the top and the transcript in a
+// toggle heading below it.
+const (
+	LayoutClassic     = "classic"
+	LayoutCollapsible = "collapsible"
+)
+
+// TemplateLayouts lists the layouts in the order they're offered.
+var TemplateLayouts = []string{LayoutClassic, LayoutCollapsible}
+
+// The sections of a page other than a template's fields, as named in a
+// template's Order.
+const (
+	SectionTranscript = "transcript"
+	SectionSummary    = "summary"
+)
+
+// DefaultTemplateKey names the built-in template used when none is chosen.
+const DefaultTemplateKey = "general"
+
+// TemplateBlocks lists the block types in the order they're offered.
+var TemplateBlocks = []string{BlockParagraph, BlockBulletedList, BlockNumberedList, BlockQuote, BlockToDo, BlockCallout, BlockMarkdown}
+
+// maxTemplateFields keeps custom schemas small enough for the model to fill
+// in reliably.
+const maxTemplateFields = 10
+
+var templateFieldKey = regexp.MustCompile(`^[a-z][a-z0-9_]{0,39}$`)
+
+var actionItemsField = models.TemplateField{
+	Key:         "action_items",
+	Description: "The action items that were agreed on, each naming who is responsible if that was said",
+	Heading:     "Action Items",
+	Block:       BlockToDo,
+}
+
+// BuiltinTemplates are available to everyone. The first is the default.
+var BuiltinTemplates = []models.SummaryTemplate{
+	{
+		Key:    DefaultTemplateKey,
+		Name:   "General summary",
+		Prompt: "Then you will create a summary of the transcription. Finally, you will list any action items that were agreed on.",
+		Fields: []models.TemplateField{actionItemsField},
+	},
+	{
+		Key:  "meeting-minutes",
+		Name: "Meeting minutes",
+		Prompt: "The transcription is of a meeting. Write a summary of what was discussed as formal minutes, " +
+			"then list the decisions that were made, the questions that were left open and the action items.",
+		Fields: []models.TemplateField{
+			{Key: "decisions", Description: "Each decision the meeting reached", Heading: "Decisions", Block: BlockBulletedList},
+			{Key: "open_questions", Description: "Questions raised but not resolved", Heading: "Open Questions", Block: BlockBulletedList},
+			actionItemsField,
+		},
+	},
+	{
+		Key:  "lecture-notes",
+		Name: "Lecture notes",
+		Prompt: "The transcription is of a lecture. Write a summary a student could revise from, " +
+			"then list the key concepts with a short explanation of each, any terms that were defined, and questions to test understanding.",
+		Fields: []models.TemplateField{
+			{Key: "key_concepts", Description: "Each key concept followed by a one or two sentence explanation", Heading: "Key Concepts", Block: BlockBulletedList},
+			{Key: "definitions", Description: "Each term that was defined, as \"term: definition\"", Heading: "Definitions", Block: BlockBulletedList},
+			{Key: "review_questions", Description: "Questions that test understanding of the material", Heading: "Review Questions", Block: BlockNumberedList},
+		},
+	},
+	{
+		Key:  "interview",
+		Name: "Interview",
+		Prompt: "The transcription is of an interview. Summarize what was learned from the interviewee, " +
+			"then list the main themes and the most telling quotes, verbatim.",
+		Fields: []models.TemplateField{
+			{Key: "themes", Description: "The main themes of the interview", Heading: "Themes", Block: BlockBulletedList},
+			{Key: "key_quotes", Description: "Notable quotes from the interviewee, word for word", Heading: "Key Quotes", Block: BlockQuote},
+		},
+	},
+	{
+		Key:  "podcast",
+		Name: "Podcast episode",
+		Prompt: "The transcription is of a podcast episode. Summarize the episode for someone deciding whether to listen, " +
+			"then list the topics it covers in order, memorable quotes and any people, books, products or links mentioned.",
+		Fields: []models.TemplateField{
+			{Key: "topics", Description: "The topics of the episode in order, each as a short title", Heading: "Topics", Block: BlockNumberedList},
+			{Key: "key_quotes", Description: "Memorable quotes, word for word", Heading: "Key Quotes", Block: BlockQuote},
+			{Key: "mentions", Description: "People, books, products and links mentioned", Heading: "Mentioned", Block: BlockBulletedList},
+		},
+	},
+}
+
+// BuiltinTemplate returns the built-in template with the given key.
+func BuiltinTemplate(key string) (models.SummaryTemplate, bool) {
+	for _, t := range BuiltinTemplates {
+		if t.Key == key {
+			return t, true
+		}
+	}
+
+	return models.SummaryTemplate{}, false
+}
+
+// TemplateOrder returns the sections of a template's page in order. Any
+// sections its Order leaves out follow in their usual order: the transcript
+// before everything else in the classic layout and after it in the
+// collapsible one, with the summary before the fields.
+func TemplateOrder(t models.SummaryTemplate) []string {
+	sections := []string{SectionSummary}
+	for _, field := range t.Fields {
+		sections = append(sections, field.Key)
+	}
+
+	if t.Layout == LayoutCollapsible {
+		sections = append(sections, SectionTranscript)
+	} else {
+		sections = append([]string{SectionTranscript}, sections...)
+	}
+
+	order := slices.Clone(t.Order)
+	for _, section := range sections {
+		if !slices.Contains(order, section) {
+			order = append(order, section)
+		}
+	}
+
+	return order
+}
+
+// ValidateTemplate checks a custom template before it is saved.
+func ValidateTemplate(t models.SummaryTemplate) error {
+	switch {
+	case t.Name == "":
+		return errors.New("give the template a name")
+	case t.Prompt == "":
+		return errors.New("describe what the summary should contain")
+	case len(t.Fields) > maxTemplateFields:
+		return fmt.Errorf("templates can have at most %d fields", maxTemplateFields)
+	}
+
+	seen := map[string]bool{}
+
+	for _, field := range t.Fields {
+		switch {
+		case !templateFieldKey.MatchString(field.Key):
+			return fmt.Errorf("field key %q must be lowercase letters, digits and underscores", field.Key)
+		case field.Key == "logical_paragraphs" || field.Key == "summary" || field.Key == "chapters" || field.Key == SectionTranscript:
+			return fmt.Errorf("field key %q is reserved", field.Key)
+		case seen[field.Key]:
+			return fmt.Errorf("field key %q is used more than once", field.Key)
+		case field.Heading == "":
+			return fmt.Errorf("field %q needs a heading", field.Key)
+		case !slices.Contains(TemplateBlocks, field.Block):
+			return fmt.Errorf("field %q has an unknown block type %q", field.Key, field.Block)
+		}
+		seen[field.Key] = true
+	}
+
+	if t.Layout != "" && !slices.Contains(TemplateLayouts, t.Layout) {
+		return fmt.Errorf("unknown layout %q", t.Layout)
+	}
+
+	placed := map[string]bool{}
+
+	for _, section := range t.Order {
+		switch {
+		case section != SectionTranscript && section != SectionSummary && !seen[section]:
+			return fmt.Errorf("the order names %q, which isn't the transcript, the summary or one of the fields", section)
+		case placed[section]:
+			return fmt.Errorf("the order names %q more than once", section)
+		}
+		placed[section] = true
+	}
+
+	return nil
+}
+
+// JobTemplate returns the template the job was submitted with. Jobs from
+// before templates existed use the default.
+func JobTemplate(job models.Job) (models.SummaryTemplate, error) {
+	if job.Template == "" {
+		t, _ := BuiltinTemplate(DefaultTemplateKey)
+		return t, nil
+	}
+
+	var t models.SummaryTemplate
+
+	err := json.Unmarshal([]byte(job.Template), &t)
+	if err != nil {
+		return models.SummaryTemplate{}, err
+	}
+
+	return t, nil
+}
@@ -0,0 +1,74 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+)
+
+func TestValidateTemplate(t *testing.T) {
+	valid := func() models.SummaryTemplate {
+		return models.SummaryTemplate{
+			Name:   "Stand-up",
+			Prompt: "Summarize what each person is working on.",
+			Fields: []models.TemplateField{
+				{Key: "blockers", Description: "Anything holding people up", Heading: "Blockers", Block: BlockBulletedList},
+				{Key: "follow_up_2", Heading: "Follow-ups", Block: BlockToDo},
+			},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		edit    func(*models.SummaryTemplate)
+		wantErr string
+	}{
+		{"valid", func(*models.SummaryTemplate) {}, ""},
+		{"no fields", func(t *models.SummaryTemplate) { t.Fields = nil }, ""},
+		{"no name", func(t *models.SummaryTemplate) { t.Name = "" }, "name"},
+		{"no prompt", func(t *models.SummaryTemplate) { t.Prompt = "" }, "summary should contain"},
+		{"too many fields", func(t *models.SummaryTemplate) {
+			t.Fields = nil
+			for i := range maxTemplateFields + 1 {
+				t.Fields = append(t.Fields, models.TemplateField{Key: fmt.Sprintf("field_%d", i), Heading: "Field", Block: BlockParagraph})
+			}
+		}, "at most"},
+		{"uppercase key", func(t *models.SummaryTemplate) { t.Fields[0].Key = "Blockers" }, "lowercase"},
+		{"key starting with a digit", func(t *models.SummaryTemplate) { t.Fields[0].Key = "2nd" }, "lowercase"},
+		{"key with spaces", func(t *models.SummaryTemplate) { t.Fields[0].Key = "follow up" }, "lowercase"},
+		{"key too long", func(t *models.SummaryTemplate) { t.Fields[0].Key = strings.Repeat("a", 41) }, "lowercase"},
+		{"reserved key", func(t *models.SummaryTemplate) { t.Fields[0].Key = "summary" }, "reserved"},
+		{"transcript key", func(t *models.SummaryTemplate) { t.Fields[0].Key = "logical_paragraphs" }, "reserved"},
+		{"duplicate key", func(t *models.SummaryTemplate) { t.Fields[1].Key = t.Fields[0].Key }, "more than once"},
+		{"no heading", func(t *models.SummaryTemplate) { t.Fields[1].Heading = "" }, "heading"},
+		{"unknown block", func(t *models.SummaryTemplate) { t.Fields[0].Block = "table" }, "block type"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template := valid()
+			tt.edit(&template)
+
+			err := ValidateTemplate(template)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("got error %q; want none", err)
+			case tt.wantErr != "" && err == nil:
+				t.Errorf("got no error; want one mentioning %q", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Errorf("got error %q; want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBuiltinTemplatesAreValid(t *testing.T) {
+	for _, template := range BuiltinTemplates {
+		err := ValidateTemplate(template)
+		if err != nil {
+			t.Errorf("built-in template %q: %v", template.Key, err)
+		}
+	}
+}
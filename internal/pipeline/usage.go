@@ -0,0 +1,97 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+)
+
+const (
+	transcriptionModel = "whisper-1"
+	chatModel          = "gpt-4o-mini"
+)
+
+// Prices is the price table used to work out what each job costs, in US
+// dollars.
+type Prices struct {
+	// Transcription is the price of a minute of audio, by model.
+	Transcription map[string]float64 `json:"transcription"`
+
+	// Chat is the price of a million tokens, by model.
+	Chat map[string]TokenPrices `json:"chat"`
+}
+
+type TokenPrices struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// DefaultPrices are OpenAI's list prices for the models the pipeline uses.
+var DefaultPrices = Prices{
+	Transcription: map[string]float64{
+		transcriptionModel: 0.006,
+	},
+	Chat: map[string]TokenPrices{
+		chatModel: {Input: 0.15, Output: 0.60},
+	},
+}
+
+// LoadPrices reads a JSON price table from path. Models it doesn't mention
+// keep their DefaultPrices.
+func LoadPrices(path string) (Prices, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Prices{}, err
+	}
+
+	var overrides Prices
+
+	err = json.Unmarshal(b, &overrides)
+	if err != nil {
+		return Prices{}, err
+	}
+
+	prices := Prices{
+		Transcription: map[string]float64{},
+		Chat:          map[string]TokenPrices{},
+	}
+	for _, table := range []Prices{DefaultPrices, overrides} {
+		for model, price := range table.Transcription {
+			prices.Transcription[model] = price
+		}
+		for model, price := range table.Chat {
+			prices.Chat[model] = price
+		}
+	}
+
+	return prices, nil
+}
+
+func (p *Pipeline) prices() Prices {
+	if p.Prices.Transcription == nil && p.Prices.Chat == nil {
+		return DefaultPrices
+	}
+	return p.Prices
+}
+
+// recordTranscriptionUsage adds the audio sent to Whisper, and its cost, to
+// the job.
+func (p *Pipeline) recordTranscriptionUsage(job models.Job, seconds float64) error {
+	return p.Jobs.AddUsage(job.ID, models.Usage{
+		AudioSeconds: seconds,
+		Cost:         seconds / 60 * p.prices().Transcription[transcriptionModel],
+	})
+}
+
+// recordChatUsage adds the tokens used by a chat completion, and their cost,
+// to the job.
+func (p *Pipeline) recordChatUsage(job models.Job, usage ChatUsage) error {
+	price := p.prices().Chat[chatModel]
+
+	return p.Jobs.AddUsage(job.ID, models.Usage{
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		Cost:             (float64(usage.PromptTokens)*price.Input + float64(usage.CompletionTokens)*price.Output) / 1_000_000,
+	})
+}
@@ -0,0 +1,115 @@
+package pipeline
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+)
+
+// whisperPromptTokens is the most prompt Whisper will consider. Anything
+// beyond it is silently dropped, so the glossary is trimmed to fit.
+const whisperPromptTokens = 224
+
+// estimateTokens approximates how many tokens OpenAI's tokenizers will split
+// s into. It errs on the high side, so that whatever is sized with it fits.
+func estimateTokens(s string) int {
+	return (tokenWeight(s) + 3) / 4
+}
+
+// tokenWeight estimates the tokens in s in quarters of a token. English
+// words average about four characters a token, so ASCII letters, digits and
+// whitespace count a quarter each. ASCII punctuation is often a token of its
+// own, as in code, so it counts as one. The tokenizers work on UTF-8 bytes
+// and other scripts get far fewer characters per token: Cyrillic, Greek and
+// accented letters take two bytes and count as one token, CJK characters
+// take three and count as one and a half, and emoji take four and count as
+// two. Real counts for prose in any of these are lower.
+func tokenWeight(s string) int {
+	weight := 0
+
+	for _, r := range s {
+		switch {
+		case r >= utf8.RuneSelf:
+			weight += 2 * utf8.RuneLen(r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r):
+			weight++
+		default:
+			weight += 4
+		}
+	}
+
+	return weight
+}
+
+// whisperPrompt lists the glossary terms as a prompt that nudges Whisper
+// towards spelling them correctly, keeping as many as fit in the limit.
+func whisperPrompt(terms []models.GlossaryTerm) string {
+	var prompt strings.Builder
+
+	for _, term := range terms {
+		next := term.Term
+		if prompt.Len() > 0 {
+			next = ", " + next
+		}
+
+		if estimateTokens(prompt.String()+next) > whisperPromptTokens {
+			break
+		}
+		prompt.WriteString(next)
+	}
+
+	return prompt.String()
+}
+
+// applyGlossary replaces the known misspellings of each glossary term in
+// the transcript with the term itself. Matches are case-insensitive and
+// only on whole words, so "Jen" won't touch "Jenkins". This catches what
+// the prompt misses and works for providers that don't accept one.
+func applyGlossary(transcript string, terms []models.GlossaryTerm) string {
+	for _, term := range terms {
+		for _, misspelling := range term.Misspellings {
+			if misspelling == "" || misspelling == term.Term {
+				continue
+			}
+
+			re, err := regexp.Compile(`(?i)` + wordBoundary(misspelling, true) +
+				regexp.QuoteMeta(misspelling) + wordBoundary(misspelling, false))
+			if err != nil {
+				continue
+			}
+
+			transcript = re.ReplaceAllLiteralString(transcript, term.Term)
+		}
+	}
+
+	return transcript
+}
+
+// wordBoundary returns \b for the start or end of s when that end is a word
+// character. Terms like "C++" end in punctuation, where \b would never match
+// before the following space.
+func wordBoundary(s string, start bool) string {
+	var r rune
+	if start {
+		r, _ = utf8.DecodeRuneInString(s)
+	} else {
+		r, _ = utf8.DecodeLastRuneInString(s)
+	}
+
+	if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+		return `\b`
+	}
+
+	return ""
+}
+
+func (p *Pipeline) glossaryFor(job models.Job) ([]models.GlossaryTerm, error) {
+	if p.Glossary == nil {
+		return nil, nil
+	}
+
+	return p.Glossary.ForUser(job.UserID)
+}
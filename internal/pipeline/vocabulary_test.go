@@ -0,0 +1,76 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/derekhassan/transcribe-to-notion/internal/models"
+)
+
+func TestApplyGlossary(t *testing.T) {
+	terms := []models.GlossaryTerm{
+		{Term: "Jenn", Misspellings: []string{"Jen", "Gen"}},
+		{Term: "C++", Misspellings: []string{"C plus plus", "see++"}},
+		{Term: "Kubernetes", Misspellings: []string{"", "Kubernetes", "cooper netties"}},
+	}
+
+	tests := []struct {
+		name       string
+		transcript string
+		want       string
+	}{
+		{"whole words", "Jen and Gen met.", "Jenn and Jenn met."},
+		{"not inside other words", "Jenkins generated a report for Jen.", "Jenkins generated a report for Jenn."},
+		{"case-insensitive", "JEN said jen.", "Jenn said Jenn."},
+		{"spanning words", "We write C plus plus at work.", "We write C++ at work."},
+		{"ending in punctuation", "I like see++, mostly.", "I like C++, mostly."},
+		{"punctuation at the end of the transcript", "I like see++", "I like C++"},
+		{"empty and unchanged misspellings are ignored", "Cooper Netties runs Kubernetes.", "Kubernetes runs Kubernetes."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := applyGlossary(tt.transcript, terms)
+			if got != tt.want {
+				t.Errorf("applyGlossary(%q) = %q; want %q", tt.transcript, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWhisperPrompt(t *testing.T) {
+	if got := whisperPrompt(nil); got != "" {
+		t.Errorf("whisperPrompt(nil) = %q; want an empty prompt", got)
+	}
+
+	terms := []models.GlossaryTerm{{Term: "Jenn"}, {Term: "C++"}, {Term: "Kubernetes"}}
+	if got, want := whisperPrompt(terms), "Jenn, C++, Kubernetes"; got != want {
+		t.Errorf("whisperPrompt() = %q; want %q", got, want)
+	}
+
+	// Far more terms than fit, so the prompt stops at the last whole term
+	// within the limit.
+	terms = nil
+	for i := range 500 {
+		terms = append(terms, models.GlossaryTerm{Term: fmt.Sprintf("Term%d", i)})
+	}
+
+	got := whisperPrompt(terms)
+	if estimateTokens(got) > whisperPromptTokens {
+		t.Errorf("prompt is about %d tokens; want at most %d", estimateTokens(got), whisperPromptTokens)
+	}
+
+	kept := strings.Split(got, ", ")
+	if len(kept) == 0 || len(kept) == len(terms) {
+		t.Fatalf("kept %d of %d terms", len(kept), len(terms))
+	}
+	for i, term := range kept {
+		if term != terms[i].Term {
+			t.Fatalf("term %d is %q; want %q", i, term, terms[i].Term)
+		}
+	}
+	if next := got + ", " + terms[len(kept)].Term; estimateTokens(next) <= whisperPromptTokens {
+		t.Errorf("stopped after %d terms when the next still fitted", len(kept))
+	}
+}
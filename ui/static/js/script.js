@@ -0,0 +1,79 @@
+const jobElement = document.querySelector("[data-job-id]");
+
+if (jobElement) {
+    followJob(jobElement);
+}
+
+// Disable the upload button once pressed, so a double click doesn't send
+// the recording twice.
+const submitButton = document.querySelector("#submit-button");
+
+if (submitButton) {
+    submitButton.form.addEventListener("submit", () => {
+        submitButton.disabled = true;
+    });
+}
+
+function followJob(element) {
+    const stages = ["queued", "transcribing", "summarizing", "review", "publishing", "completed"];
+    const progress = element.querySelector(".job-progress");
+    const result = element.querySelector(".job-result");
+    const review = element.querySelector(".job-review");
+    const rerun = element.querySelector(".job-rerun");
+    const errorMessage = element.querySelector(".job-error");
+
+    function showStatus(status) {
+        element.dataset.status = status;
+
+        const current = stages.indexOf(status);
+        element.querySelectorAll("[data-stage]").forEach((stage) => {
+            const index = stages.indexOf(stage.dataset.stage);
+            stage.classList.toggle("job-stages__item--done", current > index || status === "completed");
+            stage.classList.toggle("job-stages__item--active", current === index && status !== "completed");
+        });
+
+        progress.hidden = true;
+        review.hidden = status !== "review";
+        rerun.hidden = status !== "completed" && status !== "failed";
+    }
+
+    showStatus(element.dataset.status);
+
+    if (element.dataset.status === "completed" || element.dataset.status === "failed") {
+        return;
+    }
+
+    // EventSource reconnects on its own and sends Last-Event-ID, so the
+    // server replays anything missed while disconnected.
+    const source = new EventSource(`/jobs/${element.dataset.jobId}/events`);
+
+    source.addEventListener("status", (event) => {
+        const data = JSON.parse(event.data);
+        showStatus(data.status);
+
+        if (data.notion_page_url) {
+            result.querySelector("a").href = data.notion_page_url;
+            result.hidden = false;
+        }
+
+        if (data.error) {
+            errorMessage.textContent = data.error;
+            errorMessage.hidden = false;
+        }
+
+        if (data.status === "completed" || data.status === "failed") {
+            source.close();
+        }
+    });
+
+    source.addEventListener("progress", (event) => {
+        const data = JSON.parse(event.data);
+        if (data.stage !== element.dataset.status || data.total <= 1) {
+            return;
+        }
+
+        progress.max = data.total;
+        progress.value = data.done;
+        progress.hidden = false;
+    });
+}